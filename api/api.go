@@ -19,7 +19,18 @@ type authorizer interface {
 }
 type handler func(ctx context.Context, msg json.RawMessage) request.ApiResponse
 
+// maxBody is the most a request body may be, and maxImport is the most for requests that carry a calendar
+const (
+	maxBody   = 1024 * 2
+	maxImport = 1024 * 64
+)
+
 func handlerWrapper(f handler, auth authorizer, allowGet, logRequest bool) func(w http.ResponseWriter, r *http.Request) {
+	return limitedHandlerWrapper(f, auth, allowGet, logRequest, maxBody)
+}
+
+// limitedHandlerWrapper is handlerWrapper for requests whose bodies may be up to limit bytes
+func limitedHandlerWrapper(f handler, auth authorizer, allowGet, logRequest bool, limit int64) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if rec := recover(); rec != nil {
@@ -37,7 +48,7 @@ func handlerWrapper(f handler, auth authorizer, allowGet, logRequest bool) func(
 			return
 		}
 
-		body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1024*2))
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, limit))
 		if err != nil {
 			logrus.WithError(err).Error("Error reading request body")
 			w.WriteHeader(http.StatusInternalServerError)
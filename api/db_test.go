@@ -19,4 +19,10 @@ func init() {
 	if _, err := db.DB.ExecContext(ctx, "delete from zone"); err != nil {
 		panic(err)
 	}
+	if _, err := db.DB.ExecContext(ctx, "delete from calibrationPoint"); err != nil {
+		panic(err)
+	}
+	if _, err := db.DB.ExecContext(ctx, "delete from calibration"); err != nil {
+		panic(err)
+	}
 }
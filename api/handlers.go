@@ -1,12 +1,17 @@
 package api
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/sirupsen/logrus"
 	"net/http"
-	"sort"
+	"strings"
 	"thermostat/api/request"
+	"thermostat/config"
+	"thermostat/db/calibration"
 	"thermostat/db/mode"
 	"thermostat/db/setting"
 	"thermostat/db/zone"
@@ -29,6 +34,77 @@ func zones(ctx context.Context, _ json.RawMessage) request.ApiResponse {
 	return request.NewResponse(http.StatusOK, string(msg))
 }
 
+// addZone adds a zone with its own default and custom modes, and a default schedule, and starts it. Its equipment and
+// sensor must already be configured under zones.<name>.
+func addZone(ctx context.Context, msg json.RawMessage) request.ApiResponse {
+	var data zone.Zone
+	if err := json.Unmarshal(msg, &data); err != nil {
+		return request.NewResponse(http.StatusBadRequest, err.Error())
+	}
+	if data.Name == "" {
+		return request.NewResponse(http.StatusBadRequest, "a zone name is required")
+	}
+	if _, err := zone.Get(ctx, data.Name); err == nil {
+		return request.NewResponse(http.StatusBadRequest, "zone "+data.Name+" already exists")
+	}
+
+	z, err := zone.New(ctx, data.Name)
+	if err != nil {
+		return request.NewResponse(http.StatusInternalServerError, err.Error())
+	}
+	if err := addZoneDefaults(ctx, z.ID); err != nil {
+		if err := z.Delete(ctx); err != nil {
+			logrus.WithError(err).WithField("zone", z.Name).Error("failed to remove partially added zone")
+		}
+		return request.NewResponse(http.StatusInternalServerError, err.Error())
+	}
+	if _, err := system.StartZone(ctx, z.Name); err != nil {
+		if err := z.Delete(ctx); err != nil {
+			logrus.WithError(err).WithField("zone", z.Name).Error("failed to remove zone that could not start")
+		}
+		return request.NewResponse(http.StatusBadRequest, err.Error())
+	}
+
+	return request.NewResponse(http.StatusOK, fmt.Sprintf(`{"id": %d}`, z.ID))
+}
+
+// addZoneDefaults gives a new zone default and custom modes from 60 to 85, and a default schedule in the default mode
+// that runs all the time
+func addZoneDefaults(ctx context.Context, zoneID int64) error {
+	m, err := mode.New(ctx, zoneID, "default", 60, 85, 1, mode.FanAuto, 0, 0, 0, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := mode.New(ctx, zoneID, "custom", 60, 85, 1, mode.FanAuto, 0, 0, 0, 0); err != nil {
+		return err
+	}
+	_, err = setting.New(ctx, zoneID, m.ID, setting.DEFAULT, 254, time.Unix(0, 0), time.Unix(106751991167, 0), 0, 86400)
+	return err
+}
+
+// pushSensor records a reading from a remote sensor configured under remoteSensors.<name>, and used by a zone or the
+// outdoor temperature
+func pushSensor(_ context.Context, msg json.RawMessage) request.ApiResponse {
+	var data struct {
+		Name string
+		system.RemoteReading
+	}
+	if err := json.Unmarshal(msg, &data); err != nil {
+		return request.NewResponse(http.StatusBadRequest, err.Error())
+	}
+
+	// the sensor keeps the time of the zone that built it
+	s, err := system.RunningRemoteSensor(data.Name)
+	if err != nil {
+		return request.NewResponse(http.StatusNotFound, err.Error())
+	}
+	if err := data.Push(s); err != nil {
+		return request.NewResponse(http.StatusBadRequest, err.Error())
+	}
+
+	return request.NewResponse(http.StatusOK, `{}`)
+}
+
 func status(ctx context.Context, msg json.RawMessage) request.ApiResponse {
 	var input struct {
 		ZoneID int64
@@ -47,13 +123,26 @@ func status(ctx context.Context, msg json.RawMessage) request.ApiResponse {
 		ModeID      int64
 		Temperature float64
 		Humidity    float64
+		Raw         *sensor.Reading `json:",omitempty"` // the reading before it was filtered, for filtered sensors
 		HeatIndex   float64
+		DewPoint    *float64   `json:",omitempty"` // from sensors that measure humidity
+		Pressure    *float64   `json:",omitempty"` // in hPa, from sensors that measure it
+		Outdoor     *float64   `json:",omitempty"` // when an outdoor temperature source is configured and working
+		OutdoorTime *time.Time `json:",omitempty"` // when the outdoor temperature was fetched
 		Min         float64
 		Max         float64
 		Correction  float64
 		Heat        bool
 		AC          bool
 		Fan         bool
+		FanMode     mode.FanMode
+		FanOverride *system.FanOverride
+		Outputs     map[string]bool
+		Pending     map[string]system.Deferred
+		Reasons     map[string]string
+		Recovery    *system.Recovery
+		Sensors     []sensor.Reading // each sensor, for zones that combine several
+		Fault       *system.Fault
 	}
 
 	config := z.Setting()
@@ -63,15 +152,38 @@ func status(ctx context.Context, msg json.RawMessage) request.ApiResponse {
 
 	data.ScheduleID = config.ID
 	data.ModeID = config.ModeID
-	data.Temperature = sens.Temperature()
-	data.Humidity = sens.Humidity()
+	reading, _ := sensor.Read(sens, z.Clock())
+	data.Temperature = reading.Temperature
+	data.Humidity = reading.Humidity
+	data.Raw = reading.Raw
 	data.HeatIndex = sensor.HeatIndex(data.Temperature, data.Humidity)
+	if sensor.HasHumidity(sens) {
+		dew := sensor.DewPoint(reading.Temperature, reading.Humidity)
+		data.DewPoint = &dew
+	}
+	if reading.Pressure != 0 {
+		data.Pressure = &reading.Pressure
+	}
+	if outdoor, fetched, err := z.Outdoor(); err == nil {
+		fetched = fetched.UTC()
+		data.Outdoor, data.OutdoorTime = &outdoor, &fetched
+	}
 	data.Min = m.MinTemp
 	data.Max = m.MaxTemp
 	data.Correction = m.Correction
 	data.Heat = sys.Heat()
 	data.AC = sys.AC()
 	data.Fan = sys.Fan()
+	data.FanMode = m.Fan
+	data.FanOverride = z.FanOverride()
+	data.Outputs = sys.Outputs()
+	data.Pending = sys.Pending()
+	data.Reasons = z.Reasons()
+	data.Recovery = z.Recovery()
+	data.Fault = z.Fault()
+	if c, ok := sens.(system.CompositeSensor); ok {
+		data.Sensors = c.Readings()
+	}
 
 	if msg, err = json.Marshal(data); err != nil {
 		return request.NewResponse(http.StatusInternalServerError, err.Error())
@@ -113,6 +225,92 @@ func schedules(ctx context.Context, msg json.RawMessage) request.ApiResponse {
 	return request.NewResponse(http.StatusOK, string(msg))
 }
 
+// maxTimeline is the longest timeline a request may ask for
+const maxTimeline = 31 * 24 * time.Hour
+
+// timeline returns the settings a zone will run between start and end, by default the next week, as the intervals
+// where the same setting is in effect. Each interval lists its mode, and the settings it overrides.
+func timeline(ctx context.Context, msg json.RawMessage) request.ApiResponse {
+	var input struct {
+		ZoneID int64
+		Start  time.Time
+		End    time.Time
+	}
+	if err := json.Unmarshal(msg, &input); err != nil {
+		return request.NewResponse(http.StatusBadRequest, err.Error())
+	}
+
+	z, err := system.GetZone(input.ZoneID)
+	if err != nil {
+		return request.NewResponse(http.StatusBadRequest, err.Error())
+	}
+
+	if input.Start.IsZero() {
+		input.Start = z.Clock().Now()
+	}
+	if input.End.IsZero() {
+		input.End = input.Start.Add(7 * 24 * time.Hour)
+	}
+	if !input.Start.Before(input.End) {
+		return request.NewResponse(http.StatusBadRequest, "start must be before end")
+	}
+	if input.End.Sub(input.Start) > maxTimeline {
+		return request.NewResponse(http.StatusBadRequest, "the timeline may be at most 31 days long")
+	}
+
+	settings, err := setting.All(ctx, z.ID())
+	if err != nil {
+		return request.NewResponse(http.StatusInternalServerError, err.Error())
+	}
+
+	type interval struct {
+		Start     time.Time        `json:"start"`
+		End       time.Time        `json:"end"`
+		SettingID int64            `json:"settingID"`
+		Priority  setting.Priority `json:"priority"`
+		ModeID    int64            `json:"modeID"`
+		Mode      string           `json:"mode"`
+		MinTemp   float64          `json:"minTemp"`
+		MaxTemp   float64          `json:"maxTemp"`
+		Shadows   []int64          `json:"shadows"` // the settings it overrides
+	}
+	modes := make(map[int64]mode.Mode)
+	intervals := make([]interval, 0, 16)
+	// schedules are evaluated in the configured timezone, and times are sent in UTC
+	loc := config.Location()
+	for _, i := range system.Timeline(settings, input.Start.In(loc), input.End.In(loc)) {
+		out := interval{
+			Start:     i.Start.UTC(),
+			End:       i.End.UTC(),
+			SettingID: i.Setting.ID,
+			Priority:  i.Setting.Priority,
+			ModeID:    i.Setting.ModeID,
+			Shadows:   make([]int64, 0, len(i.Shadows)),
+		}
+		if i.Setting.ModeID != 0 {
+			m, ok := modes[i.Setting.ModeID]
+			if !ok {
+				if m, err = mode.Get(ctx, i.Setting.ModeID); err != nil {
+					return request.NewResponse(http.StatusInternalServerError, err.Error())
+				}
+				modes[m.ID] = m
+			}
+			out.Mode, out.MinTemp, out.MaxTemp = m.Name, m.MinTemp, m.MaxTemp
+		}
+		for _, s := range i.Shadows {
+			out.Shadows = append(out.Shadows, s.ID)
+		}
+		intervals = append(intervals, out)
+	}
+
+	msg, err = json.Marshal(intervals)
+	if err != nil {
+		return request.NewResponse(http.StatusInternalServerError, err.Error())
+	}
+
+	return request.NewResponse(http.StatusOK, string(msg))
+}
+
 func modes(ctx context.Context, msg json.RawMessage) request.ApiResponse {
 	var input struct {
 		ZoneID int64
@@ -158,7 +356,11 @@ func addSchedule(ctx context.Context, msg json.RawMessage) request.ApiResponse {
 		return request.NewResponse(http.StatusBadRequest, err.Error())
 	}
 
-	if s, err = setting.New(ctx, z.ID(), s.ModeID, s.Priority, s.DayOfWeek, s.StartDay, s.EndDay, s.StartTime, s.EndTime); err != nil {
+	if err := setting.ValidateRooms(s.Rooms); err != nil {
+		return request.NewResponse(http.StatusBadRequest, err.Error())
+	}
+	s.ZoneID = z.ID()
+	if s, err = setting.Add(ctx, s); err != nil {
 		return request.NewResponse(http.StatusInternalServerError, err.Error())
 	}
 
@@ -212,6 +414,135 @@ func deleteSchedule(ctx context.Context, msg json.RawMessage) request.ApiRespons
 	return request.NewResponse(http.StatusOK, `{}`)
 }
 
+// exportSchedules returns a zone's schedules as an iCalendar feed
+func exportSchedules(ctx context.Context, msg json.RawMessage) request.ApiResponse {
+	var input struct {
+		ZoneID int64
+	}
+	if err := json.Unmarshal(msg, &input); err != nil {
+		return request.NewResponse(http.StatusBadRequest, err.Error())
+	}
+
+	z, err := system.GetZone(input.ZoneID)
+	if err != nil {
+		return request.NewResponse(http.StatusBadRequest, err.Error())
+	}
+
+	settings, err := setting.All(ctx, z.ID())
+	if err != nil {
+		return request.NewResponse(http.StatusInternalServerError, err.Error())
+	}
+	userSettings := settings[:0]
+	for _, s := range settings {
+		if s.Priority != setting.CUSTOM && s.Priority != setting.DEFAULT {
+			userSettings = append(userSettings, s)
+		}
+	}
+
+	all, err := mode.All(ctx, z.ID())
+	if err != nil {
+		return request.NewResponse(http.StatusInternalServerError, err.Error())
+	}
+	modes := make(map[int64]mode.Mode, len(all))
+	for _, m := range all {
+		modes[m.ID] = m
+	}
+
+	var buf bytes.Buffer
+	if err := setting.WriteICalendar(&buf, userSettings, modes, z.Clock().Now(), config.Location()); err != nil {
+		return request.NewResponse(http.StatusInternalServerError, err.Error())
+	}
+
+	return request.NewResponse(http.StatusOK, buf.String())
+}
+
+// importSchedules adds a schedule for each event in an iCalendar feed, in the mode given, by default at override
+// priority. Only events whose summary contains Summary are imported, if it's set. Each event is added or rejected on
+// its own, and the response lists what happened to each.
+func importSchedules(ctx context.Context, msg json.RawMessage) request.ApiResponse {
+	var input struct {
+		ZoneID   int64
+		ModeID   int64
+		Priority setting.Priority
+		Summary  string
+		Calendar string
+	}
+	if err := json.Unmarshal(msg, &input); err != nil {
+		return request.NewResponse(http.StatusBadRequest, err.Error())
+	}
+
+	if input.Priority == 0 {
+		input.Priority = setting.OVERRIDE
+	}
+	if input.Priority == setting.DEFAULT {
+		return request.NewResponse(http.StatusBadRequest, "you may not add a schedule with default priority")
+	}
+	if input.Priority == setting.CUSTOM {
+		return request.NewResponse(http.StatusBadRequest, "you may not add a schedule with custom priority")
+	}
+
+	z, err := system.GetZone(input.ZoneID)
+	if err != nil {
+		return request.NewResponse(http.StatusBadRequest, err.Error())
+	}
+
+	m, err := mode.Get(ctx, input.ModeID)
+	if err != nil || m.ZoneID != z.ID() {
+		return request.NewResponse(http.StatusBadRequest, "mode not found")
+	}
+
+	// floating times in the calendar are read on the configured timezone's wall clock, like schedules
+	events, err := setting.ReadICalendar(strings.NewReader(input.Calendar), config.Location())
+	if err != nil {
+		return request.NewResponse(http.StatusBadRequest, err.Error())
+	}
+
+	type result struct {
+		UID     string `json:"uid"`
+		Summary string `json:"summary"`
+		ID      int64  `json:"id,omitempty"`
+		Error   string `json:"error,omitempty"`
+	}
+	results := make([]result, 0, len(events))
+	added := false
+	for _, e := range events {
+		if input.Summary != "" && !strings.Contains(strings.ToLower(e.Summary), strings.ToLower(input.Summary)) {
+			continue
+		}
+		r := result{UID: e.UID, Summary: e.Summary}
+		if e.Err != nil {
+			r.Error = e.Err.Error()
+			results = append(results, r)
+			continue
+		}
+
+		s := e.Setting
+		// each event is validated against the schedules already added, including the ones before it
+		if s, err = setting.New(ctx, z.ID(), m.ID, input.Priority, s.DayOfWeek, s.StartDay, s.EndDay, s.StartTime, s.EndTime); err != nil {
+			r.Error = err.Error()
+		} else {
+			r.ID = s.ID
+			added = true
+		}
+		results = append(results, r)
+	}
+
+	if added {
+		settings, err := setting.All(ctx, z.ID())
+		if err != nil {
+			return request.NewResponse(http.StatusInternalServerError, err.Error())
+		}
+		z.Update(settings)
+	}
+
+	msg, err = json.Marshal(results)
+	if err != nil {
+		return request.NewResponse(http.StatusInternalServerError, err.Error())
+	}
+
+	return request.NewResponse(http.StatusOK, string(msg))
+}
+
 func addMode(ctx context.Context, msg json.RawMessage) request.ApiResponse {
 	var data mode.Mode
 	if err := json.Unmarshal(msg, &data); err != nil {
@@ -221,7 +552,7 @@ func addMode(ctx context.Context, msg json.RawMessage) request.ApiResponse {
 		return request.NewResponse(http.StatusBadRequest, err.Error())
 	}
 
-	m, err := mode.New(ctx, data.ZoneID, data.Name, data.MinTemp, data.MaxTemp, data.Correction)
+	m, err := mode.New(ctx, data.ZoneID, data.Name, data.MinTemp, data.MaxTemp, data.Correction, data.Fan, data.Circulate, data.MinHumidity, data.MaxHumidity, data.Overcool)
 	if err != nil {
 		return request.NewResponse(http.StatusInternalServerError, err.Error())
 	}
@@ -285,6 +616,229 @@ func deleteMode(ctx context.Context, msg json.RawMessage) request.ApiResponse {
 	return request.NewResponse(http.StatusOK, `{}`)
 }
 
+func emergencyHeat(_ context.Context, msg json.RawMessage) request.ApiResponse {
+	var data struct {
+		ZoneID int64
+		On     bool
+	}
+
+	if err := json.Unmarshal(msg, &data); err != nil {
+		return request.NewResponse(http.StatusBadRequest, err.Error())
+	}
+
+	z, err := system.GetZone(data.ZoneID)
+	if err != nil {
+		return request.NewResponse(http.StatusBadRequest, err.Error())
+	}
+
+	sys, ok := z.Controller().(system.EmergencyHeater)
+	if !ok {
+		return request.NewResponse(http.StatusBadRequest, "this zone does not support emergency heat")
+	}
+
+	on, err := sys.SetEmergencyHeat(data.On)
+	if err != nil {
+		return switchError(err)
+	}
+
+	return request.NewResponse(http.StatusOK, fmt.Sprintf(`{"on": %t}`, on))
+}
+
+// fan overrides the fan for the given number of minutes, whatever the mode's fan setting. 0 minutes cancels the override.
+func fan(_ context.Context, msg json.RawMessage) request.ApiResponse {
+	var data struct {
+		ZoneID  int64
+		On      bool
+		Minutes int
+	}
+
+	if err := json.Unmarshal(msg, &data); err != nil {
+		return request.NewResponse(http.StatusBadRequest, err.Error())
+	}
+	if data.Minutes < 0 || data.Minutes > 24*60 {
+		return request.NewResponse(http.StatusBadRequest, "minutes must be between 0 and 1440")
+	}
+
+	z, err := system.GetZone(data.ZoneID)
+	if err != nil {
+		return request.NewResponse(http.StatusBadRequest, err.Error())
+	}
+
+	var until time.Time
+	if data.Minutes > 0 {
+		until = z.Clock().Now().Add(time.Minute * time.Duration(data.Minutes))
+	}
+	z.OverrideFan(data.On, until)
+
+	return request.NewResponse(http.StatusOK, `{}`)
+}
+
+// calibrations returns the calibration of every running sensor, or of the one named, along with its reference readings
+// and its current reading before calibration
+func calibrations(ctx context.Context, msg json.RawMessage) request.ApiResponse {
+	var input struct {
+		Sensor string
+	}
+	if len(msg) > 0 {
+		if err := json.Unmarshal(msg, &input); err != nil {
+			return request.NewResponse(http.StatusBadRequest, err.Error())
+		}
+	}
+
+	names := system.CalibratedSensors()
+	if input.Sensor != "" {
+		if _, err := system.CalibratedSensor(input.Sensor); err != nil {
+			return request.NewResponse(http.StatusBadRequest, err.Error())
+		}
+		names = []string{input.Sensor}
+	}
+
+	type sensorCalibration struct {
+		calibration.Calibration
+		Points       []calibration.Point
+		Uncalibrated sensor.Reading
+	}
+	data := make([]sensorCalibration, 0, len(names))
+	for _, name := range names {
+		s, err := system.CalibratedSensor(name)
+		if err != nil {
+			continue
+		}
+		c, err := calibration.Get(ctx, name)
+		if err != nil {
+			return request.NewResponse(http.StatusInternalServerError, err.Error())
+		}
+		points, err := calibration.Points(ctx, name)
+		if err != nil {
+			return request.NewResponse(http.StatusInternalServerError, err.Error())
+		}
+		raw, err := s.Uncalibrated()
+		if err != nil {
+			raw.Error = err.Error()
+		}
+		data = append(data, sensorCalibration{Calibration: c, Points: points, Uncalibrated: raw})
+	}
+
+	resp, err := json.Marshal(data)
+	if err != nil {
+		return request.NewResponse(http.StatusInternalServerError, err.Error())
+	}
+	return request.NewResponse(http.StatusOK, string(resp))
+}
+
+// calibrationReference calibrates a sensor against a reference thermometer or hygrometer's reading, taken now. Each
+// reference temperature is kept, and the temperature calibration is fit through all of them. The humidity is calibrated
+// with an offset from the latest reference.
+func calibrationReference(ctx context.Context, msg json.RawMessage) request.ApiResponse {
+	var data struct {
+		Sensor      string
+		Temperature *float64
+		Humidity    *float64
+	}
+	if err := json.Unmarshal(msg, &data); err != nil {
+		return request.NewResponse(http.StatusBadRequest, err.Error())
+	}
+	if data.Temperature == nil && data.Humidity == nil {
+		return request.NewResponse(http.StatusBadRequest, "a reference temperature or humidity is required")
+	}
+
+	s, err := system.CalibratedSensor(data.Sensor)
+	if err != nil {
+		return request.NewResponse(http.StatusBadRequest, err.Error())
+	}
+	if data.Humidity != nil && !sensor.HasHumidity(s) {
+		return request.NewResponse(http.StatusBadRequest, data.Sensor+" doesn't measure humidity")
+	}
+	raw, err := s.Uncalibrated()
+	if err != nil {
+		return request.NewResponse(http.StatusInternalServerError, err.Error())
+	}
+
+	c, err := calibration.Get(ctx, data.Sensor)
+	if err != nil {
+		return request.NewResponse(http.StatusInternalServerError, err.Error())
+	}
+	if data.Temperature != nil {
+		p := calibration.Point{Raw: raw.Temperature, Reference: *data.Temperature, Time: raw.Time}
+		points, err := calibration.Points(ctx, data.Sensor)
+		if err != nil {
+			return request.NewResponse(http.StatusInternalServerError, err.Error())
+		}
+		// a reading that doesn't fit isn't kept, so it can't spoil later fits
+		if c.Slope, c.Intercept, err = calibration.Fit(append(points, p)); err != nil {
+			return request.NewResponse(http.StatusBadRequest, err.Error())
+		}
+		if err := calibration.AddPoint(ctx, data.Sensor, p); err != nil {
+			return request.NewResponse(http.StatusInternalServerError, err.Error())
+		}
+	}
+	if data.Humidity != nil {
+		c.HumOffset = *data.Humidity - raw.Humidity
+	}
+	if err := system.Calibrate(ctx, c); err != nil {
+		return request.NewResponse(http.StatusInternalServerError, err.Error())
+	}
+
+	resp, err := json.Marshal(c)
+	if err != nil {
+		return request.NewResponse(http.StatusInternalServerError, err.Error())
+	}
+	return request.NewResponse(http.StatusOK, string(resp))
+}
+
+// setCalibration sets a sensor's calibration to an offset and divider, like tempCorrection and temperatureRangeDivider
+// in the config, and forgets its reference readings. A divider of 0 is taken as 1.
+func setCalibration(ctx context.Context, msg json.RawMessage) request.ApiResponse {
+	var data struct {
+		Sensor    string
+		Offset    float64
+		Divider   float64
+		HumOffset float64
+	}
+	if err := json.Unmarshal(msg, &data); err != nil {
+		return request.NewResponse(http.StatusBadRequest, err.Error())
+	}
+	if data.Divider == 0 {
+		data.Divider = 1
+	}
+
+	c, err := calibration.FromOffset(data.Sensor, data.Offset, data.Divider, data.HumOffset)
+	if err != nil {
+		return request.NewResponse(http.StatusBadRequest, err.Error())
+	}
+	if _, err := system.CalibratedSensor(data.Sensor); err != nil {
+		return request.NewResponse(http.StatusBadRequest, err.Error())
+	}
+	if err := calibration.ClearPoints(ctx, data.Sensor); err != nil {
+		return request.NewResponse(http.StatusInternalServerError, err.Error())
+	}
+	if err := system.Calibrate(ctx, c); err != nil {
+		return request.NewResponse(http.StatusInternalServerError, err.Error())
+	}
+
+	resp, err := json.Marshal(c)
+	if err != nil {
+		return request.NewResponse(http.StatusInternalServerError, err.Error())
+	}
+	return request.NewResponse(http.StatusOK, string(resp))
+}
+
+// switchError reports a failure to switch an output. Switches deferred by protection timers are queued by the controller,
+// so they are reported as accepted along with the timer that deferred them.
+func switchError(err error) request.ApiResponse {
+	var d *system.Deferred
+	if !errors.As(err, &d) {
+		return request.NewResponse(http.StatusInternalServerError, err.Error())
+	}
+
+	msg, err := json.Marshal(d)
+	if err != nil {
+		return request.NewResponse(http.StatusInternalServerError, err.Error())
+	}
+
+	return request.NewResponse(http.StatusAccepted, string(msg))
+}
+
 func editHandler(ctx context.Context, msg json.RawMessage) request.ApiResponse {
 	var data struct {
 		ZoneID int64
@@ -327,6 +881,11 @@ func editHandler(ctx context.Context, msg json.RawMessage) request.ApiResponse {
 		custom.MinTemp = currentMode.MinTemp + data.Delta
 		custom.MaxTemp = currentMode.MaxTemp + data.Delta
 		custom.Correction = 1
+		custom.Fan = currentMode.Fan
+		custom.Circulate = currentMode.Circulate
+		custom.MinHumidity = currentMode.MinHumidity
+		custom.MaxHumidity = currentMode.MaxHumidity
+		custom.Overcool = currentMode.Overcool
 		if err := custom.Update(ctx); err != nil {
 			return request.NewResponse(http.StatusInternalServerError, err.Error())
 		}
@@ -353,12 +912,14 @@ func editHandler(ctx context.Context, msg json.RawMessage) request.ApiResponse {
 		}
 	}
 	settings = settingList
-	now := time.Now()
-	next := nextConfigChange(now, settings, z.Setting().Priority)
+	now := z.Clock().Now().In(config.Location())
+	next := setting.Next(now, settings, z.Setting().Priority)
 	if next.IsZero() {
 		next = now.Add(time.Hour * 12)
 	}
-	if s, err := setting.New(ctx, z.ID(), custom.ID, setting.CUSTOM, current.DayOfWeek, now.Add(-time.Second), next, 0, 86400); err != nil {
+	// the custom setting runs from now until the next change, whatever day that is. The current setting's days don't
+	// do, since a setting that runs past midnight is still running the day after its days.
+	if s, err := setting.New(ctx, z.ID(), custom.ID, setting.CUSTOM, 254, now.Add(-time.Second), next, 0, 86400); err != nil {
 		return request.NewResponse(http.StatusInternalServerError, err.Error())
 	} else {
 		settings = append(settings, s)
@@ -368,30 +929,3 @@ func editHandler(ctx context.Context, msg json.RawMessage) request.ApiResponse {
 
 	return request.NewResponse(http.StatusOK, `{}`)
 }
-
-func nextConfigChange(now time.Time, settings []setting.Setting, current setting.Priority) time.Time {
-	type sched struct {
-		runtime time.Time
-		setting setting.Setting
-	}
-
-	starts := make([]sched, len(settings))
-	for i, s := range settings {
-		starts[i] = sched{
-			runtime: s.Runtime(now),
-			setting: s,
-		}
-	}
-
-	sort.Slice(starts, func(i, j int) bool {
-		return starts[i].runtime.Before(starts[j].runtime)
-	})
-
-	for _, s := range starts {
-		if s.setting.Priority >= current && !s.runtime.IsZero() && s.runtime.After(now) {
-			return s.runtime
-		}
-	}
-
-	return time.Time{}
-}
@@ -3,12 +3,17 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"math"
 	"net/http"
+	"strings"
 	"testing"
+	"thermostat/clock"
+	"thermostat/db/calibration"
 	"thermostat/db/mode"
 	"thermostat/db/setting"
 	"thermostat/db/zone"
@@ -23,27 +28,53 @@ func (c nopController) Fan() bool {
 	return false
 }
 
-func (c nopController) SetFan(_ bool) bool {
-	return false
+func (c nopController) SetFan(_ bool) (bool, error) {
+	return false, nil
 }
 
 func (c nopController) AC() bool {
 	return false
 }
 
-func (c nopController) SetAC(_ bool) bool {
+func (c nopController) SetAC(_ bool) (bool, error) {
+	return false, nil
+}
+
+func (c nopController) AC2() bool {
 	return false
 }
 
+func (c nopController) SetAC2(_ bool) (bool, error) {
+	return false, nil
+}
+
 func (c nopController) Heat() bool {
 	return false
 }
 
-func (c nopController) SetHeat(_ bool) bool {
+func (c nopController) SetHeat(_ bool) (bool, error) {
+	return false, nil
+}
+
+func (c nopController) Heat2() bool {
 	return false
 }
 
-func (c nopController) Reset() {}
+func (c nopController) SetHeat2(_ bool) (bool, error) {
+	return false, nil
+}
+
+func (c nopController) Outputs() map[string]bool {
+	return nil
+}
+
+func (c nopController) Pending() map[string]system.Deferred {
+	return nil
+}
+
+func (c nopController) Reset() error {
+	return nil
+}
 
 var _ system.Controller = &nopController{}
 
@@ -75,16 +106,17 @@ func TestEditHandler(t *testing.T) {
 	z, err := zone.New(ctx, t.Name())
 	require.NoError(t, err)
 
-	defaultMode, err := mode.New(ctx, z.ID, "default", 70, 75, 2)
+	defaultMode, err := mode.New(ctx, z.ID, "default", 70, 75, 2, mode.FanAuto, 0, 0, 0, 0)
 	require.NoError(t, err)
-	_, err = mode.New(ctx, z.ID, "custom", 70, 75, 2)
+	_, err = mode.New(ctx, z.ID, "custom", 70, 75, 2, mode.FanAuto, 0, 0, 0, 0)
 	require.NoError(t, err)
-	mode1, err := mode.New(ctx, z.ID, "mode1", 70, 75, 2)
+	mode1, err := mode.New(ctx, z.ID, "mode1", 70, 75, 2, mode.FanAuto, 0, 0, 0, 0)
 	require.NoError(t, err)
 
-	systemZone, err := system.NewZone(ctx, t.Name(), nopController{}, constantSensor(72))
+	systemZone, err := system.NewZone(ctx, t.Name(), nopController{}, constantSensor(72), clock.New())
 	require.NoError(t, err)
 	systemZone.Startup()
+	defer systemZone.Stop()
 
 	settings, err := setting.All(ctx, z.ID)
 	require.NoError(t, err)
@@ -120,12 +152,12 @@ func TestEditHandler(t *testing.T) {
 			systemZone.Update([]setting.Setting{defaultSetting})
 
 			for i := 1; i <= 5; i++ {
-				if systemZone.Setting().ModeID == defaultSetting.ID {
+				if systemZone.Setting().ModeID == defaultSetting.ModeID {
 					break
 				}
 				time.Sleep(time.Millisecond * time.Duration(5*i))
 			}
-			require.Equal(t, defaultSetting.ID, systemZone.Setting().ModeID)
+			require.Equal(t, defaultSetting.ModeID, systemZone.Setting().ModeID)
 
 			data := struct {
 				ZoneID int64
@@ -192,3 +224,325 @@ func TestEditHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestEditHandler_AfterMidnight(t *testing.T) {
+	ctx := context.TODO()
+
+	z, err := zone.New(ctx, t.Name())
+	require.NoError(t, err)
+	defaultMode, err := mode.New(ctx, z.ID, "default", 60, 85, 1, mode.FanAuto, 0, 0, 0, 0)
+	require.NoError(t, err)
+	_, err = mode.New(ctx, z.ID, "custom", 60, 85, 1, mode.FanAuto, 0, 0, 0, 0)
+	require.NoError(t, err)
+	night, err := mode.New(ctx, z.ID, "night", 64, 78, 1, mode.FanAuto, 0, 0, 0, 0)
+	require.NoError(t, err)
+	_, err = setting.New(ctx, z.ID, defaultMode.ID, setting.DEFAULT, 254, time.Unix(0, 0), time.Unix(7258118400, 0), 0, 86400)
+	require.NoError(t, err)
+	// Friday night runs into Saturday morning
+	wrapped, err := setting.New(ctx, z.ID, night.ID, setting.SCHEDULED, setting.WeekdayMask(time.Friday), time.Unix(0, 0), time.Unix(7258118400, 0), 22*3600, 6*3600)
+	require.NoError(t, err)
+
+	now := time.Date(2020, 1, 11, 2, 0, 0, 0, time.Local) // Saturday
+	systemZone, err := system.NewZone(ctx, t.Name(), nopController{}, constantSensor(72), clock.NewFake(now))
+	require.NoError(t, err)
+	systemZone.Startup()
+	defer systemZone.Stop()
+	require.Eventually(t, func() bool {
+		return systemZone.Setting().ID == wrapped.ID
+	}, time.Second, 5*time.Millisecond)
+
+	response := editHandler(ctx, json.RawMessage(fmt.Sprintf(`{"zoneID": %d, "delta": 1}`, z.ID)))
+	require.Equal(t, http.StatusOK, response.Code, response.Msg)
+
+	settings, err := setting.All(ctx, z.ID)
+	require.NoError(t, err)
+	var custom setting.Setting
+	for _, s := range settings {
+		if s.Priority == setting.CUSTOM {
+			custom = s
+		}
+	}
+	require.NotZero(t, custom.ID)
+	assert.True(t, custom.Active(now), "the custom setting must run on Saturday, not just the wrapped setting's Friday")
+	assert.Equal(t, night.MinTemp+1, custom.Mode(ctx).MinTemp)
+	assert.Eventually(t, func() bool {
+		return systemZone.Setting().ID == custom.ID
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestSwitchError(t *testing.T) {
+	t.Parallel()
+
+	until := time.Date(2020, 1, 1, 12, 2, 0, 0, time.UTC)
+	response := switchError(fmt.Errorf("switching: %w", &system.Deferred{Output: "emergency", On: true, Timer: "minOff", Until: until}))
+	require.Equal(t, http.StatusAccepted, response.Code, response.Msg)
+	var deferred system.Deferred
+	require.NoError(t, json.Unmarshal([]byte(response.Msg), &deferred))
+	assert.Equal(t, system.Deferred{Output: "emergency", On: true, Timer: "minOff", Until: until}, deferred)
+
+	response = switchError(errors.New("relay failure"))
+	assert.Equal(t, http.StatusInternalServerError, response.Code)
+	assert.Equal(t, "relay failure", response.Msg)
+}
+
+// stopZone stops a zone the test added, so it doesn't read the config while later tests change it
+func stopZone(t *testing.T, id int64) {
+	z, err := system.GetZone(id)
+	require.NoError(t, err)
+	z.Stop()
+}
+
+func TestAddZone(t *testing.T) {
+	ctx := context.TODO()
+	viper.Set("backend", "simulation")
+	defer viper.Set("backend", "hardware")
+
+	for {
+		z, err := zone.Get(ctx, t.Name())
+		if err != nil {
+			break
+		}
+		require.NoError(t, z.Delete(ctx))
+	}
+
+	response := addZone(ctx, json.RawMessage(fmt.Sprintf(`{"name": %q}`, t.Name())))
+	require.Equal(t, http.StatusOK, response.Code, response.Msg)
+	var added struct {
+		ID int64
+	}
+	require.NoError(t, json.Unmarshal([]byte(response.Msg), &added))
+	defer stopZone(t, added.ID)
+
+	z, err := system.GetZone(added.ID)
+	require.NoError(t, err)
+	assert.Equal(t, added.ID, z.ID())
+	modes, err := mode.All(ctx, added.ID)
+	require.NoError(t, err)
+	assert.Len(t, modes, 2)
+	settings, err := setting.All(ctx, added.ID)
+	require.NoError(t, err)
+	require.Len(t, settings, 1)
+	assert.Equal(t, setting.DEFAULT, settings[0].Priority)
+
+	response = addZone(ctx, json.RawMessage(fmt.Sprintf(`{"name": %q}`, t.Name())))
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	response = addZone(ctx, json.RawMessage(`{"name": ""}`))
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+
+	// a zone without any equipment configured isn't kept
+	viper.Set("backend", "hardware")
+	response = addZone(ctx, json.RawMessage(fmt.Sprintf(`{"name": "%s unconfigured"}`, t.Name())))
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	_, err = zone.Get(ctx, t.Name()+" unconfigured")
+	assert.Error(t, err)
+}
+
+func TestPushSensor(t *testing.T) {
+	ctx := context.TODO()
+	viper.Set("remoteSensors.pushBedroom.maxAge", 60)
+	viper.Set("remoteSensors.pushAttic.maxAge", 60)
+
+	// only sensors a zone uses take readings
+	response := pushSensor(ctx, json.RawMessage(`{"name": "pushBedroom", "temperature": 68.5, "humidity": 41}`))
+	assert.Equal(t, http.StatusNotFound, response.Code)
+	clk := clock.NewFake(time.Date(2020, 1, 5, 0, 0, 0, 0, time.UTC))
+	s, err := system.RemoteSensor("pushbedroom", clk)
+	require.NoError(t, err)
+
+	response = pushSensor(ctx, json.RawMessage(`{"name": "pushBedroom", "temperature": 68.5, "humidity": 41}`))
+	require.Equal(t, http.StatusOK, response.Code, response.Msg)
+	r, err := s.Read()
+	require.NoError(t, err)
+	assert.Equal(t, 68.5, r.Temperature)
+	assert.Equal(t, 41.0, r.Humidity)
+	assert.Equal(t, clk.Now(), r.Time, "the reading keeps the zone's time")
+
+	response = pushSensor(ctx, json.RawMessage(`{"name": "pushBedroom", "humidity": 41}`))
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	response = pushSensor(ctx, json.RawMessage(`{"name": "pushAttic", "temperature": 90}`))
+	assert.Equal(t, http.StatusNotFound, response.Code)
+	response = pushSensor(ctx, json.RawMessage(`{"name": "attic", "temperature": 90}`))
+	assert.Equal(t, http.StatusNotFound, response.Code)
+}
+
+func TestCalibration(t *testing.T) {
+	ctx := context.TODO()
+	viper.Set("backend", "simulation")
+	defer viper.Set("backend", "hardware")
+
+	response := addZone(ctx, json.RawMessage(fmt.Sprintf(`{"name": %q}`, t.Name())))
+	require.Equal(t, http.StatusOK, response.Code, response.Msg)
+	var added struct {
+		ID int64
+	}
+	require.NoError(t, json.Unmarshal([]byte(response.Msg), &added))
+	defer stopZone(t, added.ID)
+	s, err := system.CalibratedSensor(t.Name())
+	require.NoError(t, err)
+	raw, err := s.Uncalibrated()
+	require.NoError(t, err)
+
+	response = calibrationReference(ctx, json.RawMessage(fmt.Sprintf(`{"sensor": %q, "temperature": %f, "humidity": %f}`, t.Name(), raw.Temperature+2, raw.Humidity-5)))
+	require.Equal(t, http.StatusOK, response.Code, response.Msg)
+	var c calibration.Calibration
+	require.NoError(t, json.Unmarshal([]byte(response.Msg), &c))
+	assert.Equal(t, 1.0, c.Slope)
+	assert.InDelta(t, 2, c.Intercept, 0.0001)
+	assert.InDelta(t, -5, c.HumOffset, 0.0001)
+
+	// the zone's sensor changes right away
+	r, err := s.Read()
+	require.NoError(t, err)
+	assert.InDelta(t, raw.Temperature+2, r.Temperature, 0.5)
+
+	response = calibrations(ctx, json.RawMessage(fmt.Sprintf(`{"sensor": %q}`, t.Name())))
+	require.Equal(t, http.StatusOK, response.Code, response.Msg)
+	var list []struct {
+		Slope  float64
+		Points []calibration.Point
+	}
+	require.NoError(t, json.Unmarshal([]byte(response.Msg), &list))
+	require.Len(t, list, 1)
+	assert.Len(t, list[0].Points, 1)
+
+	response = setCalibration(ctx, json.RawMessage(fmt.Sprintf(`{"sensor": %q, "offset": 1, "divider": 2}`, t.Name())))
+	require.Equal(t, http.StatusOK, response.Code, response.Msg)
+	points, err := calibration.Points(ctx, t.Name())
+	require.NoError(t, err)
+	assert.Empty(t, points)
+	saved, err := calibration.Get(ctx, t.Name())
+	require.NoError(t, err)
+	assert.Equal(t, 0.5, saved.Slope)
+
+	response = calibrationReference(ctx, json.RawMessage(`{"sensor": "attic", "temperature": 70}`))
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	response = calibrationReference(ctx, json.RawMessage(fmt.Sprintf(`{"sensor": %q}`, t.Name())))
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	response = setCalibration(ctx, json.RawMessage(fmt.Sprintf(`{"sensor": %q, "divider": -1}`, t.Name())))
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+}
+
+func TestTimeline(t *testing.T) {
+	ctx := context.TODO()
+	viper.Set("backend", "simulation")
+	defer viper.Set("backend", "hardware")
+
+	response := addZone(ctx, json.RawMessage(fmt.Sprintf(`{"name": %q}`, t.Name())))
+	require.Equal(t, http.StatusOK, response.Code, response.Msg)
+	var added struct {
+		ID int64
+	}
+	require.NoError(t, json.Unmarshal([]byte(response.Msg), &added))
+	defer stopZone(t, added.ID)
+	modes, err := mode.All(ctx, added.ID)
+	require.NoError(t, err)
+	settings, err := setting.All(ctx, added.ID)
+	require.NoError(t, err)
+	require.Len(t, settings, 1)
+	def := settings[0]
+
+	start := time.Date(2020, 7, 4, 12, 0, 0, 0, time.UTC)
+	override := setting.Setting{ZoneID: added.ID, ModeID: modes[0].ID, Priority: setting.OVERRIDE, DayOfWeek: 254, StartDay: start, EndDay: start.AddDate(0, 0, 7), StartTime: 0, EndTime: 86400}
+	data, err := json.Marshal(override)
+	require.NoError(t, err)
+	response = addSchedule(ctx, data)
+	require.Equal(t, http.StatusOK, response.Code, response.Msg)
+	require.NoError(t, json.Unmarshal([]byte(response.Msg), &override))
+
+	response = timeline(ctx, json.RawMessage(fmt.Sprintf(`{"zoneID": %d, "start": "2020-07-04T11:00:00Z", "end": "2020-07-04T13:00:00Z"}`, added.ID)))
+	require.Equal(t, http.StatusOK, response.Code, response.Msg)
+	var intervals []struct {
+		Start     time.Time
+		End       time.Time
+		SettingID int64
+		Mode      string
+		MinTemp   float64
+		Shadows   []int64
+	}
+	require.NoError(t, json.Unmarshal([]byte(response.Msg), &intervals))
+	require.Len(t, intervals, 2)
+	assert.Equal(t, def.ID, intervals[0].SettingID)
+	assert.Empty(t, intervals[0].Shadows)
+	assert.True(t, start.Equal(intervals[0].End))
+	assert.Equal(t, override.ID, intervals[1].SettingID)
+	assert.Equal(t, modes[0].Name, intervals[1].Mode)
+	assert.Equal(t, modes[0].MinTemp, intervals[1].MinTemp)
+	assert.Equal(t, []int64{def.ID}, intervals[1].Shadows)
+
+	response = timeline(ctx, json.RawMessage(fmt.Sprintf(`{"zoneID": %d, "start": "2020-07-04T13:00:00Z", "end": "2020-07-04T11:00:00Z"}`, added.ID)))
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	response = timeline(ctx, json.RawMessage(fmt.Sprintf(`{"zoneID": %d, "start": "2020-07-04T13:00:00Z", "end": "2020-09-04T11:00:00Z"}`, added.ID)))
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+}
+
+func TestScheduleCalendar(t *testing.T) {
+	ctx := context.TODO()
+	viper.Set("backend", "simulation")
+	defer viper.Set("backend", "hardware")
+
+	response := addZone(ctx, json.RawMessage(fmt.Sprintf(`{"name": %q}`, t.Name())))
+	require.Equal(t, http.StatusOK, response.Code, response.Msg)
+	var added struct {
+		ID int64
+	}
+	require.NoError(t, json.Unmarshal([]byte(response.Msg), &added))
+	defer stopZone(t, added.ID)
+	modes, err := mode.All(ctx, added.ID)
+	require.NoError(t, err)
+
+	calendar := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\n" +
+		"BEGIN:VEVENT\r\nUID:beach@test\r\nSUMMARY:Vacation at the beach\r\nDTSTART:20200704T120000Z\r\nDTEND:20200711T120000Z\r\nEND:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\nUID:lake@test\r\nSUMMARY:Vacation at the lake\r\nDTSTART:20200708T120000Z\r\nDTEND:20200712T120000Z\r\nEND:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\nUID:monthly@test\r\nSUMMARY:Monthly vacation\r\nDTSTART:20200801T120000Z\r\nDTEND:20200801T140000Z\r\nRRULE:FREQ=MONTHLY\r\nEND:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\nUID:dentist@test\r\nSUMMARY:Dentist\r\nDTSTART:20200706T150000Z\r\nDTEND:20200706T160000Z\r\nEND:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+	input, err := json.Marshal(map[string]interface{}{"zoneID": added.ID, "modeID": modes[0].ID, "summary": "vacation", "calendar": calendar})
+	require.NoError(t, err)
+
+	response = importSchedules(ctx, input)
+	require.Equal(t, http.StatusOK, response.Code, response.Msg)
+	var results []struct {
+		UID   string
+		ID    int64
+		Error string
+	}
+	require.NoError(t, json.Unmarshal([]byte(response.Msg), &results))
+	require.Len(t, results, 3, "only vacations are imported")
+	assert.Equal(t, "beach@test", results[0].UID)
+	assert.NotZero(t, results[0].ID)
+	assert.Empty(t, results[0].Error)
+	assert.Equal(t, "lake@test", results[1].UID)
+	assert.Zero(t, results[1].ID)
+	assert.Contains(t, results[1].Error, "overlaps")
+	assert.Equal(t, "monthly@test", results[2].UID)
+	assert.Equal(t, "events must repeat daily or weekly", results[2].Error)
+
+	settings, err := setting.All(ctx, added.ID)
+	require.NoError(t, err)
+	var imported setting.Setting
+	for _, s := range settings {
+		if s.ID == results[0].ID {
+			imported = s
+		}
+	}
+	assert.Equal(t, setting.OVERRIDE, imported.Priority)
+	assert.Equal(t, modes[0].ID, imported.ModeID)
+	assert.True(t, time.Date(2020, 7, 4, 12, 0, 0, 0, time.UTC).Equal(imported.StartDay))
+
+	response = exportSchedules(ctx, json.RawMessage(fmt.Sprintf(`{"zoneID": %d}`, added.ID)))
+	require.Equal(t, http.StatusOK, response.Code, response.Msg)
+	assert.True(t, strings.HasPrefix(response.Msg, "BEGIN:VCALENDAR\r\n"))
+	assert.Equal(t, 1, strings.Count(response.Msg, "BEGIN:VEVENT"), "the default schedule isn't exported")
+	assert.Contains(t, response.Msg, fmt.Sprintf("UID:zone-%d-setting-%d@thermostat", added.ID, imported.ID))
+	assert.Contains(t, response.Msg, "SUMMARY:"+modes[0].Name)
+
+	input, err = json.Marshal(map[string]interface{}{"zoneID": added.ID, "modeID": modes[0].ID, "priority": setting.DEFAULT, "calendar": calendar})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, importSchedules(ctx, input).Code)
+	input, err = json.Marshal(map[string]interface{}{"zoneID": added.ID, "modeID": -1, "calendar": calendar})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, importSchedules(ctx, input).Code)
+	input, err = json.Marshal(map[string]interface{}{"zoneID": added.ID, "modeID": modes[0].ID, "calendar": "hello"})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, importSchedules(ctx, input).Code)
+}
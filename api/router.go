@@ -25,15 +25,25 @@ func StartApi(cert, key []byte) {
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/v1/zones", handlerWrapper(zones, auth, false, true))
+	mux.HandleFunc("/v1/zone/add", handlerWrapper(addZone, auth, false, true))
 	mux.HandleFunc("/v1/status", handlerWrapper(status, auth, false, true))
 	mux.HandleFunc("/v1/schedule", handlerWrapper(schedules, auth, false, true))
 	mux.HandleFunc("/v1/schedule/add", handlerWrapper(addSchedule, auth, false, true))
 	mux.HandleFunc("/v1/schedule/delete", handlerWrapper(deleteSchedule, auth, false, true))
+	mux.HandleFunc("/v1/schedule/timeline", handlerWrapper(timeline, auth, false, true))
+	mux.HandleFunc("/v1/schedule/export", handlerWrapper(exportSchedules, auth, false, true))
+	mux.HandleFunc("/v1/schedule/import", limitedHandlerWrapper(importSchedules, auth, false, true, maxImport))
 	mux.HandleFunc("/v1/mode", handlerWrapper(modes, auth, false, true))
 	mux.HandleFunc("/v1/mode/add", handlerWrapper(addMode, auth, false, true))
 	mux.HandleFunc("/v1/mode/edit", handlerWrapper(editMode, auth, false, true))
 	mux.HandleFunc("/v1/mode/delete", handlerWrapper(deleteMode, auth, false, true))
 	mux.HandleFunc("/v1/edit", handlerWrapper(editHandler, auth, false, true))
+	mux.HandleFunc("/v1/emergencyHeat", handlerWrapper(emergencyHeat, auth, false, true))
+	mux.HandleFunc("/v1/fan", handlerWrapper(fan, auth, false, true))
+	mux.HandleFunc("/v1/sensor/push", handlerWrapper(pushSensor, auth, false, false))
+	mux.HandleFunc("/v1/calibration", handlerWrapper(calibrations, auth, false, true))
+	mux.HandleFunc("/v1/calibration/reference", handlerWrapper(calibrationReference, auth, false, true))
+	mux.HandleFunc("/v1/calibration/set", handlerWrapper(setCalibration, auth, false, true))
 
 	mux.HandleFunc("/", web.MimePage("index.html", "text/html; charset=utf-8"))
 	mux.HandleFunc("/sha3.js", web.MimePage("sha3.js", "application/javascript; charset=utf-8"))
@@ -0,0 +1,90 @@
+package broker
+
+import (
+	"errors"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"sync"
+	"thermostat/config"
+	"time"
+)
+
+var client mqtt.Client
+var lock sync.Mutex
+
+var subscriptions = make(map[string]mqtt.MessageHandler)
+var subscriptionsLock sync.Mutex
+
+// Client returns a client connected to the MQTT broker in the config, connecting on first use
+func Client() (mqtt.Client, error) {
+	config.Ready()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if client != nil {
+		return client, nil
+	}
+
+	if !viper.IsSet("mqtt.broker") {
+		return nil, errors.New("no mqtt broker is configured")
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(viper.GetString("mqtt.broker")).
+		SetClientID(viper.GetString("mqtt.clientID")).
+		SetUsername(viper.GetString("mqtt.username")).
+		SetPassword(viper.GetString("mqtt.password")).
+		SetAutoReconnect(true).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			logrus.WithError(err).Warn("lost connection to mqtt broker")
+		}).
+		SetOnConnectHandler(resubscribe)
+
+	c := mqtt.NewClient(opts)
+	token := c.Connect()
+	if !token.WaitTimeout(10 * time.Second) {
+		return nil, errors.New("timed out connecting to mqtt broker")
+	}
+	if err := token.Error(); err != nil {
+		return nil, err
+	}
+
+	logrus.WithField("broker", viper.GetString("mqtt.broker")).Info("connected to mqtt broker")
+	client = c
+	return client, nil
+}
+
+// Subscribe calls handler with each message published to topic, subscribing again whenever the client reconnects
+func Subscribe(topic string, handler mqtt.MessageHandler) error {
+	c, err := Client()
+	if err != nil {
+		return err
+	}
+
+	subscriptionsLock.Lock()
+	subscriptions[topic] = handler
+	subscriptionsLock.Unlock()
+	return subscribe(c, topic, handler)
+}
+
+func subscribe(c mqtt.Client, topic string, handler mqtt.MessageHandler) error {
+	token := c.Subscribe(topic, 1, handler)
+	if !token.WaitTimeout(5 * time.Second) {
+		return errors.New("timed out subscribing to " + topic)
+	}
+	return token.Error()
+}
+
+// resubscribe restores the subscriptions after reconnecting, since the broker forgets them with the session
+func resubscribe(c mqtt.Client) {
+	subscriptionsLock.Lock()
+	defer subscriptionsLock.Unlock()
+
+	for topic, handler := range subscriptions {
+		if err := subscribe(c, topic, handler); err != nil {
+			logrus.WithError(err).WithField("topic", topic).Error("failed to resubscribe")
+		}
+	}
+}
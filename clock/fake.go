@@ -0,0 +1,142 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fake is a clock that only moves when it's told to. Timers and tickers that come due while it's advanced fire in order,
+// with the clock set to the time each was due, before Advance returns.
+type Fake struct {
+	now    time.Time
+	timers []*fakeTimer
+	mutex  sync.Mutex
+}
+
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.now
+}
+
+// AfterFunc calls fn when the clock is advanced past d from now. Unlike the real clock, fn runs in the goroutine that
+// advances the clock.
+func (f *Fake) AfterFunc(d time.Duration, fn func()) Timer {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	t := &fakeTimer{clock: f, due: f.now.Add(d), fn: fn}
+	f.timers = append(f.timers, t)
+	return t
+}
+
+// NewTicker ticks every d as the clock is advanced. Like the real clock, ticks are dropped if the last one hasn't been
+// received.
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	c := make(chan time.Time, 1)
+	t := &fakeTimer{clock: f, due: f.now.Add(d), period: d, c: c}
+	f.timers = append(f.timers, t)
+	return fakeTicker{t}
+}
+
+// Advance moves the clock forward by d, firing every timer and ticker that comes due on the way
+func (f *Fake) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set moves the clock to now, firing every timer and ticker that comes due on the way. The clock never moves back.
+func (f *Fake) Set(now time.Time) {
+	for {
+		f.mutex.Lock()
+		t := f.next(now)
+		if t == nil {
+			if now.After(f.now) {
+				f.now = now
+			}
+			f.mutex.Unlock()
+			return
+		}
+		if t.due.After(f.now) {
+			f.now = t.due
+		}
+		fired := f.now
+		if t.period > 0 {
+			t.due = t.due.Add(t.period)
+		} else {
+			f.remove(t)
+		}
+		f.mutex.Unlock()
+
+		// the mutex isn't held, so timers can read the clock and start new timers
+		if t.fn != nil {
+			t.fn()
+		} else {
+			select {
+			case t.c <- fired:
+			default:
+			}
+		}
+	}
+}
+
+// next returns the first timer due by now, or nil if there are none. Callers must hold the mutex.
+func (f *Fake) next(now time.Time) *fakeTimer {
+	sort.SliceStable(f.timers, func(i, j int) bool {
+		return f.timers[i].due.Before(f.timers[j].due)
+	})
+	if len(f.timers) == 0 || f.timers[0].due.After(now) {
+		return nil
+	}
+	return f.timers[0]
+}
+
+// remove stops t, returning false if it had already fired or been stopped. Callers must hold the mutex.
+func (f *Fake) remove(t *fakeTimer) bool {
+	for i, timer := range f.timers {
+		if timer == t {
+			f.timers = append(f.timers[:i], f.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	clock  *Fake
+	due    time.Time
+	period time.Duration // tickers repeat
+	fn     func()
+	c      chan time.Time
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+
+	return t.clock.remove(t)
+}
+
+type fakeTicker struct {
+	*fakeTimer
+}
+
+func (t fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t fakeTicker) Stop() {
+	t.fakeTimer.Stop()
+}
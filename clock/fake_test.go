@@ -0,0 +1,73 @@
+package clock
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestFake_AfterFunc(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+
+	var fired []time.Time
+	c.AfterFunc(2*time.Minute, func() {
+		fired = append(fired, c.Now())
+	})
+	c.AfterFunc(time.Minute, func() {
+		fired = append(fired, c.Now())
+		// timers started by timers fire in the same advance
+		c.AfterFunc(30*time.Second, func() {
+			fired = append(fired, c.Now())
+		})
+	})
+	stopped := c.AfterFunc(90*time.Second, func() {
+		t.Error("a stopped timer fired")
+	})
+	assert.True(t, stopped.Stop())
+	assert.False(t, stopped.Stop())
+
+	c.Advance(59 * time.Second)
+	assert.Empty(t, fired)
+
+	c.Advance(time.Hour)
+	assert.Equal(t, []time.Time{start.Add(time.Minute), start.Add(90 * time.Second), start.Add(2 * time.Minute)}, fired)
+	assert.Equal(t, start.Add(time.Hour+59*time.Second), c.Now())
+}
+
+func TestFake_Ticker(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+	tick := c.NewTicker(time.Minute)
+
+	c.Advance(30 * time.Second)
+	select {
+	case <-tick.C():
+		t.Error("ticked early")
+	default:
+	}
+
+	c.Advance(time.Minute)
+	assert.Equal(t, start.Add(time.Minute), <-tick.C())
+
+	// missed ticks are dropped
+	c.Advance(5 * time.Minute)
+	assert.Equal(t, start.Add(2*time.Minute), <-tick.C())
+	select {
+	case <-tick.C():
+		t.Error("ticks should be dropped while the last hasn't been received")
+	default:
+	}
+
+	tick.Stop()
+	c.Advance(time.Hour)
+	select {
+	case <-tick.C():
+		t.Error("ticked after stopping")
+	default:
+	}
+}
@@ -8,7 +8,14 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
+)
+
+var (
+	location      = time.Local
+	locationMutex sync.Mutex
 )
 
 func init() {
@@ -21,6 +28,48 @@ func init() {
 	viper.SetDefault("temperatureRangeDivider", 1.0)
 	viper.SetDefault("humCorrection", 0)
 	viper.SetDefault("sensorFan", false)
+	viper.SetDefault("ac2Pin", -1)
+	viper.SetDefault("heat2Pin", -1)
+	viper.SetDefault("cool.minOn", 0)
+	viper.SetDefault("cool.minOff", 120)
+	viper.SetDefault("cool.minCycle", 0)
+	viper.SetDefault("cool.fanLead", 15)
+	viper.SetDefault("cool.fanOverrun", 30)
+	viper.SetDefault("heat.minOn", 0)
+	viper.SetDefault("heat.minOff", 120)
+	viper.SetDefault("heat.minCycle", 0)
+	viper.SetDefault("heat.fanLead", 15)
+	viper.SetDefault("heat.fanOverrun", 30)
+	viper.SetDefault("cool.stage2.minOn", 120)
+	viper.SetDefault("cool.stage2.minOff", 120)
+	viper.SetDefault("heat.stage2.minOn", 120)
+	viper.SetDefault("heat.stage2.minOff", 120)
+	viper.SetDefault("stage2.delay", 600)
+	viper.SetDefault("stage2.threshold", 3)
+	viper.SetDefault("humidity.deadband", 2)
+	viper.SetDefault("recovery.maxLead", 7200)
+	viper.SetDefault("recovery.rate", 0.3)
+	viper.SetDefault("sensor.maxAge", 300)
+	viper.SetDefault("sensor.stuck", 21600)
+	viper.SetDefault("sensor.minTemp", 32)
+	viper.SetDefault("sensor.maxTemp", 120)
+	viper.SetDefault("sensor.maxJump", 5)
+	viper.SetDefault("sensor.failsafe", "off")
+	viper.SetDefault("sensor.freezeRuntime", 600)
+	viper.SetDefault("sensor.freezeTemp", 45)
+	viper.SetDefault("sensor.history", 7)
+	viper.SetDefault("w1.root", "/sys/bus/w1/devices")
+	viper.SetDefault("outdoor.cacheTime", 600)
+	viper.SetDefault("controller", "hvac")
+	viper.SetDefault("backend", "hardware")
+	viper.SetDefault("simulation.temperature", 70)
+	viper.SetDefault("simulation.humidity", 45)
+	viper.SetDefault("simulation.outdoor", 50)
+	viper.SetDefault("simulation.loss", 0.1)
+	viper.SetDefault("simulation.heatRate", 4)
+	viper.SetDefault("simulation.coolRate", 3)
+	viper.SetDefault("heatPump.reversingValve", "O")
+	viper.SetDefault("heatPump.balancePoint", 40)
 	viper.SetDefault("templateDir", "/usr/share/thermostat")
 	viper.SetDefault("db.migrations", "/usr/share/thermostat")
 
@@ -40,9 +89,16 @@ func init() {
 		panic(err)
 	}
 
+	if err := LoadLocation(); err != nil {
+		panic(err)
+	}
+
 	viper.WatchConfig()
 	viper.OnConfigChange(func(e fsnotify.Event) {
 		logrus.WithField("eventName", e.Name).Debug("Config file changed")
+		if err := LoadLocation(); err != nil {
+			logrus.WithError(err).Error("Failed to load timezone, keeping the last one")
+		}
 	})
 
 	switch viper.GetString("log.type") {
@@ -87,3 +143,27 @@ func init() {
 }
 
 func Ready() {}
+
+// Location returns the timezone from the timezone config key, or the system's timezone if it isn't set. Schedules run
+// on its wall clock.
+func Location() *time.Location {
+	locationMutex.Lock()
+	defer locationMutex.Unlock()
+	return location
+}
+
+// LoadLocation reads the timezone config key again. The config watcher calls it whenever the config file changes.
+func LoadLocation() error {
+	loc := time.Local
+	if tz := viper.GetString("timezone"); tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			return err
+		}
+	}
+
+	locationMutex.Lock()
+	defer locationMutex.Unlock()
+	location = loc
+	return nil
+}
@@ -0,0 +1,29 @@
+package config
+
+import (
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestLoadLocation(t *testing.T) {
+	defer func(tz string) {
+		viper.Set("timezone", tz)
+		require.NoError(t, LoadLocation())
+	}(viper.GetString("timezone"))
+
+	viper.Set("timezone", "America/Chicago")
+	require.NoError(t, LoadLocation())
+	assert.Equal(t, "America/Chicago", Location().String())
+
+	// a bad timezone keeps the last one
+	viper.Set("timezone", "Nowhere/Special")
+	assert.Error(t, LoadLocation())
+	assert.Equal(t, "America/Chicago", Location().String())
+
+	viper.Set("timezone", "")
+	require.NoError(t, LoadLocation())
+	assert.Equal(t, time.Local, Location())
+}
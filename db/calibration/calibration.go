@@ -0,0 +1,143 @@
+package calibration
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"thermostat/db"
+	"time"
+)
+
+// Calibration corrects a sensor's readings. The temperature is raw*Slope + Intercept, and the humidity is raw+HumOffset.
+type Calibration struct {
+	Sensor    string
+	Slope     float64
+	Intercept float64
+	HumOffset float64
+}
+
+// Point is a reference thermometer's reading, and what the sensor read at the same time
+type Point struct {
+	Raw       float64
+	Reference float64
+	Time      time.Time
+}
+
+// minSpread is how far apart, in degrees, points' raw readings must be to fit a slope through them. Closer points only
+// fit an offset, since the slope would mostly be noise.
+const minSpread = 2
+
+// minSlope and maxSlope bound the slope of a fit. Outside them, the reference readings are more likely wrong than the
+// sensor is.
+const (
+	minSlope = 0.1
+	maxSlope = 10
+)
+
+// Default leaves a sensor's readings as they are
+func Default(sensor string) Calibration {
+	return Calibration{Sensor: sensor, Slope: 1}
+}
+
+// FromOffset returns a calibration that divides the temperature by divider, then adds offset, like tempCorrection and
+// temperatureRangeDivider in the config
+func FromOffset(sensor string, offset, divider, humOffset float64) (Calibration, error) {
+	if divider <= 0 {
+		return Calibration{}, errors.New("the divider must be above 0")
+	}
+	return Calibration{Sensor: sensor, Slope: 1 / divider, Intercept: offset, HumOffset: humOffset}, nil
+}
+
+// Get returns the sensor's calibration, or the default if it hasn't been calibrated
+func Get(ctx context.Context, sensor string) (Calibration, error) {
+	c := Calibration{Sensor: sensor}
+	row := db.DB.QueryRowContext(ctx, "select slope, intercept, humOffset from calibration where sensor=?", sensor)
+	err := row.Scan(&c.Slope, &c.Intercept, &c.HumOffset)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Default(sensor), nil
+	}
+	return c, err
+}
+
+// Seed saves c as its sensor's calibration if the sensor hasn't been calibrated, and returns the sensor's calibration
+func Seed(ctx context.Context, c Calibration) (Calibration, error) {
+	if _, err := db.DB.ExecContext(ctx, "insert into calibration (sensor, slope, intercept, humOffset) values (?, ?, ?, ?) on conflict(sensor) do nothing",
+		c.Sensor, c.Slope, c.Intercept, c.HumOffset); err != nil {
+		return Calibration{}, err
+	}
+	return Get(ctx, c.Sensor)
+}
+
+// Save saves the calibration, replacing the sensor's old one
+func (c Calibration) Save(ctx context.Context) error {
+	_, err := db.DB.ExecContext(ctx, "insert into calibration (sensor, slope, intercept, humOffset) values (?, ?, ?, ?) on conflict(sensor) do update set slope=excluded.slope, intercept=excluded.intercept, humOffset=excluded.humOffset",
+		c.Sensor, c.Slope, c.Intercept, c.HumOffset)
+	return err
+}
+
+// Points returns the reference readings taken for the sensor, oldest first
+func Points(ctx context.Context, sensor string) ([]Point, error) {
+	rows, err := db.DB.QueryContext(ctx, "select raw, reference, time from calibrationPoint where sensor=? order by time, id", sensor)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := make([]Point, 0, 4)
+	for rows.Next() {
+		var p Point
+		if err := rows.Scan(&p.Raw, &p.Reference, &p.Time); err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+
+	return points, rows.Err()
+}
+
+// AddPoint saves a reference reading for the sensor
+func AddPoint(ctx context.Context, sensor string, p Point) error {
+	_, err := db.DB.ExecContext(ctx, "insert into calibrationPoint (sensor, raw, reference, time) values (?, ?, ?, ?)", sensor, p.Raw, p.Reference, p.Time)
+	return err
+}
+
+// ClearPoints deletes the sensor's reference readings, like after it's moved
+func ClearPoints(ctx context.Context, sensor string) error {
+	_, err := db.DB.ExecContext(ctx, "delete from calibrationPoint where sensor=?", sensor)
+	return err
+}
+
+// Fit returns the least squares line through points, mapping raw readings to the reference. With a single point, or
+// points too close together, it only fits an offset.
+func Fit(points []Point) (slope, intercept float64, err error) {
+	if len(points) == 0 {
+		return 0, 0, errors.New("no reference readings to fit")
+	}
+
+	n := float64(len(points))
+	var sumRaw, sumRef float64
+	low, high := math.Inf(1), math.Inf(-1)
+	for _, p := range points {
+		sumRaw += p.Raw
+		sumRef += p.Reference
+		low = math.Min(low, p.Raw)
+		high = math.Max(high, p.Raw)
+	}
+	meanRaw, meanRef := sumRaw/n, sumRef/n
+	if high-low < minSpread {
+		return 1, meanRef - meanRaw, nil
+	}
+
+	var cov, variance float64
+	for _, p := range points {
+		cov += (p.Raw - meanRaw) * (p.Reference - meanRef)
+		variance += (p.Raw - meanRaw) * (p.Raw - meanRaw)
+	}
+	slope = cov / variance
+	if slope < minSlope || slope > maxSlope {
+		return 0, 0, fmt.Errorf("the reference readings fit a slope of %.2f, which must be between %g and %g", slope, float64(minSlope), float64(maxSlope))
+	}
+	return slope, meanRef - slope*meanRaw, nil
+}
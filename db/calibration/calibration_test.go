@@ -0,0 +1,106 @@
+package calibration
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestCalibration(t *testing.T) {
+	t.Parallel()
+	ctx := context.TODO()
+
+	c, err := Get(ctx, t.Name())
+	require.NoError(t, err)
+	assert.Equal(t, Default(t.Name()), c)
+
+	c, err = FromOffset(t.Name(), 1.5, 2, -3)
+	require.NoError(t, err)
+	require.NoError(t, c.Save(ctx))
+	c.Intercept = 2
+	require.NoError(t, c.Save(ctx))
+
+	saved, err := Get(ctx, t.Name())
+	require.NoError(t, err)
+	assert.Equal(t, Calibration{Sensor: t.Name(), Slope: 0.5, Intercept: 2, HumOffset: -3}, saved)
+
+	_, err = FromOffset(t.Name(), 0, 0, 0)
+	assert.Error(t, err)
+}
+
+func TestPoints(t *testing.T) {
+	t.Parallel()
+	ctx := context.TODO()
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, AddPoint(ctx, t.Name(), Point{Raw: 70, Reference: 71, Time: now}))
+	require.NoError(t, AddPoint(ctx, t.Name(), Point{Raw: 75, Reference: 76.5, Time: now.Add(time.Hour)}))
+	require.NoError(t, AddPoint(ctx, t.Name()+" other", Point{Raw: 75, Reference: 76.5, Time: now}))
+
+	points, err := Points(ctx, t.Name())
+	require.NoError(t, err)
+	require.Len(t, points, 2)
+	assert.Equal(t, 70.0, points[0].Raw)
+	assert.Equal(t, 76.5, points[1].Reference)
+	assert.True(t, now.Add(time.Hour).Equal(points[1].Time))
+
+	require.NoError(t, ClearPoints(ctx, t.Name()))
+	points, err = Points(ctx, t.Name())
+	require.NoError(t, err)
+	assert.Empty(t, points)
+	points, err = Points(ctx, t.Name()+" other")
+	require.NoError(t, err)
+	assert.Len(t, points, 1)
+}
+
+func TestFit(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		points    []Point
+		slope     float64
+		intercept float64
+	}{
+		{"one point", []Point{{Raw: 70, Reference: 71.5}}, 1, 1.5},
+		{"points too close", []Point{{Raw: 70, Reference: 71}, {Raw: 71, Reference: 72}}, 1, 1},
+		{"line", []Point{{Raw: 60, Reference: 62}, {Raw: 70, Reference: 71}, {Raw: 80, Reference: 80}}, 0.9, 8},
+		{"noisy line", []Point{{Raw: 60, Reference: 60}, {Raw: 60, Reference: 62}, {Raw: 80, Reference: 80}, {Raw: 80, Reference: 82}}, 1, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slope, intercept, err := Fit(tt.points)
+			require.NoError(t, err)
+			assert.InDelta(t, tt.slope, slope, 0.0001)
+			assert.InDelta(t, tt.intercept, intercept, 0.0001)
+		})
+	}
+
+	_, _, err := Fit(nil)
+	assert.Error(t, err)
+	_, _, err = Fit([]Point{{Raw: 60, Reference: 80}, {Raw: 80, Reference: 60}})
+	assert.Error(t, err, "a negative slope")
+	_, _, err = Fit([]Point{{Raw: 70, Reference: 30}, {Raw: 73, Reference: 90}})
+	assert.Error(t, err, "a slope of 20")
+}
+
+func TestSeed(t *testing.T) {
+	t.Parallel()
+	ctx := context.TODO()
+
+	seed, err := FromOffset(t.Name(), 1.5, 1, 2)
+	require.NoError(t, err)
+	c, err := Seed(ctx, seed)
+	require.NoError(t, err)
+	assert.Equal(t, seed, c)
+
+	// once the sensor is calibrated, the seed is ignored
+	c.Intercept = -1
+	require.NoError(t, c.Save(ctx))
+	c, err = Seed(ctx, seed)
+	require.NoError(t, err)
+	assert.Equal(t, -1.0, c.Intercept)
+}
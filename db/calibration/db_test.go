@@ -0,0 +1,19 @@
+package calibration
+
+import (
+	"context"
+	"github.com/spf13/viper"
+	"thermostat/db"
+)
+
+func init() {
+	ctx := context.Background()
+	viper.SetDefault("db.file", "/tmp/thermostat-db-calibration.db")
+
+	if _, err := db.DB.ExecContext(ctx, "delete from calibrationPoint"); err != nil {
+		panic(err)
+	}
+	if _, err := db.DB.ExecContext(ctx, "delete from calibration"); err != nil {
+		panic(err)
+	}
+}
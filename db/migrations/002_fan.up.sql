@@ -0,0 +1,6 @@
+create table modeFan (
+    modeID integer primary key,
+    mode text not null default 'auto',
+    circulate integer not null default 0,
+    foreign key (modeID) references mode(id) on delete cascade
+);
@@ -0,0 +1,7 @@
+create table modeHumidity (
+    modeID integer primary key,
+    minHumidity real not null default 0,
+    maxHumidity real not null default 0,
+    overcool real not null default 0,
+    foreign key (modeID) references mode(id) on delete cascade
+);
@@ -0,0 +1,7 @@
+create table zoneRate (
+    zoneID integer,
+    kind text,
+    rate real,
+    primary key (zoneID, kind),
+    foreign key (zoneID) references zone(id) on delete cascade
+);
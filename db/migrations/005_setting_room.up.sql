@@ -0,0 +1,6 @@
+create table settingRoom (
+    settingID integer,
+    room text,
+    primary key (settingID, room),
+    foreign key (settingID) references setting(id) on delete cascade
+);
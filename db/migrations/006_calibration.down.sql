@@ -0,0 +1,2 @@
+drop table calibrationPoint;
+drop table calibration;
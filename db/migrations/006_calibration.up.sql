@@ -0,0 +1,14 @@
+create table calibration (
+    sensor text primary key,
+    slope real,
+    intercept real,
+    humOffset real
+);
+
+create table calibrationPoint (
+    id integer primary key asc,
+    sensor text,
+    raw real,
+    reference real,
+    time timestamp
+);
@@ -0,0 +1,2 @@
+drop index readingRecorded;
+drop table reading;
@@ -0,0 +1,14 @@
+create table reading (
+    id integer primary key asc,
+    zoneID integer,
+    sensor text,
+    temperature real,
+    humidity real,
+    used boolean,
+    error text,
+    time timestamp,
+    recorded timestamp,
+    foreign key (zoneID) references zone(id) on delete cascade
+);
+
+create index readingRecorded on reading (zoneID, recorded);
@@ -8,6 +8,15 @@ import (
 	"thermostat/db"
 )
 
+// FanMode controls when the fan runs on its own, apart from running with heat or AC
+type FanMode string
+
+const (
+	FanAuto      FanMode = "auto"      // the fan only runs with heat or AC
+	FanOn        FanMode = "on"        // the fan always runs
+	FanCirculate FanMode = "circulate" // the fan runs at least Circulate minutes each hour
+)
+
 type Mode struct {
 	ID         int64
 	ZoneID     int64   `json:"zoneID"`
@@ -15,8 +24,17 @@ type Mode struct {
 	MinTemp    float64 `json:"minTemp"`
 	MaxTemp    float64 `json:"maxTemp"`
 	Correction float64 `json:"correction"`
+	Fan        FanMode `json:"fan"`
+	Circulate  int     `json:"circulate"` // minutes per hour
+
+	MinHumidity float64 `json:"minHumidity"` // percent. 0 disables humidifying
+	MaxHumidity float64 `json:"maxHumidity"` // percent. 0 disables dehumidifying
+	Overcool    float64 `json:"overcool"`    // degrees the AC may cool below the range to dehumidify
 }
 
+// MaxOvercool is the most a mode may overcool to dehumidify
+const MaxOvercool = 3
+
 func (m Mode) Validate() error {
 	if len(m.Name) < 2 {
 		return errors.New("name must be at least 2 characters long")
@@ -30,14 +48,37 @@ func (m Mode) Validate() error {
 	if m.Correction*2 > m.MaxTemp-m.MinTemp {
 		return errors.New("correction cannot be more than half the difference in temperature range")
 	}
+	switch m.Fan {
+	case "", FanAuto, FanOn:
+	case FanCirculate:
+		if m.Circulate < 1 || m.Circulate > 60 {
+			return errors.New("circulate must be between 1 and 60 minutes per hour")
+		}
+	default:
+		return errors.New("fan must be auto, on, or circulate")
+	}
+	if m.MinHumidity < 0 || m.MinHumidity > 100 || m.MaxHumidity < 0 || m.MaxHumidity > 100 {
+		return errors.New("humidity must be between 0 and 100 percent")
+	}
+	if m.MinHumidity > 0 && m.MaxHumidity > 0 && m.MaxHumidity-m.MinHumidity < 10 {
+		return errors.New("max humidity must be at least 10 percent higher than the min humidity")
+	}
+	if m.Overcool < 0 || m.Overcool > MaxOvercool {
+		return errors.New("overcool must be between 0 and 3 degrees")
+	}
 
 	return nil
 }
 
+// columns selects every field of a mode, in the order of Mode's fields
+const columns = "id, zoneID, name, minTemp, maxTemp, correction, coalesce(modeFan.mode, 'auto'), coalesce(modeFan.circulate, 0), " +
+	"coalesce(modeHumidity.minHumidity, 0), coalesce(modeHumidity.maxHumidity, 0), coalesce(modeHumidity.overcool, 0) " +
+	"from mode left join modeFan on modeFan.modeID=mode.id left join modeHumidity on modeHumidity.modeID=mode.id"
+
 func Get(ctx context.Context, id int64) (Mode, error) {
-	row := db.DB.QueryRowContext(ctx, "select id, zoneID, name, minTemp, maxTemp, correction from mode where id=?", id)
+	row := db.DB.QueryRowContext(ctx, "select "+columns+" where id=?", id)
 	var m Mode
-	if err := row.Scan(&m.ID, &m.ZoneID, &m.Name, &m.MinTemp, &m.MaxTemp, &m.Correction); err != nil {
+	if err := row.Scan(&m.ID, &m.ZoneID, &m.Name, &m.MinTemp, &m.MaxTemp, &m.Correction, &m.Fan, &m.Circulate, &m.MinHumidity, &m.MaxHumidity, &m.Overcool); err != nil {
 		return Mode{}, err
 	}
 
@@ -57,7 +98,7 @@ func Get(ctx context.Context, id int64) (Mode, error) {
 }
 
 func All(ctx context.Context, zone int64) ([]Mode, error) {
-	rows, err := db.DB.QueryContext(ctx, "select id, name, minTemp, maxTemp, correction from mode where zoneID=?", zone)
+	rows, err := db.DB.QueryContext(ctx, "select "+columns+" where zoneID=?", zone)
 	if err != nil {
 		return nil, err
 	}
@@ -65,10 +106,8 @@ func All(ctx context.Context, zone int64) ([]Mode, error) {
 
 	modes := make([]Mode, 0, 4)
 	for rows.Next() {
-		m := Mode{
-			ZoneID: zone,
-		}
-		if err := rows.Scan(&m.ID, &m.Name, &m.MinTemp, &m.MaxTemp, &m.Correction); err != nil {
+		var m Mode
+		if err := rows.Scan(&m.ID, &m.ZoneID, &m.Name, &m.MinTemp, &m.MaxTemp, &m.Correction, &m.Fan, &m.Circulate, &m.MinHumidity, &m.MaxHumidity, &m.Overcool); err != nil {
 			return nil, err
 		}
 		modes = append(modes, m)
@@ -77,13 +116,21 @@ func All(ctx context.Context, zone int64) ([]Mode, error) {
 	return modes, err
 }
 
-func New(ctx context.Context, zoneID int64, name string, minTemp, maxTemp, correction float64) (Mode, error) {
+func New(ctx context.Context, zoneID int64, name string, minTemp, maxTemp, correction float64, fan FanMode, circulate int, minHumidity, maxHumidity, overcool float64) (Mode, error) {
+	if fan == "" {
+		fan = FanAuto
+	}
 	m := Mode{
-		ZoneID:     zoneID,
-		Name:       name,
-		MinTemp:    minTemp,
-		MaxTemp:    maxTemp,
-		Correction: correction,
+		ZoneID:      zoneID,
+		Name:        name,
+		MinTemp:     minTemp,
+		MaxTemp:     maxTemp,
+		Correction:  correction,
+		Fan:         fan,
+		Circulate:   circulate,
+		MinHumidity: minHumidity,
+		MaxHumidity: maxHumidity,
+		Overcool:    overcool,
 	}
 
 	result, err := db.DB.ExecContext(ctx, "insert into mode (zoneID, name, minTemp, maxTemp, correction) values (?, ?, ?, ?, ?)", zoneID, name, minTemp, maxTemp, correction)
@@ -91,13 +138,29 @@ func New(ctx context.Context, zoneID int64, name string, minTemp, maxTemp, corre
 		return Mode{}, err
 	}
 
-	m.ID, err = result.LastInsertId()
+	if m.ID, err = result.LastInsertId(); err != nil {
+		return Mode{}, err
+	}
 
-	return m, err
+	return m, m.updateOptions(ctx)
 }
 
 func (m Mode) Update(ctx context.Context) error {
-	_, err := db.DB.ExecContext(ctx, "UPDATE mode SET name=?, minTemp=?, maxTemp=?, correction=? where id=?", m.Name, m.MinTemp, m.MaxTemp, m.Correction, m.ID)
+	if _, err := db.DB.ExecContext(ctx, "UPDATE mode SET name=?, minTemp=?, maxTemp=?, correction=? where id=?", m.Name, m.MinTemp, m.MaxTemp, m.Correction, m.ID); err != nil {
+		return err
+	}
+	return m.updateOptions(ctx)
+}
+
+// updateOptions saves the fan and humidity settings, which are kept apart from the mode itself
+func (m Mode) updateOptions(ctx context.Context) error {
+	if m.Fan == "" {
+		m.Fan = FanAuto
+	}
+	if _, err := db.DB.ExecContext(ctx, "insert into modeFan (modeID, mode, circulate) values (?, ?, ?) on conflict(modeID) do update set mode=excluded.mode, circulate=excluded.circulate", m.ID, m.Fan, m.Circulate); err != nil {
+		return err
+	}
+	_, err := db.DB.ExecContext(ctx, "insert into modeHumidity (modeID, minHumidity, maxHumidity, overcool) values (?, ?, ?, ?) on conflict(modeID) do update set minHumidity=excluded.minHumidity, maxHumidity=excluded.maxHumidity, overcool=excluded.overcool", m.ID, m.MinHumidity, m.MaxHumidity, m.Overcool)
 	return err
 }
 
@@ -19,7 +19,7 @@ func TestGetMode(t *testing.T) {
 
 	var modes []Mode
 	for i := 0; i < 3; i++ {
-		m, err := New(ctx, z.ID, fmt.Sprintf(t.Name()+"%d", i), 70, 80, 1)
+		m, err := New(ctx, z.ID, fmt.Sprintf(t.Name()+"%d", i), 70, 80, 1, FanAuto, 0, 0, 0, 0)
 		require.NoError(t, err)
 		modes = append(modes, m)
 	}
@@ -43,7 +43,7 @@ func TestNewMode(t *testing.T) {
 	z, err := zone.New(ctx, t.Name())
 	require.NoError(t, err)
 
-	id, err := New(ctx, z.ID, t.Name(), 60, 80, 2)
+	id, err := New(ctx, z.ID, t.Name(), 60, 80, 2, FanAuto, 0, 0, 0, 0)
 	require.NoError(t, err)
 	assert.NotZero(t, id)
 }
@@ -55,7 +55,7 @@ func TestMode_Edit(t *testing.T) {
 	z, err := zone.New(ctx, t.Name())
 	require.NoError(t, err)
 
-	m, err := New(ctx, z.ID, t.Name(), 71, 80, 1)
+	m, err := New(ctx, z.ID, t.Name(), 71, 80, 1, FanAuto, 0, 0, 0, 0)
 	require.NoError(t, err)
 
 	m.Name = "foo"
@@ -73,6 +73,112 @@ func TestMode_Edit(t *testing.T) {
 	assert.Equal(t, float64(1), m.Correction)
 }
 
+func TestMode_Fan(t *testing.T) {
+	t.Parallel()
+	ctx := context.TODO()
+
+	z, err := zone.New(ctx, t.Name())
+	require.NoError(t, err)
+
+	m, err := New(ctx, z.ID, t.Name(), 70, 80, 1, FanCirculate, 20, 0, 0, 0)
+	require.NoError(t, err)
+
+	check, err := Get(ctx, m.ID)
+	require.NoError(t, err)
+	assert.Equal(t, FanCirculate, check.Fan)
+	assert.Equal(t, 20, check.Circulate)
+
+	m.Fan = FanOn
+	require.NoError(t, m.Update(ctx))
+
+	modes, err := All(ctx, z.ID)
+	require.NoError(t, err)
+	require.Len(t, modes, 1)
+	assert.Equal(t, FanOn, modes[0].Fan)
+}
+
+func TestMode_Humidity(t *testing.T) {
+	t.Parallel()
+	ctx := context.TODO()
+
+	z, err := zone.New(ctx, t.Name())
+	require.NoError(t, err)
+
+	m, err := New(ctx, z.ID, t.Name(), 70, 80, 1, FanAuto, 0, 30, 55, 2)
+	require.NoError(t, err)
+
+	check, err := Get(ctx, m.ID)
+	require.NoError(t, err)
+	assert.Equal(t, float64(30), check.MinHumidity)
+	assert.Equal(t, float64(55), check.MaxHumidity)
+	assert.Equal(t, float64(2), check.Overcool)
+
+	m.MaxHumidity = 60
+	require.NoError(t, m.Update(ctx))
+	check, err = Get(ctx, m.ID)
+	require.NoError(t, err)
+	assert.Equal(t, float64(60), check.MaxHumidity)
+}
+
+func TestMode_ValidateHumidity(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		minHumidity float64
+		maxHumidity float64
+		overcool    float64
+		valid       bool
+	}{
+		{0, 0, 0, true},
+		{30, 55, 2, true},
+		{0, 55, 0, true},
+		{50, 55, 0, false},
+		{-1, 0, 0, false},
+		{0, 101, 0, false},
+		{0, 55, 4, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%v %v %v", tt.minHumidity, tt.maxHumidity, tt.overcool), func(t *testing.T) {
+			m := Mode{Name: "test", MinTemp: 70, MaxTemp: 80, Correction: 1, MinHumidity: tt.minHumidity, MaxHumidity: tt.maxHumidity, Overcool: tt.overcool}
+			if tt.valid {
+				assert.NoError(t, m.Validate())
+			} else {
+				assert.Error(t, m.Validate())
+			}
+		})
+	}
+}
+
+func TestMode_ValidateFan(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		fan       FanMode
+		circulate int
+		valid     bool
+	}{
+		{"", 0, true},
+		{FanAuto, 0, true},
+		{FanOn, 0, true},
+		{FanCirculate, 15, true},
+		{FanCirculate, 0, false},
+		{FanCirculate, 61, false},
+		{"sometimes", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %d", tt.fan, tt.circulate), func(t *testing.T) {
+			m := Mode{Name: "test", MinTemp: 70, MaxTemp: 80, Correction: 1, Fan: tt.fan, Circulate: tt.circulate}
+			if tt.valid {
+				assert.NoError(t, m.Validate())
+			} else {
+				assert.Error(t, m.Validate())
+			}
+		})
+	}
+}
+
 func modeExists(t testing.TB, ctx context.Context, id int64) bool {
 	t.Helper()
 
@@ -90,7 +196,7 @@ func TestMode_Delete(t *testing.T) {
 	z, err := zone.New(ctx, t.Name())
 	require.NoError(t, err)
 
-	m, err := New(ctx, z.ID, t.Name(), 0, 100, 1)
+	m, err := New(ctx, z.ID, t.Name(), 0, 100, 1, FanAuto, 0, 0, 0, 0)
 	require.NoError(t, err)
 	assert.True(t, modeExists(t, ctx, m.ID))
 
@@ -0,0 +1,19 @@
+package reading
+
+import (
+	"context"
+	"github.com/spf13/viper"
+	"thermostat/db"
+)
+
+func init() {
+	ctx := context.Background()
+	viper.SetDefault("db.file", "/tmp/thermostat-db-reading.db")
+
+	if _, err := db.DB.ExecContext(ctx, "delete from reading"); err != nil {
+		panic(err)
+	}
+	if _, err := db.DB.ExecContext(ctx, "delete from zone"); err != nil {
+		panic(err)
+	}
+}
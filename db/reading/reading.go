@@ -0,0 +1,62 @@
+package reading
+
+import (
+	"context"
+	"thermostat/db"
+	"time"
+)
+
+// Reading is one of a zone's sensor readings, as it was recorded
+type Reading struct {
+	Sensor      string // the room the sensor is in, or "" for the zone's combined reading
+	Temperature float64
+	Humidity    float64
+	Used        bool   // the reading counted towards the combined reading
+	Error       string // why the sensor failed to read
+	Time        time.Time
+	Recorded    time.Time // when the zone recorded the reading
+}
+
+// Add records readings the zone took together at recorded, all or none of them
+func Add(ctx context.Context, zoneID int64, recorded time.Time, readings []Reading) error {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // does nothing once committed
+
+	for _, r := range readings {
+		if _, err := tx.ExecContext(ctx, "insert into reading (zoneID, sensor, temperature, humidity, used, error, time, recorded) values (?, ?, ?, ?, ?, ?, ?, ?)",
+			zoneID, r.Sensor, r.Temperature, r.Humidity, r.Used, r.Error, r.Time, recorded); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Between returns the zone's readings recorded from start until end, oldest first
+func Between(ctx context.Context, zoneID int64, start, end time.Time) ([]Reading, error) {
+	rows, err := db.DB.QueryContext(ctx, "select sensor, temperature, humidity, used, error, time, recorded from reading where zoneID=? and recorded>=? and recorded<? order by recorded, id",
+		zoneID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	readings := make([]Reading, 0, 64)
+	for rows.Next() {
+		var r Reading
+		if err := rows.Scan(&r.Sensor, &r.Temperature, &r.Humidity, &r.Used, &r.Error, &r.Time, &r.Recorded); err != nil {
+			return nil, err
+		}
+		readings = append(readings, r)
+	}
+
+	return readings, rows.Err()
+}
+
+// Prune deletes the zone's readings recorded before before
+func Prune(ctx context.Context, zoneID int64, before time.Time) error {
+	_, err := db.DB.ExecContext(ctx, "delete from reading where zoneID=? and recorded<?", zoneID, before)
+	return err
+}
@@ -0,0 +1,51 @@
+package reading
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"thermostat/db/zone"
+	"time"
+)
+
+func TestReadings(t *testing.T) {
+	t.Parallel()
+	ctx := context.TODO()
+
+	z, err := zone.New(ctx, t.Name())
+	require.NoError(t, err)
+	other, err := zone.New(ctx, t.Name()+" other")
+	require.NoError(t, err)
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	first := []Reading{
+		{Sensor: "hallway", Temperature: 70, Humidity: 40, Used: true, Time: now.Add(-time.Second)},
+		{Sensor: "bedroom", Error: "i2c", Time: now.Add(-time.Minute)},
+		{Temperature: 70, Humidity: 40, Used: true, Time: now.Add(-time.Second)},
+	}
+	require.NoError(t, Add(ctx, z.ID, now, first))
+	require.NoError(t, Add(ctx, z.ID, now.Add(time.Minute), []Reading{{Temperature: 71, Used: true, Time: now.Add(time.Minute)}}))
+	require.NoError(t, Add(ctx, other.ID, now, []Reading{{Temperature: 60, Time: now}}))
+
+	readings, err := Between(ctx, z.ID, now, now.Add(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, readings, 3)
+	for i, r := range readings {
+		assert.Equal(t, first[i].Sensor, r.Sensor)
+		assert.Equal(t, first[i].Temperature, r.Temperature)
+		assert.Equal(t, first[i].Used, r.Used)
+		assert.Equal(t, first[i].Error, r.Error)
+		assert.True(t, first[i].Time.Equal(r.Time))
+		assert.True(t, now.Equal(r.Recorded))
+	}
+
+	require.NoError(t, Prune(ctx, z.ID, now.Add(time.Minute)))
+	readings, err = Between(ctx, z.ID, now, now.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, readings, 1)
+	assert.Equal(t, 71.0, readings[0].Temperature)
+	readings, err = Between(ctx, other.ID, now, now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, readings, 1, "other zones' readings are kept")
+}
@@ -0,0 +1,477 @@
+package setting
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"thermostat/db/mode"
+	"time"
+	"unicode/utf8"
+)
+
+// Settings are shared as iCalendar (RFC 5545) events. A setting is a weekly event, repeating on its days of the week
+// from its start day to its end day. Times are written as floating times, read on the local wall clock, the same way
+// schedules are. Events read from a calendar become settings the same way: an event that repeats daily or weekly runs
+// at the same times on each of its days, and one that doesn't runs the whole time between its start and end.
+
+// forever is the end day of a setting that never ends
+var forever = time.Unix(106751991167, 0)
+
+const (
+	icalDate     = "20060102"
+	icalDateTime = "20060102T150405"
+	icalUTC      = "20060102T150405Z"
+)
+
+var icalDays = [...]string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+func (p Priority) String() string {
+	switch p {
+	case DEFAULT:
+		return "default"
+	case SCHEDULED:
+		return "scheduled"
+	case OVERRIDE:
+		return "override"
+	case CUSTOM:
+		return "custom"
+	}
+	return fmt.Sprintf("priority %d", int(p))
+}
+
+// WriteICalendar writes settings to w as a calendar of weekly events, each named for its mode, with wall clock times in loc
+func WriteICalendar(w io.Writer, settings []Setting, modes map[int64]mode.Mode, stamp time.Time, loc *time.Location) error {
+	out := icalWriter{w: bufio.NewWriter(w)}
+	out.line("BEGIN:VCALENDAR")
+	out.line("VERSION:2.0")
+	out.line("PRODID:-//thermostat//schedules//EN")
+	out.line("CALSCALE:GREGORIAN")
+	for _, s := range settings {
+		m := modes[s.ModeID]
+
+		// the first day the setting runs, at noon since it's never skipped or repeated
+		year, month, day := s.StartDay.In(loc).Date()
+		first := time.Date(year, month, day, 12, 0, 0, 0, loc)
+		for i := 0; i < 7 && s.DayOfWeek&WeekdayMask(first.Weekday()) == 0; i++ {
+			first = first.AddDate(0, 0, 1)
+		}
+		endDate := first
+		if s.Wraps() {
+			endDate = first.AddDate(0, 0, 1)
+		}
+
+		days := make([]string, 0, len(icalDays))
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			if s.DayOfWeek&WeekdayMask(d) > 0 {
+				days = append(days, icalDays[d])
+			}
+		}
+
+		out.line("BEGIN:VEVENT")
+		out.line(fmt.Sprintf("UID:zone-%d-setting-%d@thermostat", s.ZoneID, s.ID))
+		out.line("DTSTAMP:" + stamp.UTC().Format(icalUTC))
+		out.line("DTSTART:" + floating(first, s.StartTime))
+		out.line("DTEND:" + floating(endDate, s.EndTime))
+		rule := "RRULE:FREQ=WEEKLY;BYDAY=" + strings.Join(days, ",")
+		if !s.EndDay.Equal(forever) { // a rule without an end repeats forever
+			rule += ";UNTIL=" + s.EndDay.In(loc).Format(icalDateTime)
+		}
+		out.line(rule)
+		out.line("SUMMARY:" + escapeText(m.Name))
+		out.line("DESCRIPTION:" + escapeText(fmt.Sprintf("%s schedule, %g to %g degrees F", s.Priority, m.MinTemp, m.MaxTemp)))
+		out.line("CATEGORIES:" + escapeText(s.Priority.String()))
+		out.line("END:VEVENT")
+	}
+	out.line("END:VCALENDAR")
+	if out.err != nil {
+		return out.err
+	}
+	return out.w.Flush()
+}
+
+// floating formats sec seconds past midnight on day's date as a floating date and time
+func floating(day time.Time, sec int) string {
+	year, month, date := day.Date()
+	return time.Date(year, month, date, 0, 0, sec, 0, time.UTC).Format(icalDateTime)
+}
+
+// icalWriter writes content lines, folded to 75 octets, and keeps the first error
+type icalWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (w *icalWriter) line(s string) {
+	for w.err == nil {
+		if len(s) <= 75 {
+			_, w.err = w.w.WriteString(s + "\r\n")
+			return
+		}
+		// fold between characters, and continuation lines start with a space
+		n := 75
+		for n > 0 && !utf8.RuneStart(s[n]) {
+			n--
+		}
+		_, w.err = w.w.WriteString(s[:n] + "\r\n ")
+		s = s[n:]
+	}
+}
+
+var textEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+var textUnescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+// Event is an event read from a calendar, and the setting it schedules. Settings read from a calendar don't have a
+// zone, mode, or priority. Err is why the event can't be a setting.
+type Event struct {
+	UID     string
+	Summary string
+	Setting Setting
+	Err     error
+}
+
+// property is a content line's parameters and value
+type property struct {
+	params map[string]string
+	value  string
+}
+
+// ReadICalendar reads the events in a calendar, with floating times read in loc
+func ReadICalendar(r io.Reader, loc *time.Location) ([]Event, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	// unfold continuation lines
+	text := strings.NewReplacer("\r\n ", "", "\r\n\t", "", "\n ", "", "\n\t", "").Replace(string(data))
+
+	var events []Event
+	var event map[string]property
+	var stack []string
+	calendar := false
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		name, params, value, err := contentLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+
+		switch name {
+		case "BEGIN":
+			value = strings.ToUpper(value)
+			if len(stack) == 0 && value != "VCALENDAR" {
+				return nil, errors.New("not a calendar")
+			}
+			calendar = true
+			stack = append(stack, value)
+			if len(stack) == 2 && value == "VEVENT" {
+				event = make(map[string]property)
+			}
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1] != strings.ToUpper(value) {
+				return nil, fmt.Errorf("line %d: unexpected END:%s", i+1, value)
+			}
+			if len(stack) == 2 && event != nil {
+				events = append(events, newEvent(event, loc))
+				event = nil
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			// properties of alarms and other components in the event aren't the event's
+			if len(stack) == 2 && event != nil {
+				if _, ok := event[name]; !ok {
+					event[name] = property{params: params, value: value}
+				}
+			}
+		}
+	}
+	if !calendar {
+		return nil, errors.New("not a calendar")
+	}
+	if len(stack) > 0 {
+		return nil, errors.New("calendar is incomplete")
+	}
+
+	return events, nil
+}
+
+// contentLine splits a content line into its upper case name, its parameters, and its value
+func contentLine(line string) (string, map[string]string, string, error) {
+	quoted := false
+	colon := -1
+	for i, c := range line {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return "", nil, "", errors.New("missing a value")
+	}
+
+	parts := strings.Split(line[:colon], ";")
+	params := make(map[string]string, len(parts)-1)
+	for _, p := range parts[1:] {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			return "", nil, "", fmt.Errorf("invalid parameter %q", p)
+		}
+		params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], `"`)
+	}
+
+	return strings.ToUpper(parts[0]), params, line[colon+1:], nil
+}
+
+// newEvent makes a setting from the event's properties
+func newEvent(props map[string]property, loc *time.Location) Event {
+	e := Event{
+		UID:     props["UID"].value,
+		Summary: textUnescaper.Replace(props["SUMMARY"].value),
+	}
+	e.Setting, e.Err = eventSetting(props, loc)
+	return e
+}
+
+func eventSetting(props map[string]property, loc *time.Location) (Setting, error) {
+	if strings.EqualFold(props["STATUS"].value, "CANCELLED") {
+		return Setting{}, errors.New("event is cancelled")
+	}
+	if _, ok := props["RDATE"]; ok {
+		return Setting{}, errors.New("extra dates for repeating events are not supported")
+	}
+	if _, ok := props["EXDATE"]; ok {
+		return Setting{}, errors.New("exceptions to repeating events are not supported")
+	}
+
+	dtstart, ok := props["DTSTART"]
+	if !ok {
+		return Setting{}, errors.New("event must have a start")
+	}
+	start, allDay, err := icalTime(dtstart, loc)
+	if err != nil {
+		return Setting{}, fmt.Errorf("invalid start: %v", err)
+	}
+
+	var end time.Time
+	if dtend, ok := props["DTEND"]; ok {
+		if end, _, err = icalTime(dtend, loc); err != nil {
+			return Setting{}, fmt.Errorf("invalid end: %v", err)
+		}
+	} else if duration, ok := props["DURATION"]; ok {
+		d, days, err := icalDuration(duration.value)
+		if err != nil {
+			return Setting{}, fmt.Errorf("invalid duration: %v", err)
+		}
+		end = start.AddDate(0, 0, days).Add(d)
+	} else if allDay {
+		end = start.AddDate(0, 0, 1)
+	}
+	if !end.After(start) {
+		return Setting{}, errors.New("event must end after it starts")
+	}
+
+	rrule, ok := props["RRULE"]
+	if !ok {
+		// the setting runs all the time from the start of the event to its end
+		return Setting{
+			DayOfWeek: 254,
+			StartDay:  start,
+			EndDay:    end.Add(-time.Second),
+			StartTime: 0,
+			EndTime:   24 * 60 * 60,
+		}, nil
+	}
+
+	s := Setting{StartDay: start}
+	if allDay {
+		if !end.Equal(start.AddDate(0, 0, 1)) {
+			return Setting{}, errors.New("repeating all day events must last one day")
+		}
+		s.StartTime, s.EndTime = 0, 24*60*60
+	} else {
+		if end.Sub(start) >= 24*time.Hour {
+			return Setting{}, errors.New("repeating events must be shorter than a day")
+		}
+		s.StartTime, s.EndTime = daySeconds(start), daySeconds(end)
+		if s.EndTime == 0 {
+			s.EndTime = 24 * 60 * 60
+		}
+	}
+
+	rule, err := icalRule(rrule.value)
+	if err != nil {
+		return Setting{}, err
+	}
+	if rule.days != 0 {
+		s.DayOfWeek = rule.days
+	} else if rule.daily {
+		s.DayOfWeek = 254
+	} else {
+		s.DayOfWeek = WeekdayMask(start.Weekday())
+	}
+
+	// the setting ends when its last event does
+	duration := end.Sub(start)
+	switch {
+	case rule.until != "":
+		until, untilDate, err := icalTime(property{value: rule.until}, loc)
+		if err != nil {
+			return Setting{}, fmt.Errorf("invalid repeat end: %v", err)
+		}
+		if untilDate {
+			// the whole day
+			until = until.AddDate(0, 0, 1).Add(-time.Second)
+		}
+		s.EndDay = lastStart(s, until).Add(duration)
+	case rule.count > 0:
+		year, month, day := start.Date()
+		last := start
+		for i, n := 0, 0; n < rule.count; i++ {
+			date := time.Date(year, month, day+i, 12, 0, 0, 0, start.Location())
+			if i == 0 || s.DayOfWeek&WeekdayMask(date.Weekday()) > 0 {
+				last = wallClock(date, s.StartTime)
+				n++
+			}
+		}
+		s.EndDay = last.Add(duration)
+	default:
+		s.EndDay = forever
+	}
+
+	return s, nil
+}
+
+// lastStart returns the last time the setting starts at or before until
+func lastStart(s Setting, until time.Time) time.Time {
+	year, month, day := until.Date()
+	for i := 0; i <= 7; i++ {
+		// noon is never skipped or repeated
+		date := time.Date(year, month, day-i, 12, 0, 0, 0, until.Location())
+		if s.DayOfWeek&WeekdayMask(date.Weekday()) == 0 {
+			continue
+		}
+		if start := wallClock(date, s.StartTime); !start.After(until) {
+			return start
+		}
+	}
+	return time.Time{}
+}
+
+// icalTime reads a date or date and time property in the location it names, or in loc. Dates are midnight, and
+// report that they're dates.
+func icalTime(p property, loc *time.Location) (time.Time, bool, error) {
+	if tzid, ok := p.params["TZID"]; ok {
+		l, err := time.LoadLocation(tzid)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("unknown time zone %q", tzid)
+		}
+		t, err := time.ParseInLocation(icalDateTime, p.value, l)
+		return t.In(loc), false, err
+	}
+
+	switch {
+	case len(p.value) == len(icalDate):
+		t, err := time.ParseInLocation(icalDate, p.value, loc)
+		return t, true, err
+	case strings.HasSuffix(p.value, "Z"):
+		t, err := time.Parse(icalUTC, p.value)
+		return t.In(loc), false, err
+	}
+	t, err := time.ParseInLocation(icalDateTime, p.value, loc)
+	return t, false, err
+}
+
+var durationPattern = regexp.MustCompile(`^\+?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// icalDuration reads a duration as a number of days, which follow the wall clock, and a time
+func icalDuration(s string) (time.Duration, int, error) {
+	match := durationPattern.FindStringSubmatch(s)
+	if match == nil || s == "P" || strings.HasSuffix(s, "T") {
+		return 0, 0, fmt.Errorf("%q is not a duration", s)
+	}
+	n := make([]int, len(match))
+	for i, m := range match[1:] {
+		if m != "" {
+			n[i+1], _ = strconv.Atoi(m)
+		}
+	}
+	return time.Duration(n[3])*time.Hour + time.Duration(n[4])*time.Minute + time.Duration(n[5])*time.Second, n[1]*7 + n[2], nil
+}
+
+// rule is a recurrence rule a setting can follow
+type rule struct {
+	freq  bool
+	daily bool
+	days  int
+	until string
+	count int
+}
+
+// icalRule reads a recurrence rule, which must repeat every day or every week
+func icalRule(s string) (rule, error) {
+	var r rule
+	for _, part := range strings.Split(s, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return rule{}, fmt.Errorf("invalid repeat rule %q", part)
+		}
+		value := strings.ToUpper(kv[1])
+		switch strings.ToUpper(kv[0]) {
+		case "FREQ":
+			r.freq = true
+			switch value {
+			case "DAILY":
+				r.daily = true
+			case "WEEKLY":
+			default:
+				return rule{}, errors.New("events must repeat daily or weekly")
+			}
+		case "INTERVAL":
+			if value != "1" {
+				return rule{}, errors.New("events must repeat every day or every week")
+			}
+		case "BYDAY":
+			for _, d := range strings.Split(value, ",") {
+				i := 0
+				for i < len(icalDays) && icalDays[i] != d {
+					i++
+				}
+				if i == len(icalDays) {
+					return rule{}, fmt.Errorf("unsupported repeat day %q", d)
+				}
+				r.days |= WeekdayMask(time.Weekday(i))
+			}
+		case "UNTIL":
+			r.until = value
+		case "COUNT":
+			count, err := strconv.Atoi(value)
+			if err != nil || count < 1 {
+				return rule{}, fmt.Errorf("invalid repeat count %q", value)
+			}
+			r.count = count
+		case "WKST":
+		default:
+			return rule{}, fmt.Errorf("unsupported repeat rule %s", kv[0])
+		}
+	}
+	if !r.freq {
+		return rule{}, errors.New("repeat rules must have a frequency")
+	}
+	if r.until != "" && r.count > 0 {
+		return rule{}, errors.New("repeat rules may not have both an end and a count")
+	}
+	return r, nil
+}
@@ -0,0 +1,173 @@
+package setting
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"thermostat/db/mode"
+	"time"
+)
+
+func calendar(events ...string) string {
+	return "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\n" + strings.Join(events, "") + "END:VCALENDAR\r\n"
+}
+
+func TestWriteICalendar(t *testing.T) {
+	t.Parallel()
+	loc := chicago(t)
+
+	settings := []Setting{
+		{ID: 2, ZoneID: 1, ModeID: 5, Priority: SCHEDULED, DayOfWeek: WeekdayMask(time.Monday) | WeekdayMask(time.Friday), StartDay: time.Date(2020, 1, 1, 0, 0, 0, 0, loc), EndDay: time.Date(2020, 6, 30, 0, 0, 0, 0, loc), StartTime: 6 * 60 * 60, EndTime: 8 * 60 * 60},
+		{ID: 3, ZoneID: 1, ModeID: 6, Priority: OVERRIDE, DayOfWeek: WeekdayMask(time.Saturday), StartDay: time.Date(2020, 1, 1, 0, 0, 0, 0, loc), EndDay: time.Date(2020, 6, 30, 0, 0, 0, 0, loc), StartTime: 22 * 60 * 60, EndTime: 6 * 60 * 60},
+		{ID: 4, ZoneID: 1, ModeID: 5, Priority: DEFAULT, DayOfWeek: 254, StartDay: time.Date(2020, 1, 1, 0, 0, 0, 0, loc), EndDay: forever, StartTime: 6 * 60 * 60, EndTime: 22 * 60 * 60},
+	}
+	modes := map[int64]mode.Mode{
+		5: {ID: 5, Name: "work, weekdays", MinTemp: 62, MaxTemp: 80},
+		6: {ID: 6, Name: "Saturday night, and a long name that has to be folded onto the next line", MinTemp: 66, MaxTemp: 76},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteICalendar(&buf, settings, modes, time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC), loc))
+	out := buf.String()
+
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n") {
+		assert.True(t, len(line) <= 75, "line longer than 75 octets: %q", line)
+	}
+	assert.True(t, strings.HasPrefix(out, "BEGIN:VCALENDAR\r\n"))
+	assert.True(t, strings.HasSuffix(out, "END:VCALENDAR\r\n"))
+	assert.Contains(t, out, "UID:zone-1-setting-2@thermostat\r\n")
+	assert.Contains(t, out, "DTSTAMP:20200101T120000Z\r\n")
+	// the first Monday or Friday of the year
+	assert.Contains(t, out, "DTSTART:20200103T060000\r\nDTEND:20200103T080000\r\nRRULE:FREQ=WEEKLY;BYDAY=MO,FR;UNTIL=20200630T000000\r\n")
+	assert.Contains(t, out, "SUMMARY:work\\, weekdays\r\n")
+	assert.Contains(t, out, "DESCRIPTION:scheduled schedule\\, 62 to 80 degrees F\r\n")
+	// Saturday night runs into Sunday morning
+	assert.Contains(t, out, "DTSTART:20200104T220000\r\nDTEND:20200105T060000\r\n")
+	assert.Contains(t, out, "CATEGORIES:override\r\n")
+	// a setting that never ends repeats without an end
+	assert.Contains(t, out, "RRULE:FREQ=WEEKLY;BYDAY=SU,MO,TU,WE,TH,FR,SA\r\n")
+
+	// what's written reads back as the same settings
+	events, err := ReadICalendar(&buf, loc)
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	assert.Equal(t, "work, weekdays", events[0].Summary)
+	assert.Equal(t, modes[6].Name, events[1].Summary)
+	for i, e := range events {
+		require.NoError(t, e.Err)
+		assert.Equal(t, settings[i].DayOfWeek, e.Setting.DayOfWeek)
+		assert.Equal(t, settings[i].StartTime, e.Setting.StartTime)
+		assert.Equal(t, settings[i].EndTime, e.Setting.EndTime)
+	}
+	assert.Equal(t, forever, events[2].Setting.EndDay)
+}
+
+func TestReadICalendar(t *testing.T) {
+	t.Parallel()
+	loc := chicago(t)
+	monday := time.Date(2020, 3, 2, 0, 0, 0, 0, loc)
+
+	tests := []struct {
+		name     string
+		event    string
+		expected Setting
+		err      string
+	}{
+		{
+			name:     "all day",
+			event:    "DTSTART;VALUE=DATE:20200302\r\nDTEND;VALUE=DATE:20200309\r\n",
+			expected: Setting{DayOfWeek: 254, StartDay: monday, EndDay: monday.AddDate(0, 0, 7).Add(-time.Second), StartTime: 0, EndTime: 86400},
+		},
+		{
+			name:     "one day",
+			event:    "DTSTART;VALUE=DATE:20200302\r\n",
+			expected: Setting{DayOfWeek: 254, StartDay: monday, EndDay: monday.AddDate(0, 0, 1).Add(-time.Second), StartTime: 0, EndTime: 86400},
+		},
+		{
+			name:     "time zone",
+			event:    "DTSTART;TZID=America/New_York:20200302T090000\r\nDTEND:20200303T150000Z\r\n",
+			expected: Setting{DayOfWeek: 254, StartDay: monday.Add(8 * time.Hour), EndDay: monday.Add(33*time.Hour - time.Second), StartTime: 0, EndTime: 86400},
+		},
+		{
+			name:     "weekly",
+			event:    "DTSTART:20200302T060000\r\nDURATION:PT2H30M\r\nRRULE:FREQ=WEEKLY;BYDAY=MO,WE,FR;UNTIL=20200313\r\n",
+			expected: Setting{DayOfWeek: 2<<1 | 2<<3 | 2<<5, StartDay: monday.Add(6 * time.Hour), EndDay: time.Date(2020, 3, 13, 8, 30, 0, 0, loc), StartTime: 6 * 60 * 60, EndTime: 8*60*60 + 30*60},
+		},
+		{
+			name:     "weekly on the start day",
+			event:    "DTSTART:20200302T060000\r\nDTEND:20200302T080000\r\nRRULE:FREQ=WEEKLY;COUNT=3\r\n",
+			expected: Setting{DayOfWeek: 2 << 1, StartDay: monday.Add(6 * time.Hour), EndDay: time.Date(2020, 3, 16, 8, 0, 0, 0, loc), StartTime: 6 * 60 * 60, EndTime: 8 * 60 * 60},
+		},
+		{
+			name:     "overnight, across spring forward",
+			event:    "DTSTART:20200306T220000\r\nDTEND:20200307T060000\r\nRRULE:FREQ=DAILY;COUNT=3\r\n",
+			expected: Setting{DayOfWeek: 254, StartDay: time.Date(2020, 3, 6, 22, 0, 0, 0, loc), EndDay: time.Date(2020, 3, 9, 6, 0, 0, 0, loc), StartTime: 22 * 60 * 60, EndTime: 6 * 60 * 60},
+		},
+		{
+			name:     "until the end of the day",
+			event:    "DTSTART:20200302T210000\r\nDTEND:20200303T000000\r\nRRULE:FREQ=DAILY\r\n",
+			expected: Setting{DayOfWeek: 254, StartDay: monday.Add(21 * time.Hour), EndDay: forever, StartTime: 21 * 60 * 60, EndTime: 86400},
+		},
+		{name: "no start", event: "SUMMARY:nothing\r\n", err: "event must have a start"},
+		{name: "backwards", event: "DTSTART:20200302T090000\r\nDTEND:20200302T080000\r\n", err: "event must end after it starts"},
+		{name: "monthly", event: "DTSTART:20200302T090000\r\nDTEND:20200302T100000\r\nRRULE:FREQ=MONTHLY\r\n", err: "events must repeat daily or weekly"},
+		{name: "every other week", event: "DTSTART:20200302T090000\r\nDTEND:20200302T100000\r\nRRULE:FREQ=WEEKLY;INTERVAL=2\r\n", err: "events must repeat every day or every week"},
+		{name: "long", event: "DTSTART:20200302T090000\r\nDTEND:20200303T100000\r\nRRULE:FREQ=DAILY\r\n", err: "repeating events must be shorter than a day"},
+		{name: "exceptions", event: "DTSTART:20200302T090000\r\nDTEND:20200302T100000\r\nRRULE:FREQ=DAILY\r\nEXDATE:20200303T090000\r\n", err: "exceptions to repeating events are not supported"},
+		{name: "cancelled", event: "DTSTART:20200302T090000\r\nDTEND:20200302T100000\r\nSTATUS:CANCELLED\r\n", err: "event is cancelled"},
+		{name: "unknown time zone", event: "DTSTART;TZID=Mars/Olympus:20200302T090000\r\nDTEND:20200302T100000\r\n", err: `invalid start: unknown time zone "Mars/Olympus"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := ReadICalendar(strings.NewReader(calendar("BEGIN:VEVENT\r\nUID:1@test\r\nSUMMARY:Vacation\r\n"+tt.event+"END:VEVENT\r\n")), loc)
+			require.NoError(t, err)
+			require.Len(t, events, 1)
+			e := events[0]
+			assert.Equal(t, "1@test", e.UID)
+			if tt.err != "" {
+				require.Error(t, e.Err)
+				assert.Equal(t, tt.err, e.Err.Error())
+				return
+			}
+			require.NoError(t, e.Err)
+			assert.Equal(t, tt.expected.DayOfWeek, e.Setting.DayOfWeek)
+			assert.Equal(t, tt.expected.StartTime, e.Setting.StartTime)
+			assert.Equal(t, tt.expected.EndTime, e.Setting.EndTime)
+			assert.True(t, tt.expected.StartDay.Equal(e.Setting.StartDay), "expected start %s, got %s", tt.expected.StartDay, e.Setting.StartDay)
+			assert.True(t, tt.expected.EndDay.Equal(e.Setting.EndDay), "expected end %s, got %s", tt.expected.EndDay, e.Setting.EndDay)
+		})
+	}
+}
+
+func TestReadICalendar_Structure(t *testing.T) {
+	t.Parallel()
+	loc := chicago(t)
+
+	// folded lines, escaped text, and an alarm whose properties aren't the event's
+	events, err := ReadICalendar(strings.NewReader(calendar(
+		"BEGIN:VEVENT\r\nUID:1@test\r\nSUMMARY:Trip to the lake\\, and\r\n  back\r\nDTSTART;VALUE=DATE:20200302\r\n"+
+			"BEGIN:VALARM\r\nACTION:DISPLAY\r\nSUMMARY:alarm\r\nTRIGGER:-PT1H\r\nEND:VALARM\r\nEND:VEVENT\r\n",
+		"BEGIN:VTODO\r\nUID:2@test\r\nSUMMARY:not an event\r\nEND:VTODO\r\n",
+		"BEGIN:VEVENT\nUID:3@test\nSUMMARY:bare newlines\nDTSTART;VALUE=DATE:20200302\nEND:VEVENT\n",
+	)), loc)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, "Trip to the lake, and back", events[0].Summary)
+	assert.NoError(t, events[0].Err)
+	assert.Equal(t, "bare newlines", events[1].Summary)
+	assert.NoError(t, events[1].Err)
+
+	for _, bad := range []string{
+		"",
+		"hello",
+		"BEGIN:VEVENT\r\nEND:VEVENT\r\n",
+		"BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\n",
+		"BEGIN:VCALENDAR\r\nEND:VEVENT\r\n",
+	} {
+		_, err := ReadICalendar(strings.NewReader(bad), loc)
+		assert.Error(t, err, bad)
+	}
+}
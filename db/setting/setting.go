@@ -2,9 +2,11 @@ package setting
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"github.com/sirupsen/logrus"
+	"sort"
 	"thermostat/db"
 	"thermostat/db/mode"
 	"time"
@@ -29,6 +31,7 @@ type Setting struct {
 	EndDay    time.Time `json:"endDay"`
 	StartTime int       `json:"startTime"`
 	EndTime   int       `json:"endTime"`
+	Rooms     []string  `json:"rooms"` // the rooms whose sensors the zone reads while this schedule is active. Empty for all of them
 }
 
 func (s Setting) Mode(ctx context.Context) mode.Mode {
@@ -39,6 +42,28 @@ func (s Setting) Mode(ctx context.Context) mode.Mode {
 	return m
 }
 
+// Wraps reports if the setting runs past midnight, ending the day after it starts
+func (s Setting) Wraps() bool {
+	return s.EndTime < s.StartTime
+}
+
+// Active reports if the setting is in effect at now. Settings that wrap past midnight use the day of the week they
+// start on, so a Friday night setting still runs early Saturday morning.
+func (s Setting) Active(now time.Time) bool {
+	if s.StartDay.After(now) || s.EndDay.Before(now) {
+		return false
+	}
+
+	sec := daySeconds(now)
+	if !s.Wraps() {
+		return s.DayOfWeek&WeekdayMask(now.Weekday()) > 0 && sec >= s.StartTime && sec <= s.EndTime
+	}
+	if sec >= s.StartTime {
+		return s.DayOfWeek&WeekdayMask(now.Weekday()) > 0
+	}
+	return sec <= s.EndTime && s.DayOfWeek&WeekdayMask(previousDay(now.Weekday())) > 0
+}
+
 // Runtime returns the next time this schedule would run if it was the highest priority schedule at that time
 func (s Setting) Runtime(now time.Time) time.Time {
 	if now.After(s.EndDay) {
@@ -49,26 +74,94 @@ func (s Setting) Runtime(now time.Time) time.Time {
 		return s.StartDay
 	}
 
-	start := now
-	if s.DayOfWeek&WeekdayMask(now.Weekday()) > 0 {
-		nowSeconds := now.Hour()*60*60 + now.Minute()*60 + now.Second()
-		if nowSeconds < s.EndTime {
-			if nowSeconds < s.StartTime {
-				start = start.Add(time.Second * time.Duration(s.StartTime-nowSeconds))
-			}
-			return start
+	if s.Active(now) && daySeconds(now) != s.EndTime {
+		return now
+	}
+
+	year, month, day := now.Date()
+	for i := 0; i <= 7; i++ {
+		// noon is never skipped or repeated
+		date := time.Date(year, month, day+i, 12, 0, 0, 0, now.Location())
+		if s.DayOfWeek&WeekdayMask(date.Weekday()) == 0 {
+			continue
+		}
+		start := wallClock(date, s.StartTime)
+		if !start.After(now) {
+			continue
+		}
+		// the whole schedule was skipped when the clock sprang forward
+		if !s.Wraps() && !wallClock(date, s.EndTime).After(start) {
+			continue
 		}
+		return start
 	}
+	return time.Time{}
+}
+
+// Changes returns the times after from and before to when the setting may start or stop being active, in order
+func (s Setting) Changes(from, to time.Time) []time.Time {
+	candidates := []time.Time{s.StartDay, s.EndDay.Add(time.Second)}
 
-	_, offset := now.Zone()
-	start = start.Truncate(time.Hour * 24).Add(time.Duration(-offset) * time.Second)
-	for i, d := 1, now.Weekday()+1; i <= 7; i, d = i+1, (d+1)%(time.Saturday+1) {
-		if s.DayOfWeek&WeekdayMask(d) > 0 {
-			start = start.Add(time.Hour * 24 * time.Duration(i)).Add(time.Second * time.Duration(s.StartTime))
+	year, month, day := from.Date()
+	for i := -1; ; i++ {
+		// noon is never skipped or repeated
+		date := time.Date(year, month, day+i, 12, 0, 0, 0, from.Location())
+		if wallClock(date, 0).After(to) {
 			break
 		}
+		if s.DayOfWeek&WeekdayMask(date.Weekday()) == 0 {
+			continue
+		}
+		endDate := date
+		if s.Wraps() {
+			endDate = date.AddDate(0, 0, 1)
+		}
+		// the setting is still active at its end time, so it stops the second after
+		end := s.EndTime + 1
+		if end > 24*60*60 {
+			end = 24 * 60 * 60
+		}
+		candidates = append(candidates, wallClock(date, s.StartTime), wallClock(endDate, end))
 	}
-	return start
+
+	changes := candidates[:0]
+	for _, c := range candidates {
+		if c.After(from) && c.Before(to) {
+			changes = append(changes, c)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Before(changes[j])
+	})
+	return changes
+}
+
+// Next returns when the next of settings with at least the current priority begins, or the zero time if none do
+func Next(now time.Time, settings []Setting, current Priority) time.Time {
+	type sched struct {
+		runtime time.Time
+		setting Setting
+	}
+
+	starts := make([]sched, len(settings))
+	for i, s := range settings {
+		starts[i] = sched{
+			runtime: s.Runtime(now),
+			setting: s,
+		}
+	}
+
+	sort.Slice(starts, func(i, j int) bool {
+		return starts[i].runtime.Before(starts[j].runtime)
+	})
+
+	for _, s := range starts {
+		if s.setting.Priority >= current && !s.runtime.IsZero() && s.runtime.After(now) {
+			return s.runtime
+		}
+	}
+
+	return time.Time{}
 }
 
 func Overlaps(a, b Setting) bool {
@@ -81,20 +174,48 @@ func Overlaps(a, b Setting) bool {
 	if a.EndDay.Before(b.StartDay) || a.StartDay.After(b.EndDay) {
 		return false
 	}
-	if a.DayOfWeek&b.DayOfWeek == 0 {
-		return false
-	}
-	if a.EndTime < b.StartTime || a.StartTime > b.EndTime {
-		return false
+
+	aDays, bDays := a.dayRanges(), b.dayRanges()
+	for d := range aDays {
+		for _, ar := range aDays[d] {
+			for _, br := range bDays[d] {
+				if ar[0] <= br[1] && br[0] <= ar[1] {
+					return true
+				}
+			}
+		}
 	}
 
-	return true
+	return false
+}
+
+// dayRanges returns the start and end seconds the setting runs for on each day of the week. Settings that wrap past
+// midnight run from their start time to the end of the day, then from midnight to their end time on the next day.
+func (s Setting) dayRanges() [time.Saturday + 1][][2]int {
+	var days [time.Saturday + 1][][2]int
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if s.DayOfWeek&WeekdayMask(d) == 0 {
+			continue
+		}
+		if !s.Wraps() {
+			days[d] = append(days[d], [2]int{s.StartTime, s.EndTime})
+			continue
+		}
+		days[d] = append(days[d], [2]int{s.StartTime, 24 * 60 * 60})
+		next := (d + 1) % (time.Saturday + 1)
+		days[next] = append(days[next], [2]int{0, s.EndTime})
+	}
+	return days
 }
 
 func WeekdayMask(d time.Weekday) int {
 	return 2 << uint(d)
 }
 
+func previousDay(d time.Weekday) time.Weekday {
+	return (d + time.Saturday) % (time.Saturday + 1)
+}
+
 func All(ctx context.Context, zoneID int64) ([]Setting, error) {
 	rows, err := db.DB.QueryContext(ctx, "select id, modeID, priority, dayOfWeek, startDay, endDay, startTime, endTime from setting where zoneID=?", zoneID)
 	if err != nil {
@@ -112,8 +233,85 @@ func All(ctx context.Context, zoneID int64) ([]Setting, error) {
 		}
 		settings = append(settings, s)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-	return settings, err
+	rooms, err := zoneRooms(ctx, zoneID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range settings {
+		settings[i].Rooms = rooms[settings[i].ID]
+	}
+
+	return settings, nil
+}
+
+// zoneRooms returns the rooms listed for each of a zone's settings, by setting
+func zoneRooms(ctx context.Context, zoneID int64) (map[int64][]string, error) {
+	rows, err := db.DB.QueryContext(ctx, "select r.settingID, r.room from settingRoom r join setting s on s.id = r.settingID where s.zoneID=? order by r.room", zoneID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rooms := make(map[int64][]string)
+	for rows.Next() {
+		var id int64
+		var room string
+		if err := rows.Scan(&id, &room); err != nil {
+			return nil, err
+		}
+		rooms[id] = append(rooms[id], room)
+	}
+
+	return rooms, rows.Err()
+}
+
+// ValidateRooms checks the rooms a setting lists
+func ValidateRooms(rooms []string) error {
+	for _, room := range rooms {
+		if room == "" {
+			return errors.New("room names must not be empty")
+		}
+	}
+	return nil
+}
+
+// SetRooms replaces the rooms listed for the setting
+func (s *Setting) SetRooms(ctx context.Context, rooms []string) error {
+	if err := ValidateRooms(rooms); err != nil {
+		return err
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // does nothing once committed
+
+	if _, err := tx.ExecContext(ctx, "delete from settingRoom where settingID=?", s.ID); err != nil {
+		return err
+	}
+	if err := addRooms(ctx, tx, s.ID, rooms); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.Rooms = rooms
+	return nil
+}
+
+func addRooms(ctx context.Context, tx *sql.Tx, settingID int64, rooms []string) error {
+	for _, room := range rooms {
+		if _, err := tx.ExecContext(ctx, "insert or ignore into settingRoom (settingID, room) values (?, ?)", settingID, room); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func allPriority(ctx context.Context, zoneID int64, priority Priority) ([]Setting, error) {
@@ -148,8 +346,8 @@ func Validate(ctx context.Context, setting Setting) error {
 	if !setting.StartDay.Before(setting.EndDay) {
 		return errors.New("setting start must be before setting end")
 	}
-	if setting.EndTime <= setting.StartTime {
-		return errors.New("setting end time must be after start time")
+	if setting.EndTime == setting.StartTime {
+		return errors.New("setting end time must be different from its start time")
 	}
 	if setting.DayOfWeek == 0 {
 		return errors.New("setting must be active on at least one day of the week")
@@ -170,7 +368,7 @@ func Validate(ctx context.Context, setting Setting) error {
 }
 
 func New(ctx context.Context, zoneID, modeID int64, priority Priority, dayOfWeek int, startDay, endDay time.Time, startTime, endTime int) (Setting, error) {
-	s := Setting{
+	return Add(ctx, Setting{
 		ZoneID:    zoneID,
 		ModeID:    modeID,
 		Priority:  priority,
@@ -179,19 +377,43 @@ func New(ctx context.Context, zoneID, modeID int64, priority Priority, dayOfWeek
 		EndDay:    endDay,
 		StartTime: startTime,
 		EndTime:   endTime,
+	})
+}
+
+// Add saves a new setting together with the rooms it lists, all or nothing
+func Add(ctx context.Context, s Setting) (Setting, error) {
+	s.ID = 0
+	if err := ValidateRooms(s.Rooms); err != nil {
+		return Setting{}, err
 	}
+	// validate before starting the transaction: it holds the only connection
 	if err := Validate(ctx, s); err != nil {
 		return Setting{}, err
 	}
 
-	result, err := db.DB.ExecContext(ctx, "insert into setting (zoneID, modeID, priority, dayOfWeek, startDay, endDay, startTime, endTime) values (?, ?, ?, ?, ?, ?, ?, ?)", zoneID, modeID, priority, dayOfWeek, startDay, endDay, startTime, endTime)
+	tx, err := db.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return Setting{}, err
 	}
+	defer tx.Rollback() // does nothing once committed
 
-	s.ID, err = result.LastInsertId()
+	result, err := tx.ExecContext(ctx, "insert into setting (zoneID, modeID, priority, dayOfWeek, startDay, endDay, startTime, endTime) values (?, ?, ?, ?, ?, ?, ?, ?)", s.ZoneID, s.ModeID, s.Priority, s.DayOfWeek, s.StartDay, s.EndDay, s.StartTime, s.EndTime)
+	if err != nil {
+		return Setting{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Setting{}, err
+	}
+	if err := addRooms(ctx, tx, id, s.Rooms); err != nil {
+		return Setting{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Setting{}, err
+	}
 
-	return s, err
+	s.ID = id
+	return s, nil
 }
 
 func (s Setting) Delete(ctx context.Context) error {
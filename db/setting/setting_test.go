@@ -22,7 +22,7 @@ func TestAddSetting(t *testing.T) {
 	z, err := zone.New(ctx, t.Name())
 	require.NoError(t, err)
 
-	m, err := mode.New(ctx, z.ID, t.Name(), 60, 80, 1)
+	m, err := mode.New(ctx, z.ID, t.Name(), 60, 80, 1, mode.FanAuto, 0, 0, 0, 0)
 	require.NoError(t, err)
 	s, err := New(ctx, z.ID, m.ID, DEFAULT, 1, now, later, 1, 86400)
 	require.NoError(t, err)
@@ -51,7 +51,7 @@ func TestSetting_Delete(t *testing.T) {
 
 	z, err := zone.New(ctx, t.Name())
 	require.NoError(t, err)
-	m, err := mode.New(ctx, z.ID, t.Name(), 70, 80, 1)
+	m, err := mode.New(ctx, z.ID, t.Name(), 70, 80, 1, mode.FanAuto, 0, 0, 0, 0)
 	require.NoError(t, err)
 
 	s, err := New(ctx, z.ID, m.ID, SCHEDULED, 1, time.Now(), time.Now().Add(time.Minute), 0, 50)
@@ -130,6 +130,42 @@ func TestSetting_Runtime(t *testing.T) {
 			dayOfWeek: []time.Weekday{time.Sunday, time.Monday, time.Wednesday, time.Thursday, time.Friday, time.Saturday},
 			expected:  time.Date(now.Year(), now.Month(), now.Day()+1, 4, 0, 0, 0, now.Location()),
 		},
+		{
+			name:      "wraps from yesterday",
+			start:     now.AddDate(0, 0, -10),
+			end:       now.AddDate(0, 0, 10),
+			startTime: 60 * 60 * 20,
+			endTime:   60 * 60 * 13,
+			dayOfWeek: []time.Weekday{time.Monday},
+			expected:  now,
+		},
+		{
+			name:      "wraps from today",
+			start:     now.AddDate(0, 0, -10),
+			end:       now.AddDate(0, 0, 10),
+			startTime: 60 * 60 * 22,
+			endTime:   60 * 60 * 6,
+			dayOfWeek: []time.Weekday{time.Tuesday},
+			expected:  time.Date(now.Year(), now.Month(), now.Day(), 22, 0, 0, 0, now.Location()),
+		},
+		{
+			name:      "wrapped this morning",
+			start:     now.AddDate(0, 0, -10),
+			end:       now.AddDate(0, 0, 10),
+			startTime: 60 * 60 * 22,
+			endTime:   60 * 60 * 6,
+			dayOfWeek: []time.Weekday{time.Monday},
+			expected:  time.Date(now.Year(), now.Month(), now.Day()+6, 22, 0, 0, 0, now.Location()),
+		},
+		{
+			name:      "wraps past the end of the week",
+			start:     now.AddDate(0, 0, -10),
+			end:       now.AddDate(0, 0, 10),
+			startTime: 60 * 60 * 22,
+			endTime:   60 * 60 * 6,
+			dayOfWeek: []time.Weekday{time.Saturday},
+			expected:  time.Date(now.Year(), now.Month(), now.Day()+4, 22, 0, 0, 0, now.Location()),
+		},
 	}
 
 	for i, tt := range tests {
@@ -154,6 +190,28 @@ func TestSetting_Runtime(t *testing.T) {
 	}
 }
 
+func TestSetting_RuntimeWeekBoundary(t *testing.T) {
+	t.Parallel()
+
+	sunday := time.Date(2020, 1, 5, 0, 0, 0, 0, time.Local)
+	s := Setting{
+		DayOfWeek: WeekdayMask(time.Saturday),
+		StartDay:  sunday.AddDate(0, 0, -10),
+		EndDay:    sunday.AddDate(0, 0, 10),
+		StartTime: 60 * 60 * 22,
+		EndTime:   60 * 60 * 6,
+	}
+
+	// early Sunday is still Saturday night's setting
+	early := sunday.Add(time.Hour * 3)
+	assert.Equal(t, early, s.Runtime(early))
+	assert.True(t, s.Active(early))
+
+	later := sunday.Add(time.Hour * 7)
+	assert.Equal(t, time.Date(2020, 1, 11, 22, 0, 0, 0, time.Local), s.Runtime(later))
+	assert.False(t, s.Active(later))
+}
+
 func TestOverlaps(t *testing.T) {
 	t.Parallel()
 	ctx := context.TODO()
@@ -163,11 +221,11 @@ func TestOverlaps(t *testing.T) {
 	require.NoError(t, err)
 	z2, err := zone.New(ctx, t.Name()+"2")
 	require.NoError(t, err)
-	m1, err := mode.New(ctx, z1.ID, t.Name()+"1", 70, 80, 1)
+	m1, err := mode.New(ctx, z1.ID, t.Name()+"1", 70, 80, 1, mode.FanAuto, 0, 0, 0, 0)
 	require.NoError(t, err)
-	m2, err := mode.New(ctx, z1.ID, t.Name()+"2", 71, 79, 2)
+	m2, err := mode.New(ctx, z1.ID, t.Name()+"2", 71, 79, 2, mode.FanAuto, 0, 0, 0, 0)
 	require.NoError(t, err)
-	m3, err := mode.New(ctx, z2.ID, t.Name()+"1", 70, 80, 1)
+	m3, err := mode.New(ctx, z2.ID, t.Name()+"1", 70, 80, 1, mode.FanAuto, 0, 0, 0, 0)
 	require.NoError(t, err)
 	existing, err := New(ctx, z1.ID, m1.ID, SCHEDULED, WeekdayMask(time.Monday)|WeekdayMask(time.Wednesday), now, now.Add(time.Hour*24*30), 32400, 61200) // 9 to 5 monday and wednesday for the next 30 days
 	require.NoError(t, err)
@@ -212,7 +270,7 @@ func TestOverlaps(t *testing.T) {
 		},
 		{
 			name:      "before",
-			startTime: 0,
+			startTime: 1, // 0 would leave the existing start time, wrapping past midnight
 			endTime:   existing.StartTime - 1,
 			overlap:   false,
 		},
@@ -270,6 +328,32 @@ func TestOverlaps(t *testing.T) {
 			endTime:   existing.EndTime - 1,
 			overlap:   true,
 		},
+		{
+			name:      "wraps into time",
+			weekdays:  []time.Weekday{time.Sunday},
+			startTime: 60 * 60 * 22,
+			endTime:   existing.StartTime + 1,
+			overlap:   true,
+		},
+		{
+			name:      "wraps before time",
+			weekdays:  []time.Weekday{time.Sunday},
+			startTime: 60 * 60 * 22,
+			endTime:   existing.StartTime - 1,
+			overlap:   false,
+		},
+		{
+			name:      "wraps after time",
+			startTime: existing.EndTime + 1,
+			endTime:   existing.StartTime - 1,
+			overlap:   false,
+		},
+		{
+			name:      "wraps from time",
+			startTime: existing.EndTime,
+			endTime:   existing.StartTime - 1,
+			overlap:   true,
+		},
 	}
 
 	for i, tt := range tests {
@@ -325,6 +409,45 @@ func TestOverlaps(t *testing.T) {
 	}
 }
 
+func TestOverlapsWeekBoundary(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	saturdayNight := Setting{
+		ZoneID:    1,
+		Priority:  SCHEDULED,
+		DayOfWeek: WeekdayMask(time.Saturday),
+		StartDay:  now,
+		EndDay:    now.AddDate(0, 0, 30),
+		StartTime: 60 * 60 * 22,
+		EndTime:   60 * 60 * 6,
+	}
+
+	tests := []struct {
+		name               string
+		weekday            time.Weekday
+		startTime, endTime int
+		overlap            bool
+	}{
+		{"sunday morning", time.Sunday, 60 * 60 * 5, 60 * 60 * 7, true},
+		{"sunday after", time.Sunday, 60 * 60 * 7, 60 * 60 * 9, false},
+		{"sunday night", time.Sunday, 60 * 60 * 23, 60 * 60 * 1, false},
+		{"saturday", time.Saturday, 0, 60 * 60 * 24, true},
+		{"saturday morning", time.Saturday, 0, 60 * 60 * 8, false},
+		{"friday night", time.Friday, 60 * 60 * 22, 60 * 60 * 6, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := saturdayNight
+			s.DayOfWeek = WeekdayMask(tt.weekday)
+			s.StartTime, s.EndTime = tt.startTime, tt.endTime
+			assert.Equal(t, tt.overlap, Overlaps(saturdayNight, s))
+			assert.Equal(t, tt.overlap, Overlaps(s, saturdayNight))
+		})
+	}
+}
+
 func TestValidate(t *testing.T) {
 	t.Parallel()
 	ctx := context.TODO()
@@ -332,9 +455,9 @@ func TestValidate(t *testing.T) {
 
 	z1, err := zone.New(ctx, t.Name()+"1")
 	require.NoError(t, err)
-	m1, err := mode.New(ctx, z1.ID, t.Name()+"1", 70, 80, 1)
+	m1, err := mode.New(ctx, z1.ID, t.Name()+"1", 70, 80, 1, mode.FanAuto, 0, 0, 0, 0)
 	require.NoError(t, err)
-	m2, err := mode.New(ctx, z1.ID, t.Name()+"2", 71, 79, 2)
+	m2, err := mode.New(ctx, z1.ID, t.Name()+"2", 71, 79, 2, mode.FanAuto, 0, 0, 0, 0)
 	require.NoError(t, err)
 	existing, err := New(ctx, z1.ID, m1.ID, SCHEDULED, WeekdayMask(time.Monday)|WeekdayMask(time.Wednesday), now, now.Add(time.Hour*24*30), 32400, 61200) // 9 to 5 monday and wednesday for the next 30 days
 	require.NoError(t, err)
@@ -360,11 +483,24 @@ func TestValidate(t *testing.T) {
 			err:      "setting start must be before setting end",
 		},
 		{
-			name:      "backward time",
+			name:      "wraps past midnight",
+			weekdays:  []time.Weekday{time.Tuesday},
+			startTime: existing.EndTime,
+			endTime:   existing.StartTime - 1,
+		},
+		{
+			name:      "wraps into overlap",
 			weekdays:  []time.Weekday{time.Tuesday},
 			startTime: existing.EndTime,
 			endTime:   existing.StartTime,
-			err:       "setting end time must be after start time",
+			err:       fmt.Sprintf("new setting overlaps with setting %d", existing.ID),
+		},
+		{
+			name:      "same time",
+			weekdays:  []time.Weekday{time.Tuesday},
+			startTime: existing.StartTime,
+			endTime:   existing.StartTime,
+			err:       "setting end time must be different from its start time",
 		},
 		{
 			name: "no days",
@@ -423,3 +559,55 @@ func TestWeekdayMask(t *testing.T) {
 		assert.Equal(t, int(math.Pow(2, float64(i+1))), WeekdayMask(i), "Day %s", i)
 	}
 }
+
+func TestSetting_Rooms(t *testing.T) {
+	t.Parallel()
+	ctx := context.TODO()
+
+	z, err := zone.New(ctx, t.Name())
+	require.NoError(t, err)
+	m, err := mode.New(ctx, z.ID, t.Name(), 70, 80, 1, mode.FanAuto, 0, 0, 0, 0)
+	require.NoError(t, err)
+	s, err := New(ctx, z.ID, m.ID, SCHEDULED, 1, time.Now(), time.Now().Add(time.Minute), 0, 50)
+	require.NoError(t, err)
+
+	require.NoError(t, s.SetRooms(ctx, []string{"office", "bedroom"}))
+	settings, err := All(ctx, z.ID)
+	require.NoError(t, err)
+	require.Len(t, settings, 1)
+	assert.Equal(t, []string{"bedroom", "office"}, settings[0].Rooms)
+
+	require.NoError(t, s.SetRooms(ctx, nil))
+	settings, err = All(ctx, z.ID)
+	require.NoError(t, err)
+	assert.Empty(t, settings[0].Rooms)
+
+	assert.Error(t, s.SetRooms(ctx, []string{""}))
+}
+
+func TestAdd_Rooms(t *testing.T) {
+	t.Parallel()
+	ctx := context.TODO()
+
+	z, err := zone.New(ctx, t.Name())
+	require.NoError(t, err)
+	m, err := mode.New(ctx, z.ID, t.Name(), 70, 80, 1, mode.FanAuto, 0, 0, 0, 0)
+	require.NoError(t, err)
+	s := Setting{ZoneID: z.ID, ModeID: m.ID, Priority: SCHEDULED, DayOfWeek: 1, StartDay: time.Now(), EndDay: time.Now().Add(time.Minute), StartTime: 0, EndTime: 50, Rooms: []string{""}}
+
+	_, err = Add(ctx, s)
+	assert.Error(t, err)
+	settings, err := All(ctx, z.ID)
+	require.NoError(t, err)
+	assert.Empty(t, settings, "nothing is saved when the rooms are invalid")
+
+	s.Rooms = []string{"office", "bedroom"}
+	s, err = Add(ctx, s)
+	require.NoError(t, err)
+	assert.NotZero(t, s.ID)
+	settings, err = All(ctx, z.ID)
+	require.NoError(t, err)
+	require.Len(t, settings, 1)
+	assert.Equal(t, s.ID, settings[0].ID)
+	assert.Equal(t, []string{"bedroom", "office"}, settings[0].Rooms)
+}
@@ -0,0 +1,59 @@
+package setting
+
+import (
+	"sort"
+	"time"
+)
+
+// Schedules run on the wall clock, in the location of the time they're checked against. Days aren't always 24 hours,
+// so schedule times are found by date and time of day instead of by adding seconds to midnight. When the clock springs
+// forward, times in the skipped hour happen when the clock jumps past them. When it falls back, times in the repeated
+// hour happen the first time the clock reads them, and a schedule is active for as long as the clock reads a time in its
+// range, both times around.
+
+// daySeconds returns the seconds since midnight shown on t's wall clock
+func daySeconds(t time.Time) int {
+	hour, min, sec := t.Clock()
+	return hour*60*60 + min*60 + sec
+}
+
+// wallClock returns the first time on day's date, in day's location, that the wall clock reads sec seconds past
+// midnight. 86400 is midnight at the end of the day.
+func wallClock(day time.Time, sec int) time.Time {
+	year, month, date := day.Date()
+	loc := day.Location()
+	target := time.Date(year, month, date, 0, 0, sec, 0, time.UTC)
+	t := time.Date(year, month, date, 0, 0, sec, 0, loc)
+
+	diff := naive(t).Sub(target)
+	if diff == 0 {
+		// a repeated time reads the same an offset change earlier
+		_, offset := t.Zone()
+		_, before := t.Add(-time.Hour * 12).Zone()
+		if earlier := t.Add(time.Duration(offset-before) * time.Second); earlier.Before(t) && naive(earlier).Equal(target) {
+			return earlier
+		}
+		return t
+	}
+
+	// the time was skipped, so find when the clock jumped past it
+	low := t
+	if diff > 0 {
+		low = t.Add(-diff)
+	}
+	seconds := int(diff.Seconds())
+	if seconds < 0 {
+		seconds = -seconds
+	}
+	i := sort.Search(seconds+1, func(i int) bool {
+		return !naive(low.Add(time.Duration(i) * time.Second)).Before(target)
+	})
+	return low.Add(time.Duration(i) * time.Second)
+}
+
+// naive returns what t's wall clock reads, as if it were UTC, so wall clock times can be compared across offset changes
+func naive(t time.Time) time.Time {
+	year, month, day := t.Date()
+	hour, min, sec := t.Clock()
+	return time.Date(year, month, day, hour, min, sec, 0, time.UTC)
+}
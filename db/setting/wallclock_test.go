@@ -0,0 +1,153 @@
+package setting
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func chicago(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation("America/Chicago")
+	require.NoError(t, err)
+	return loc
+}
+
+func TestWallClock(t *testing.T) {
+	t.Parallel()
+	loc := chicago(t)
+	cdt := time.FixedZone("CDT", -5*60*60)
+	cst := time.FixedZone("CST", -6*60*60)
+
+	tests := []struct {
+		name     string
+		day      time.Time
+		sec      int
+		expected time.Time
+	}{
+		{"ordinary day", time.Date(2020, 6, 1, 12, 0, 0, 0, loc), 6 * 60 * 60, time.Date(2020, 6, 1, 6, 0, 0, 0, cdt)},
+		{"end of day", time.Date(2020, 6, 1, 12, 0, 0, 0, loc), 24 * 60 * 60, time.Date(2020, 6, 2, 0, 0, 0, 0, cdt)},
+		{"before spring forward", time.Date(2020, 3, 8, 12, 0, 0, 0, loc), 60 * 60, time.Date(2020, 3, 8, 1, 0, 0, 0, cst)},
+		{"skipped", time.Date(2020, 3, 8, 12, 0, 0, 0, loc), 2*60*60 + 30*60, time.Date(2020, 3, 8, 3, 0, 0, 0, cdt)},
+		{"start of skipped hour", time.Date(2020, 3, 8, 12, 0, 0, 0, loc), 2 * 60 * 60, time.Date(2020, 3, 8, 3, 0, 0, 0, cdt)},
+		{"after spring forward", time.Date(2020, 3, 8, 12, 0, 0, 0, loc), 3 * 60 * 60, time.Date(2020, 3, 8, 3, 0, 0, 0, cdt)},
+		{"repeated", time.Date(2020, 11, 1, 12, 0, 0, 0, loc), 60*60 + 30*60, time.Date(2020, 11, 1, 1, 30, 0, 0, cdt)},
+		{"after fall back", time.Date(2020, 11, 1, 12, 0, 0, 0, loc), 2 * 60 * 60, time.Date(2020, 11, 1, 2, 0, 0, 0, cst)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := wallClock(tt.day, tt.sec)
+			assert.True(t, tt.expected.Equal(actual), "expected %s, got %s", tt.expected, actual)
+		})
+	}
+}
+
+func TestWallClock_SkippedMidnight(t *testing.T) {
+	t.Parallel()
+	loc, err := time.LoadLocation("America/Santiago")
+	require.NoError(t, err)
+
+	// Chile springs forward at midnight
+	actual := wallClock(time.Date(2020, 9, 6, 12, 0, 0, 0, loc), 0)
+	assert.True(t, time.Date(2020, 9, 6, 1, 0, 0, 0, time.FixedZone("-03", -3*60*60)).Equal(actual), actual.String())
+}
+
+func TestSetting_RuntimeDST(t *testing.T) {
+	t.Parallel()
+	loc := chicago(t)
+	everyDay := 255
+
+	tests := []struct {
+		name               string
+		now                time.Time
+		startTime, endTime int
+		expected           time.Time
+	}{
+		{
+			name:      "morning after spring forward",
+			now:       time.Date(2020, 3, 7, 12, 0, 0, 0, loc),
+			startTime: 6 * 60 * 60,
+			endTime:   8 * 60 * 60,
+			expected:  time.Date(2020, 3, 8, 6, 0, 0, 0, loc),
+		},
+		{
+			name:      "morning after fall back",
+			now:       time.Date(2020, 10, 31, 12, 0, 0, 0, loc),
+			startTime: 6 * 60 * 60,
+			endTime:   8 * 60 * 60,
+			expected:  time.Date(2020, 11, 1, 6, 0, 0, 0, loc),
+		},
+		{
+			name:      "starts in skipped hour",
+			now:       time.Date(2020, 3, 8, 0, 0, 0, 0, loc),
+			startTime: 2*60*60 + 30*60,
+			endTime:   5 * 60 * 60,
+			expected:  time.Date(2020, 3, 8, 3, 0, 0, 0, loc),
+		},
+		{
+			name:      "entirely in skipped hour",
+			now:       time.Date(2020, 3, 8, 0, 0, 0, 0, loc),
+			startTime: 2 * 60 * 60,
+			endTime:   2*60*60 + 30*60,
+			expected:  time.Date(2020, 3, 9, 2, 0, 0, 0, loc),
+		},
+		{
+			name:      "starts in repeated hour",
+			now:       time.Date(2020, 11, 1, 0, 0, 0, 0, loc),
+			startTime: 60*60 + 30*60,
+			endTime:   5 * 60 * 60,
+			expected:  time.Date(2020, 11, 1, 1, 30, 0, 0, time.FixedZone("CDT", -5*60*60)),
+		},
+		{
+			name:      "wraps over spring forward",
+			now:       time.Date(2020, 3, 7, 12, 0, 0, 0, loc),
+			startTime: 22 * 60 * 60,
+			endTime:   6 * 60 * 60,
+			expected:  time.Date(2020, 3, 7, 22, 0, 0, 0, loc),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Setting{
+				DayOfWeek: everyDay,
+				StartDay:  tt.now.AddDate(0, 0, -10),
+				EndDay:    tt.now.AddDate(0, 0, 10),
+				StartTime: tt.startTime,
+				EndTime:   tt.endTime,
+			}
+			actual := s.Runtime(tt.now)
+			assert.True(t, tt.expected.Equal(actual), "expected %s, got %s", tt.expected, actual)
+		})
+	}
+}
+
+func TestSetting_ActiveDST(t *testing.T) {
+	t.Parallel()
+	loc := chicago(t)
+
+	// 01:00 to 01:45 on Sundays
+	s := Setting{
+		DayOfWeek: WeekdayMask(time.Sunday),
+		StartDay:  time.Date(2020, 1, 1, 0, 0, 0, 0, loc),
+		EndDay:    time.Date(2021, 1, 1, 0, 0, 0, 0, loc),
+		StartTime: 60 * 60,
+		EndTime:   60*60 + 45*60,
+	}
+
+	// the clock reads 01:30 twice when it falls back, and the schedule runs both times
+	first := time.Date(2020, 11, 1, 6, 30, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+	assert.Equal(t, "01:30 CDT", first.In(loc).Format("15:04 MST"))
+	assert.Equal(t, "01:30 CST", second.In(loc).Format("15:04 MST"))
+	assert.True(t, s.Active(first.In(loc)))
+	assert.False(t, s.Active(first.Add(time.Minute*20).In(loc)))
+	assert.True(t, s.Active(second.In(loc)))
+
+	// the clock skips 02:00 to 03:00 when it springs forward, so the schedule still ends at 01:45
+	spring := time.Date(2020, 3, 8, 1, 50, 0, 0, loc)
+	assert.False(t, s.Active(spring))
+	assert.True(t, s.Active(spring.Add(-time.Minute*10)))
+}
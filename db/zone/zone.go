@@ -22,26 +22,41 @@ func Get(ctx context.Context, name string) (Zone, error) {
 }
 
 func All(ctx context.Context) ([]json.RawMessage, error) {
+	list, err := List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	zones := make([]json.RawMessage, 0, len(list))
+	for _, z := range list {
+		data, err := json.Marshal(z)
+		if err != nil {
+			return nil, err
+		}
+		zones = append(zones, data)
+	}
+
+	return zones, nil
+}
+
+// List returns every zone
+func List(ctx context.Context) ([]Zone, error) {
 	rows, err := db.DB.QueryContext(ctx, "select id, name from zone")
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	zones := make([]json.RawMessage, 0, 4)
+	zones := make([]Zone, 0, 4)
 	for rows.Next() {
 		var z Zone
 		if err := rows.Scan(&z.ID, &z.Name); err != nil {
 			return nil, err
 		}
-		data, err := json.Marshal(z)
-		if err != nil {
-			return nil, err
-		}
-		zones = append(zones, data)
+		zones = append(zones, z)
 	}
 
-	return zones, nil
+	return zones, rows.Err()
 }
 
 func New(ctx context.Context, name string) (Zone, error) {
@@ -66,3 +81,30 @@ func (z Zone) Delete(ctx context.Context) error {
 	_, err := db.DB.ExecContext(ctx, "delete from zone where id=?", z.ID)
 	return err
 }
+
+// Rates returns the heating and cooling rates learned for a zone, in degrees per hour, by kind
+func Rates(ctx context.Context, zoneID int64) (map[string]float64, error) {
+	rows, err := db.DB.QueryContext(ctx, "select kind, rate from zoneRate where zoneID=?", zoneID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := make(map[string]float64)
+	for rows.Next() {
+		var kind string
+		var rate float64
+		if err := rows.Scan(&kind, &rate); err != nil {
+			return nil, err
+		}
+		rates[kind] = rate
+	}
+
+	return rates, rows.Err()
+}
+
+// SetRate saves a rate learned for a zone, in degrees per hour
+func SetRate(ctx context.Context, zoneID int64, kind string, rate float64) error {
+	_, err := db.DB.ExecContext(ctx, "insert into zoneRate (zoneID, kind, rate) values (?, ?, ?) on conflict(zoneID, kind) do update set rate=excluded.rate", zoneID, kind, rate)
+	return err
+}
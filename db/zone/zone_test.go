@@ -56,3 +56,23 @@ func TestZone_Delete(t *testing.T) {
 	require.NoError(t, err)
 	assert.False(t, zoneExists(t, ctx, z.ID))
 }
+
+func TestRates(t *testing.T) {
+	t.Parallel()
+	ctx := context.TODO()
+
+	z, err := New(ctx, t.Name())
+	require.NoError(t, err)
+
+	rates, err := Rates(ctx, z.ID)
+	require.NoError(t, err)
+	assert.Empty(t, rates)
+
+	require.NoError(t, SetRate(ctx, z.ID, "heating", 4))
+	require.NoError(t, SetRate(ctx, z.ID, "cooling", 2))
+	require.NoError(t, SetRate(ctx, z.ID, "heating", 5))
+
+	rates, err = Rates(ctx, z.ID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"heating": 5, "cooling": 2}, rates)
+}
@@ -3,6 +3,7 @@ module thermostat
 go 1.14
 
 require (
+	github.com/eclipse/paho.mqtt.golang v1.2.0
 	github.com/fsnotify/fsnotify v1.4.7
 	github.com/golang-migrate/migrate/v4 v4.11.0
 	github.com/mattn/go-sqlite3 v1.13.0
@@ -24,7 +24,8 @@ func init() {
 	}
 }
 
-func Log(fan, ac, heat bool, temp, hum float64) {
+// Log records the outputs and readings. outdoor is nil when the outdoor temperature isn't known.
+func Log(fan, ac, heat bool, temp, hum float64, outdoor *float64) {
 	if logger == nil {
 		return
 	}
@@ -36,6 +37,10 @@ func Log(fan, ac, heat bool, temp, hum float64) {
 		strconv.FormatBool(heat),
 		strconv.FormatFloat(temp, 'f', 6, 64),
 		strconv.FormatFloat(hum, 'f', 6, 64),
+		"",
+	}
+	if outdoor != nil {
+		data[6] = strconv.FormatFloat(*outdoor, 'f', 6, 64)
 	}
 
 	_ = logger.Write(data)
@@ -2,13 +2,15 @@ package main
 
 import (
 	"context"
-	"encoding/hex"
 	"flag"
+	"fmt"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"os"
 	"thermostat/api"
+	"thermostat/clock"
 	"thermostat/config"
+	"thermostat/db/zone"
 	"thermostat/sensor"
 	"thermostat/system"
 )
@@ -19,8 +21,10 @@ func main() {
 
 	var test bool
 	var stop bool
+	var discover bool
 	flag.BoolVar(&test, "post", false, "Perform a power on self test of systems and sensors")
 	flag.BoolVar(&stop, "stop", false, "Turn off all relays")
+	flag.BoolVar(&discover, "discover", false, "List attached DS18B20 1-Wire temperature probes")
 	flag.Parse()
 	if test {
 		selfTest()
@@ -30,21 +34,21 @@ func main() {
 		turnOff()
 		return
 	}
+	if discover {
+		discoverProbes()
+		return
+	}
 
-	sys := system.NewHVAC(viper.GetInt("fanPin"), viper.GetInt("acPin"), viper.GetInt("heatPin"))
-
-	addr, err := hex.DecodeString(viper.GetString("tempSensor")[2:])
+	zones, err := zone.List(ctx)
 	if err != nil {
 		panic(err)
 	}
-	sens := sensor.NewHIH6020(uint16(addr[0]), viper.GetFloat64("tempCorrection"), viper.GetFloat64("temperatureRangeDivider"), viper.GetFloat64("humCorrection"))
-	zone, err := system.NewZone(ctx, "default", sys, sens)
-	if err != nil {
-		panic(err)
+	for _, z := range zones {
+		if _, err := system.StartZone(ctx, z.Name); err != nil {
+			logrus.WithError(err).WithField("zone", z.Name).Error("failed to start zone")
+		}
 	}
 
-	zone.Startup()
-
 	cert, key := loadApiCert()
 	api.StartApi(cert, key)
 }
@@ -61,17 +65,29 @@ func selfTest() {
 	logrus.SetFormatter(&logrus.TextFormatter{})
 	logrus.Info("running Power On Self Test...")
 
-	addr, err := hex.DecodeString(viper.GetString("tempSensor")[2:])
+	zones, err := zone.List(context.Background())
 	if err != nil {
 		panic(err)
 	}
-	sens := sensor.NewHIH6020(uint16(addr[0]), 0, 1, 0)
-	logrus.WithField("temp", sens.Temperature()).Info("current temp")
+	for _, z := range zones {
+		log := logrus.WithField("zone", z.Name)
+		clk := clock.New()
+		h, err := system.NewEquipment(z.Name, clk)
+		if err != nil {
+			log.WithError(err).Error("failed to load equipment")
+			continue
+		}
+		sens, err := system.NewSensor(context.Background(), z.Name, h, clk)
+		if err != nil {
+			log.WithError(err).Error("failed to load sensor")
+			continue
+		}
+		log.WithField("temp", sens.Temperature()).Info("current temp")
 
-	h := system.NewHVAC(viper.GetInt("fanPin"), viper.GetInt("acPin"), viper.GetInt("heatPin"))
-	h.Test()
+		h.Test()
 
-	logrus.WithField("temp", sens.Temperature()).Info("current temp")
+		log.WithField("temp", sens.Temperature()).Info("current temp")
+	}
 }
 
 func turnOff() {
@@ -80,6 +96,28 @@ func turnOff() {
 	logrus.SetFormatter(&logrus.TextFormatter{})
 	logrus.Info("Powering off...")
 
-	h := system.NewHVAC(viper.GetInt("fanPin"), viper.GetInt("acPin"), viper.GetInt("heatPin"))
-	h.Reset()
+	zones, err := zone.List(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	for _, z := range zones {
+		h, err := system.NewEquipment(z.Name, clock.New())
+		if err != nil {
+			logrus.WithError(err).WithField("zone", z.Name).Error("failed to load equipment")
+			continue
+		}
+		if err := h.Reset(); err != nil {
+			logrus.WithError(err).WithField("zone", z.Name).Error("failed to turn off equipment")
+		}
+	}
+}
+
+func discoverProbes() {
+	ids, err := sensor.DiscoverDS18B20(viper.GetString("w1.root"))
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to list 1-Wire devices")
+	}
+	for _, id := range ids {
+		fmt.Println(id)
+	}
 }
@@ -0,0 +1,89 @@
+package relay
+
+import (
+	"sync"
+	"time"
+)
+
+// Event is a single relay change recorded by a Journal
+type Event struct {
+	Relay string
+	On    bool
+	Time  time.Time
+}
+
+// Journal records changes to fake relays in the order they happened, so tests can check relay sequencing
+type Journal struct {
+	events []Event
+	mutex  sync.Mutex
+}
+
+func (j *Journal) add(e Event) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.events = append(j.events, e)
+}
+
+// Events returns a copy of every recorded change
+func (j *Journal) Events() []Event {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return append([]Event(nil), j.events...)
+}
+
+// Fake is an in-memory relay
+type Fake struct {
+	name    string
+	on      bool
+	err     error
+	journal *Journal
+	mutex   sync.Mutex
+}
+
+// NewFake returns an in-memory relay. Changes are recorded in journal if it is not nil.
+func NewFake(name string, journal *Journal) *Fake {
+	return &Fake{
+		name:    name,
+		journal: journal,
+	}
+}
+
+func (r *Fake) Set(on bool) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+
+	r.on = on
+	if r.journal != nil {
+		r.journal.add(Event{
+			Relay: r.name,
+			On:    on,
+			Time:  time.Now(),
+		})
+	}
+	return nil
+}
+
+func (r *Fake) On() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.on
+}
+
+// Fail makes every following Set return err, until Fail is called again with nil
+func (r *Fake) Fail(err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.err = err
+}
+
+func (r *Fake) String() string {
+	return r.name
+}
@@ -0,0 +1,44 @@
+package relay
+
+import (
+	"fmt"
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/gpio/gpioreg"
+	"periph.io/x/periph/host"
+)
+
+type gpioRelay struct {
+	pin    gpio.PinIO
+	invert bool
+}
+
+// NewGPIO returns a relay on the given GPIO pin, using periph. If invert is set, the relay is active low.
+func NewGPIO(num int, invert bool) (Relay, error) {
+	if _, err := host.Init(); err != nil {
+		return nil, err
+	}
+
+	name := fmt.Sprintf("GPIO%d", num)
+	pin := gpioreg.ByName(name)
+	if pin == nil {
+		return nil, fmt.Errorf("failed to find pin %s", name)
+	}
+
+	return NewPin(pin, invert), nil
+}
+
+// NewPin returns a relay on the given periph pin. If invert is set, the relay is active low.
+func NewPin(pin gpio.PinIO, invert bool) Relay {
+	return gpioRelay{
+		pin:    pin,
+		invert: invert,
+	}
+}
+
+func (r gpioRelay) Set(on bool) error {
+	return r.pin.Out(gpio.Level(on != r.invert))
+}
+
+func (r gpioRelay) String() string {
+	return r.pin.String()
+}
@@ -0,0 +1,86 @@
+package relay
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// see linux/gpio.h
+const (
+	gpioHandlesMax            = 64
+	gpioHandleRequestOutput   = 1 << 1
+	gpioGetLineHandleIoctl    = 3<<30 | uint32(unsafe.Sizeof(gpioHandleRequest{}))<<16 | 0xB4<<8 | 0x03
+	gpioHandleSetLineValIoctl = 3<<30 | uint32(unsafe.Sizeof(gpioHandleData{}))<<16 | 0xB4<<8 | 0x09
+)
+
+type gpioHandleRequest struct {
+	lineOffsets   [gpioHandlesMax]uint32
+	flags         uint32
+	defaultValues [gpioHandlesMax]uint8
+	consumerLabel [32]byte
+	lines         uint32
+	fd            int32
+}
+
+type gpioHandleData struct {
+	values [gpioHandlesMax]uint8
+}
+
+type gpioChipRelay struct {
+	chip   string
+	line   int
+	invert bool
+	handle *os.File
+}
+
+// NewGPIOChip returns a relay on a line of a Linux gpiochip character device, like /dev/gpiochip0.
+// If invert is set, the relay is active low.
+func NewGPIOChip(chip string, line int, invert bool) (Relay, error) {
+	f, err := os.OpenFile(chip, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	req := gpioHandleRequest{
+		flags: gpioHandleRequestOutput,
+		lines: 1,
+	}
+	req.lineOffsets[0] = uint32(line)
+	if invert {
+		req.defaultValues[0] = 1
+	}
+	copy(req.consumerLabel[:], "thermostat")
+
+	if err := ioctl(f.Fd(), gpioGetLineHandleIoctl, unsafe.Pointer(&req)); err != nil {
+		return nil, fmt.Errorf("unable to request line %d of %s: %w", line, chip, err)
+	}
+
+	return &gpioChipRelay{
+		chip:   chip,
+		line:   line,
+		invert: invert,
+		handle: os.NewFile(uintptr(req.fd), fmt.Sprintf("%s:%d", chip, line)),
+	}, nil
+}
+
+func (r *gpioChipRelay) Set(on bool) error {
+	var data gpioHandleData
+	if on != r.invert {
+		data.values[0] = 1
+	}
+	return ioctl(r.handle.Fd(), gpioHandleSetLineValIoctl, unsafe.Pointer(&data))
+}
+
+func (r *gpioChipRelay) String() string {
+	return fmt.Sprintf("%s:%d", r.chip, r.line)
+}
+
+func ioctl(fd uintptr, req uint32, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(req), uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package relay
+
+import (
+	"errors"
+)
+
+// NewGPIOChip is only supported on Linux
+func NewGPIOChip(_ string, _ int, _ bool) (Relay, error) {
+	return nil, errors.New("gpiochip relays require linux")
+}
@@ -0,0 +1,60 @@
+package relay
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var httpClient = &http.Client{
+	Timeout: 5 * time.Second,
+}
+
+type httpRelay struct {
+	method string
+	on     string
+	off    string
+}
+
+// NewHTTP returns a relay on a network relay board that is switched by requesting one url to turn it on and another
+// to turn it off. method defaults to GET.
+func NewHTTP(method, on, off string) (Relay, error) {
+	if method == "" {
+		method = http.MethodGet
+	}
+	if on == "" || off == "" {
+		return nil, fmt.Errorf("http relay needs both an on and an off url")
+	}
+
+	return httpRelay{
+		method: method,
+		on:     on,
+		off:    off,
+	}, nil
+}
+
+func (r httpRelay) Set(on bool) error {
+	url := r.off
+	if on {
+		url = r.on
+	}
+
+	req, err := http.NewRequest(r.method, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("relay %s responded with %s", r, resp.Status)
+	}
+	return nil
+}
+
+func (r httpRelay) String() string {
+	return r.on
+}
@@ -0,0 +1,56 @@
+package relay
+
+import (
+	"fmt"
+	"thermostat/broker"
+	"time"
+)
+
+type mqttRelay struct {
+	topic string
+	on    string
+	off   string
+}
+
+// NewMQTT returns a relay that is switched by publishing the on or off payload to a topic on the configured MQTT
+// broker. The payloads default to ON and OFF.
+func NewMQTT(topic, on, off string) (Relay, error) {
+	if topic == "" {
+		return nil, fmt.Errorf("mqtt relay needs a topic")
+	}
+	if on == "" {
+		on = "ON"
+	}
+	if off == "" {
+		off = "OFF"
+	}
+
+	return mqttRelay{
+		topic: topic,
+		on:    on,
+		off:   off,
+	}, nil
+}
+
+func (r mqttRelay) Set(on bool) error {
+	client, err := broker.Client()
+	if err != nil {
+		return err
+	}
+
+	payload := r.off
+	if on {
+		payload = r.on
+	}
+
+	// retain the message so the relay board picks up the current state if it reconnects
+	token := client.Publish(r.topic, 1, true, payload)
+	if !token.WaitTimeout(5 * time.Second) {
+		return fmt.Errorf("timed out publishing to %s", r.topic)
+	}
+	return token.Error()
+}
+
+func (r mqttRelay) String() string {
+	return r.topic
+}
@@ -0,0 +1,38 @@
+package relay
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+)
+
+// Relay is a single on/off output, like the fan or compressor
+type Relay interface {
+	Set(on bool) error
+	String() string
+}
+
+// FromConfig builds the relay described by the config section at key. The type field selects the backend:
+// gpio, gpiochip, http, mqtt, or fake. Returns nil if the key is not set, so optional outputs can be left out of the config.
+func FromConfig(key string) (Relay, error) {
+	if !viper.IsSet(key) {
+		return nil, nil
+	}
+
+	switch t := viper.GetString(key + ".type"); t {
+	case "gpio":
+		return NewGPIO(viper.GetInt(key+".pin"), viper.GetBool(key+".invert"))
+	case "gpiochip":
+		return NewGPIOChip(viper.GetString(key+".chip"), viper.GetInt(key+".line"), viper.GetBool(key+".invert"))
+	case "http":
+		return NewHTTP(viper.GetString(key+".method"), viper.GetString(key+".on"), viper.GetString(key+".off"))
+	case "mqtt":
+		return NewMQTT(viper.GetString(key+".topic"), viper.GetString(key+".on"), viper.GetString(key+".off"))
+	case "fake":
+		return NewFake(key, nil), nil
+	case "":
+		return nil, errors.New(key + " is missing a relay type")
+	default:
+		return nil, fmt.Errorf("unknown relay type %s for %s", t, key)
+	}
+}
@@ -0,0 +1,81 @@
+package relay
+
+import (
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFromConfig(t *testing.T) {
+	t.Parallel()
+
+	viper.Set("relayTest.fake", map[string]interface{}{"type": "fake"})
+	viper.Set("relayTest.http", map[string]interface{}{"type": "http", "on": "http://relay/on", "off": "http://relay/off"})
+	viper.Set("relayTest.unknown", map[string]interface{}{"type": "carrier pigeon"})
+	viper.Set("relayTest.untyped", map[string]interface{}{"pin": 4})
+
+	r, err := FromConfig("relayTest.missing")
+	assert.NoError(t, err)
+	assert.Nil(t, r)
+
+	r, err = FromConfig("relayTest.fake")
+	assert.NoError(t, err)
+	assert.IsType(t, &Fake{}, r)
+
+	r, err = FromConfig("relayTest.http")
+	assert.NoError(t, err)
+	assert.IsType(t, httpRelay{}, r)
+
+	_, err = FromConfig("relayTest.unknown")
+	assert.Error(t, err)
+
+	_, err = FromConfig("relayTest.untyped")
+	assert.Error(t, err)
+}
+
+func TestHTTP(t *testing.T) {
+	t.Parallel()
+
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	r, err := NewHTTP(http.MethodPost, srv.URL+"/on", srv.URL+"/off")
+	require.NoError(t, err)
+	assert.NoError(t, r.Set(true))
+	assert.NoError(t, r.Set(false))
+	assert.Equal(t, []string{"POST /on", "POST /off"}, paths)
+
+	r, err = NewHTTP("", srv.URL+"/broken", srv.URL+"/off")
+	require.NoError(t, err)
+	assert.Error(t, r.Set(true))
+
+	_, err = NewHTTP("", srv.URL+"/on", "")
+	assert.Error(t, err)
+}
+
+func TestFake(t *testing.T) {
+	t.Parallel()
+
+	journal := &Journal{}
+	r := NewFake("test", journal)
+	assert.NoError(t, r.Set(true))
+	assert.True(t, r.On())
+
+	r.Fail(assert.AnError)
+	assert.Equal(t, assert.AnError, r.Set(false))
+	assert.True(t, r.On())
+
+	events := journal.Events()
+	require.Len(t, events, 1)
+	assert.Equal(t, "test", events[0].Relay)
+	assert.True(t, events[0].On)
+}
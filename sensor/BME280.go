@@ -0,0 +1,224 @@
+package sensor
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"periph.io/x/periph/conn"
+	"sync"
+	"time"
+)
+
+const (
+	bme280ChipID   = 0x60
+	bme280RegID    = 0xD0
+	bme280RegCalT  = 0x88 // temperature and pressure calibration, then H1
+	bme280RegCalH  = 0xE1 // the rest of the humidity calibration
+	bme280RegHum   = 0xF2
+	bme280RegMeas  = 0xF4
+	bme280RegData  = 0xF7
+	bme280Forced   = 0x25 // 1x temperature and pressure oversampling, forced mode
+	bme280Humidity = 0x01 // 1x humidity oversampling
+)
+
+// BME280 is a Bosch temperature, humidity, and pressure sensor on the I²C bus, usually at 0x76 or 0x77
+type BME280 struct {
+	conn conn.Conn
+	addr uint16
+
+	conversionTime time.Duration
+	tempOffset     float64
+	tempDivider    float64
+	humCalibration float64
+	cal            *bme280Calibration // read from the sensor the first time it's needed
+	temp           float64
+	hum            float64
+	pressure       float64
+	lastUpdate     time.Time // when the sensor was last read successfully
+	lastAttempt    time.Time
+	err            error // from the last attempt
+	mutex          sync.Mutex
+}
+
+// bme280Calibration is the trimming parameters each sensor is programmed with at the factory
+type bme280Calibration struct {
+	t1             uint16
+	t2, t3         int16
+	p1             uint16
+	p2, p3, p4, p5 int16
+	p6, p7, p8, p9 int16
+	h1, h3         uint8
+	h2, h4, h5     int16
+	h6             int8
+}
+
+func NewBME280(addr uint16, tempOffset, tempDivider, humCalibration float64) *BME280 {
+	return newBME280(openI2C(addr), addr, tempOffset, tempDivider, humCalibration)
+}
+
+func newBME280(c conn.Conn, addr uint16, tempOffset, tempDivider, humCalibration float64) *BME280 {
+	return &BME280{
+		conn:           c,
+		addr:           addr,
+		conversionTime: 10 * time.Millisecond, // 9.3ms max at 1x oversampling
+		tempOffset:     tempOffset,
+		tempDivider:    tempDivider,
+		humCalibration: humCalibration,
+	}
+}
+
+func (s *BME280) Temperature() float64 {
+	r, _ := s.Read()
+	return r.Temperature
+}
+
+func (s *BME280) Humidity() float64 {
+	r, _ := s.Read()
+	return r.Humidity
+}
+
+// Read returns the last good reading, along with the error from the latest attempt to read the sensor
+func (s *BME280) Read() (Reading, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// keep things like the /status API from pounding on the sensor
+	if time.Now().Sub(s.lastAttempt).Seconds() >= 20 {
+		s.lastAttempt = time.Now()
+		s.err = s.update()
+		if s.err != nil {
+			logrus.WithError(s.err).WithField("addr", s.addr).Error("failed to read sensor")
+		}
+	}
+
+	return Reading{Temperature: s.temp, Humidity: s.hum, Pressure: s.pressure, Time: s.lastUpdate}, s.err
+}
+
+func (s *BME280) update() error {
+	if s.cal == nil {
+		cal, err := s.calibrate()
+		if err != nil {
+			return err
+		}
+		s.cal = cal
+	}
+
+	// the humidity setting only takes effect once ctrl_meas is written, which also starts the conversion
+	if err := s.conn.Tx([]byte{bme280RegHum, bme280Humidity}, nil); err != nil {
+		return fmt.Errorf("unable to configure humidity: %w", err)
+	}
+	if err := s.conn.Tx([]byte{bme280RegMeas, bme280Forced}, nil); err != nil {
+		return fmt.Errorf("unable to start conversion: %w", err)
+	}
+	time.Sleep(s.conversionTime)
+
+	resp := make([]byte, 8)
+	if err := s.conn.Tx([]byte{bme280RegData}, resp); err != nil {
+		return fmt.Errorf("unable to get data: %w", err)
+	}
+	logrus.WithField("data", hex.EncodeToString(resp)).Debug("sensor data")
+
+	rawP := int32(resp[0])<<12 | int32(resp[1])<<4 | int32(resp[2])>>4
+	rawT := int32(resp[3])<<12 | int32(resp[4])<<4 | int32(resp[5])>>4
+	rawH := int32(resp[6])<<8 | int32(resp[7])
+
+	celcius, fine := s.cal.temperature(rawT)
+	s.temp = FarenheitFromCelcius(celcius)/s.tempDivider + s.tempOffset
+	s.hum = s.cal.humidity(rawH, fine) + s.humCalibration
+	s.pressure = s.cal.pressure(rawP, fine) / 100
+
+	s.lastUpdate = time.Now()
+	return nil
+}
+
+// calibrate checks the chip id, and reads the sensor's calibration
+func (s *BME280) calibrate() (*bme280Calibration, error) {
+	id := make([]byte, 1)
+	if err := s.conn.Tx([]byte{bme280RegID}, id); err != nil {
+		return nil, fmt.Errorf("unable to get chip id: %w", err)
+	}
+	if id[0] != bme280ChipID {
+		return nil, fmt.Errorf("unexpected chip id 0x%02X", id[0])
+	}
+
+	t := make([]byte, 26)
+	if err := s.conn.Tx([]byte{bme280RegCalT}, t); err != nil {
+		return nil, fmt.Errorf("unable to get calibration: %w", err)
+	}
+	h := make([]byte, 7)
+	if err := s.conn.Tx([]byte{bme280RegCalH}, h); err != nil {
+		return nil, fmt.Errorf("unable to get calibration: %w", err)
+	}
+	return parseBME280Calibration(t, h), nil
+}
+
+// parseBME280Calibration parses the calibration registers starting at 0x88 and 0xE1
+func parseBME280Calibration(t, h []byte) *bme280Calibration {
+	word := func(i int) uint16 {
+		return binary.LittleEndian.Uint16(t[i:])
+	}
+	return &bme280Calibration{
+		t1: word(0),
+		t2: int16(word(2)),
+		t3: int16(word(4)),
+		p1: word(6),
+		p2: int16(word(8)),
+		p3: int16(word(10)),
+		p4: int16(word(12)),
+		p5: int16(word(14)),
+		p6: int16(word(16)),
+		p7: int16(word(18)),
+		p8: int16(word(20)),
+		p9: int16(word(22)),
+		h1: t[25],
+		h2: int16(binary.LittleEndian.Uint16(h[0:])),
+		h3: h[2],
+		h4: int16(int8(h[3]))<<4 | int16(h[4]&0x0F),
+		h5: int16(int8(h[5]))<<4 | int16(h[4]>>4),
+		h6: int8(h[6]),
+	}
+}
+
+// temperature returns the temperature in degrees C, along with the fine temperature the other readings are compensated
+// with. The compensation formulas are the floating point ones from section 8.1 of the datasheet.
+func (c *bme280Calibration) temperature(raw int32) (float64, float64) {
+	adc := float64(raw)
+	var1 := (adc/16384 - float64(c.t1)/1024) * float64(c.t2)
+	var2 := (adc/131072 - float64(c.t1)/8192) * (adc/131072 - float64(c.t1)/8192) * float64(c.t3)
+	fine := var1 + var2
+	return fine / 5120, fine
+}
+
+// pressure returns the pressure in Pa
+func (c *bme280Calibration) pressure(raw int32, fine float64) float64 {
+	var1 := fine/2 - 64000
+	var2 := var1 * var1 * float64(c.p6) / 32768
+	var2 += var1 * float64(c.p5) * 2
+	var2 = var2/4 + float64(c.p4)*65536
+	var1 = (float64(c.p3)*var1*var1/524288 + float64(c.p2)*var1) / 524288
+	var1 = (1 + var1/32768) * float64(c.p1)
+	if var1 == 0 {
+		return 0 // avoid dividing by zero
+	}
+	p := 1048576 - float64(raw)
+	p = (p - var2/4096) * 6250 / var1
+	var1 = float64(c.p9) * p * p / 2147483648
+	var2 = p * float64(c.p8) / 32768
+	return p + (var1+var2+float64(c.p7))/16
+}
+
+// humidity returns the relative humidity in percent
+func (c *bme280Calibration) humidity(raw int32, fine float64) float64 {
+	h := fine - 76800
+	h = (float64(raw) - (float64(c.h4)*64 + float64(c.h5)/16384*h)) *
+		(float64(c.h2) / 65536 * (1 + float64(c.h6)/67108864*h*(1+float64(c.h3)/67108864*h)))
+	h *= 1 - float64(c.h1)*h/524288
+	if h > 100 {
+		return 100
+	}
+	if h < 0 {
+		return 0
+	}
+	return h
+}
@@ -0,0 +1,69 @@
+package sensor
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"periph.io/x/periph/conn/conntest"
+	"testing"
+)
+
+// the calibration and readings from the datasheet's example, with made up humidity calibration
+var bme280Calibrate = []conntest.IO{
+	{W: []byte{bme280RegID}, R: []byte{bme280ChipID}},
+	{W: []byte{bme280RegCalT}, R: []byte{
+		0x70, 0x6B, 0x43, 0x67, 0x18, 0xFC, 0x7D, 0x8E, 0x43, 0xD6, 0xD0, 0x0B, 0x27,
+		0x0B, 0x8C, 0x00, 0xF9, 0xFF, 0x8C, 0x3C, 0xF8, 0xC6, 0x70, 0x17, 0x00, 0x4B,
+	}},
+	{W: []byte{bme280RegCalH}, R: []byte{0x6A, 0x01, 0x00, 0x13, 0x29, 0x03, 0x1E}},
+}
+
+var bme280Measure = []conntest.IO{
+	{W: []byte{bme280RegHum, bme280Humidity}},
+	{W: []byte{bme280RegMeas, bme280Forced}},
+	{W: []byte{bme280RegData}, R: []byte{0x65, 0x5A, 0xC0, 0x7E, 0xED, 0x00, 0x75, 0x30}},
+}
+
+func TestBME280_Read(t *testing.T) {
+	t.Parallel()
+
+	bus := &conntest.Playback{Ops: append(append([]conntest.IO{}, bme280Calibrate...), bme280Measure...), DontPanic: true}
+	s := newBME280(bus, 0x76, 0, 1, 0)
+	s.conversionTime = 0
+
+	r, err := s.Read()
+	require.NoError(t, err)
+	require.NoError(t, bus.Close())
+	assert.InDelta(t, 77.1485, r.Temperature, 0.0001)
+	assert.InDelta(t, 55.0007, r.Humidity, 0.0001)
+	assert.InDelta(t, 1006.5327, r.Pressure, 0.0001)
+	assert.False(t, r.Time.IsZero())
+
+	// readings are reused for a while
+	again, err := s.Read()
+	require.NoError(t, err)
+	assert.Equal(t, r, again)
+}
+
+func TestBME280_calibration(t *testing.T) {
+	t.Parallel()
+
+	bus := &conntest.Playback{Ops: append(append([]conntest.IO{}, bme280Calibrate...), bme280Measure...), DontPanic: true}
+	s := newBME280(bus, 0x76, 2, 2, -5)
+	s.conversionTime = 0
+
+	r, err := s.Read()
+	require.NoError(t, err)
+	assert.InDelta(t, 77.1485/2+2, r.Temperature, 0.0001)
+	assert.InDelta(t, 50.0007, r.Humidity, 0.0001)
+}
+
+func TestBME280_wrongChip(t *testing.T) {
+	t.Parallel()
+
+	bus := &conntest.Playback{Ops: []conntest.IO{{W: []byte{bme280RegID}, R: []byte{0x58}}}, DontPanic: true}
+	s := newBME280(bus, 0x76, 0, 1, 0)
+
+	_, err := s.Read()
+	assert.EqualError(t, err, "unexpected chip id 0x58")
+	assert.Nil(t, s.cal)
+}
@@ -0,0 +1,141 @@
+package sensor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// W1Root is where the Linux w1 driver lists 1-Wire devices
+const W1Root = "/sys/bus/w1/devices"
+
+// DS18B20 is a 1-Wire temperature probe, read through the Linux w1 sysfs interface
+type DS18B20 struct {
+	id   string
+	path string // the probe's w1_slave file
+
+	tempOffset  float64
+	tempDivider float64
+	temp        float64
+	lastUpdate  time.Time // when the sensor was last read successfully
+	lastAttempt time.Time
+	err         error // from the last attempt
+	mutex       sync.Mutex
+}
+
+// NewDS18B20 returns the probe with the 1-Wire id, like 28-000005e2fdc3, listed under root
+func NewDS18B20(root, id string, tempOffset, tempDivider float64) *DS18B20 {
+	if root == "" {
+		root = W1Root
+	}
+	return &DS18B20{
+		id:          id,
+		path:        filepath.Join(root, id, "w1_slave"),
+		tempOffset:  tempOffset,
+		tempDivider: tempDivider,
+	}
+}
+
+// DiscoverDS18B20 returns the ids of every DS18B20 probe listed under root
+func DiscoverDS18B20(root string) ([]string, error) {
+	if root == "" {
+		root = W1Root
+	}
+	matches, err := filepath.Glob(filepath.Join(root, "28-*"))
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(matches))
+	for _, m := range matches {
+		if _, err := os.Stat(filepath.Join(m, "w1_slave")); err == nil {
+			ids = append(ids, filepath.Base(m))
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (s *DS18B20) Temperature() float64 {
+	r, _ := s.Read()
+	return r.Temperature
+}
+
+// Humidity is always 0, since the DS18B20 only measures temperature
+func (s *DS18B20) Humidity() float64 {
+	return 0
+}
+
+// HasHumidity is false, so a Composite leaves the probe out of its humidity
+func (s *DS18B20) HasHumidity() bool {
+	return false
+}
+
+// Read returns the last good reading, along with the error from the latest attempt to read the sensor
+func (s *DS18B20) Read() (Reading, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// each read makes the kernel run a conversion, which takes up to 750ms
+	if time.Now().Sub(s.lastAttempt).Seconds() >= 20 {
+		s.lastAttempt = time.Now()
+		s.err = s.update()
+		if s.err != nil {
+			logrus.WithError(s.err).WithField("id", s.id).Error("failed to read sensor")
+		}
+	}
+
+	return Reading{Temperature: s.temp, Time: s.lastUpdate}, s.err
+}
+
+func (s *DS18B20) update() error {
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	celcius, err := parseW1Slave(data)
+	if err != nil {
+		return err
+	}
+
+	s.temp = FarenheitFromCelcius(celcius)/s.tempDivider + s.tempOffset
+	s.lastUpdate = time.Now()
+	return nil
+}
+
+// parseW1Slave returns the temperature in a w1_slave file, which looks like
+//
+//	72 01 4b 46 7f ff 0e 10 57 : crc=57 YES
+//	72 01 4b 46 7f ff 0e 10 57 t=23125
+//
+// where the first line says if the scratchpad passed its CRC check, and t is in thousandths of a degree C.
+func parseW1Slave(data []byte) (float64, error) {
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	if len(lines) != 2 {
+		return 0, fmt.Errorf("unexpected w1_slave data %q", data)
+	}
+	if !bytes.HasSuffix(bytes.TrimSpace(lines[0]), []byte("YES")) {
+		return 0, errors.New("CRC check failed")
+	}
+
+	i := bytes.LastIndex(lines[1], []byte("t="))
+	if i < 0 {
+		return 0, fmt.Errorf("no temperature in w1_slave data %q", data)
+	}
+	milli, err := strconv.Atoi(string(bytes.TrimSpace(lines[1][i+2:])))
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse temperature: %w", err)
+	}
+	// the scratchpad holds 85C until the first conversion finishes, so it's what a probe that lost power reads
+	if milli == 85000 {
+		return 0, errors.New("the probe reported its power on reset value")
+	}
+	return float64(milli) / 1000, nil
+}
@@ -0,0 +1,71 @@
+package sensor
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+const w1Fixtures = "testdata/w1"
+
+func TestDiscoverDS18B20(t *testing.T) {
+	t.Parallel()
+
+	ids, err := DiscoverDS18B20(w1Fixtures)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"28-000005e2fdc3", "28-0316a2795bff", "28-0516a1cb5eff"}, ids)
+
+	ids, err = DiscoverDS18B20("testdata/missing")
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+}
+
+func TestDS18B20_Read(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		id   string
+		temp float64
+		err  string
+	}{
+		{"good reading", "28-000005e2fdc3", 73.625, ""},
+		{"failed CRC", "28-0316a2795bff", 0, "CRC check failed"},
+		{"power on reset", "28-0516a1cb5eff", 0, "the probe reported its power on reset value"},
+		{"unplugged", "28-ffffffffffff", 0, "no such file or directory"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewDS18B20(w1Fixtures, tt.id, 0, 1)
+			r, err := s.Read()
+			if tt.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				assert.True(t, r.Time.IsZero())
+				return
+			}
+			require.NoError(t, err)
+			assert.InDelta(t, tt.temp, r.Temperature, 0.001)
+			assert.Equal(t, 0.0, r.Humidity)
+			assert.False(t, r.Time.IsZero())
+		})
+	}
+
+	// calibration is applied the same way as the HIH6020's
+	s := NewDS18B20(w1Fixtures, "28-000005e2fdc3", -2, 2)
+	assert.InDelta(t, 34.8125, s.Temperature(), 0.001)
+}
+
+func TestParseW1Slave(t *testing.T) {
+	t.Parallel()
+
+	c, err := parseW1Slave([]byte("ff ff 7f 46 7f ff 01 10 aa : crc=aa YES\nff ff 7f 46 7f ff 01 10 aa t=-62\n"))
+	require.NoError(t, err)
+	assert.Equal(t, -0.062, c)
+
+	_, err = parseW1Slave([]byte("00 00 00 00 00 00 00 00 00 : crc=00 YES\n"))
+	assert.Error(t, err)
+	_, err = parseW1Slave([]byte("72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57 t=\n"))
+	assert.Error(t, err)
+}
@@ -2,6 +2,7 @@ package sensor
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
@@ -10,6 +11,7 @@ import (
 	"periph.io/x/periph/conn/gpio/gpioreg"
 	"periph.io/x/periph/conn/i2c"
 	"periph.io/x/periph/conn/i2c/i2creg"
+	"sync"
 	"time"
 )
 
@@ -23,7 +25,10 @@ type HIH6020 struct {
 	humCalibration float64
 	temp           float64
 	hum            float64
-	lastUpdate     time.Time
+	lastUpdate     time.Time // when the sensor was last read successfully
+	lastAttempt    time.Time
+	err            error // from the last attempt
+	mutex          sync.Mutex
 }
 
 func NewHIH6020(addr uint16, tempOffset, tempDivider, humCalibration float64) *HIH6020 {
@@ -59,40 +64,45 @@ func NewHIH6020(addr uint16, tempOffset, tempDivider, humCalibration float64) *H
 }
 
 func (s *HIH6020) Temperature() float64 {
-	// keep things like the /status API from pounding on the sensor
-	if time.Now().Sub(s.lastUpdate).Seconds() < 20 {
-		return s.temp
-	}
-
-	s.update()
-	return s.temp
+	r, _ := s.Read()
+	return r.Temperature
 }
 
 func (s *HIH6020) Humidity() float64 {
+	r, _ := s.Read()
+	return r.Humidity
+}
+
+// Read returns the last good reading, along with the error from the latest attempt to read the sensor
+func (s *HIH6020) Read() (Reading, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	// keep things like the /status API from pounding on the sensor
-	if time.Now().Sub(s.lastUpdate).Seconds() < 20 {
-		return s.hum
+	if time.Now().Sub(s.lastAttempt).Seconds() >= 20 {
+		s.lastAttempt = time.Now()
+		s.err = s.update()
+		if s.err != nil {
+			logrus.WithError(s.err).WithField("addr", s.addr).Error("failed to read sensor")
+		}
 	}
 
-	s.update()
-	return s.hum
+	return Reading{Temperature: s.temp, Humidity: s.hum, Time: s.lastUpdate}, s.err
 }
 
-func (s *HIH6020) update() {
+func (s *HIH6020) update() error {
 	logrus.Debug("starting conversion")
 
 	// I think this can be literally any command
 	convertCmd := s.addr << 1
 	if err := s.conn.Tx([]byte{byte(convertCmd)}, nil); err != nil {
-		logrus.WithError(err).Error("Unable to get data")
-		return
+		return fmt.Errorf("unable to start conversion: %w", err)
 	}
 	time.Sleep(s.conversionTime)
 
 	resp := make([]byte, 4)
 	if err := s.conn.Tx([]byte{}, resp); err != nil {
-		logrus.WithError(err).Error("Unable to get data")
-		return
+		return fmt.Errorf("unable to get data: %w", err)
 	}
 
 	logrus.WithField("data", hex.EncodeToString(resp)).Debug("sensor data")
@@ -104,24 +114,25 @@ func (s *HIH6020) update() {
 		logrus.WithField("wait", s.conversionTime).Warn("returning stale sensor data")
 		s.conversionTime += 5 * time.Millisecond
 	case 2:
-		logrus.Panic("device is unexpectedly in command mode!")
+		return errors.New("device is unexpectedly in command mode")
 	case 3:
-		logrus.Panic("unexpected device status")
+		return errors.New("unexpected device status")
 	}
 
 	s.temp = FarenheitFromCelcius(s.celciusFromRaw(resp[2:]))/s.tempDivider + s.tempOffset
 	s.hum = s.humidityFromRaw(resp[0:2]) + s.humCalibration
 
 	s.lastUpdate = time.Now()
+	return nil
 }
 
-func (s HIH6020) celciusFromRaw(data []byte) float64 {
+func (s *HIH6020) celciusFromRaw(data []byte) float64 {
 	num := ((uint64(data[0]) * 256) + (uint64(data[1]) & 0xFC)) / 4
 	const denom float64 = 16382 // math.Exp2(14) - 2
 	return float64(num)/denom*165.0 - 40.0
 }
 
-func (s HIH6020) humidityFromRaw(data []byte) float64 {
+func (s *HIH6020) humidityFromRaw(data []byte) float64 {
 	num := (uint64(data[0]&0x3F) * 256) | uint64(data[1])
 	const denom float64 = 16382 // math.Exp2(14) - 2
 	return float64(num) / denom * 100.0
@@ -0,0 +1,113 @@
+package sensor
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"periph.io/x/periph/conn"
+	"sync"
+	"time"
+)
+
+// SHT31 is a Sensirion SHT3x temperature and humidity sensor on the I²C bus, usually at 0x44 or 0x45
+type SHT31 struct {
+	conn conn.Conn
+	addr uint16
+
+	conversionTime time.Duration
+	tempOffset     float64
+	tempDivider    float64
+	humCalibration float64
+	temp           float64
+	hum            float64
+	lastUpdate     time.Time // when the sensor was last read successfully
+	lastAttempt    time.Time
+	err            error // from the last attempt
+	mutex          sync.Mutex
+}
+
+func NewSHT31(addr uint16, tempOffset, tempDivider, humCalibration float64) *SHT31 {
+	return newSHT31(openI2C(addr), addr, tempOffset, tempDivider, humCalibration)
+}
+
+func newSHT31(c conn.Conn, addr uint16, tempOffset, tempDivider, humCalibration float64) *SHT31 {
+	return &SHT31{
+		conn:           c,
+		addr:           addr,
+		conversionTime: 16 * time.Millisecond, // 15.5ms max at high repeatability
+		tempOffset:     tempOffset,
+		tempDivider:    tempDivider,
+		humCalibration: humCalibration,
+	}
+}
+
+func (s *SHT31) Temperature() float64 {
+	r, _ := s.Read()
+	return r.Temperature
+}
+
+func (s *SHT31) Humidity() float64 {
+	r, _ := s.Read()
+	return r.Humidity
+}
+
+// Read returns the last good reading, along with the error from the latest attempt to read the sensor
+func (s *SHT31) Read() (Reading, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// keep things like the /status API from pounding on the sensor
+	if time.Now().Sub(s.lastAttempt).Seconds() >= 20 {
+		s.lastAttempt = time.Now()
+		s.err = s.update()
+		if s.err != nil {
+			logrus.WithError(s.err).WithField("addr", s.addr).Error("failed to read sensor")
+		}
+	}
+
+	return Reading{Temperature: s.temp, Humidity: s.hum, Time: s.lastUpdate}, s.err
+}
+
+func (s *SHT31) update() error {
+	// single shot, high repeatability, without clock stretching
+	if err := s.conn.Tx([]byte{0x24, 0x00}, nil); err != nil {
+		return fmt.Errorf("unable to start conversion: %w", err)
+	}
+	time.Sleep(s.conversionTime)
+
+	resp := make([]byte, 6)
+	if err := s.conn.Tx(nil, resp); err != nil {
+		return fmt.Errorf("unable to get data: %w", err)
+	}
+	logrus.WithField("data", hex.EncodeToString(resp)).Debug("sensor data")
+
+	if sht31CRC(resp[0:2]) != resp[2] || sht31CRC(resp[3:5]) != resp[5] {
+		return errors.New("CRC check failed")
+	}
+
+	rawT := uint16(resp[0])<<8 | uint16(resp[1])
+	rawH := uint16(resp[3])<<8 | uint16(resp[4])
+	celcius := -45 + 175*float64(rawT)/65535
+	s.temp = FarenheitFromCelcius(celcius)/s.tempDivider + s.tempOffset
+	s.hum = 100*float64(rawH)/65535 + s.humCalibration
+
+	s.lastUpdate = time.Now()
+	return nil
+}
+
+// sht31CRC is the CRC-8 sent after each word, with polynomial 0x31 and initialized to 0xFF
+func sht31CRC(data []byte) byte {
+	crc := byte(0xFF)
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x31
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
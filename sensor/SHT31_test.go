@@ -0,0 +1,53 @@
+package sensor
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"periph.io/x/periph/conn/conntest"
+	"testing"
+)
+
+func TestSHT31_Read(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		data []byte
+		temp float64
+		hum  float64
+		err  string
+	}{
+		{"good reading", []byte{0x66, 0x66, 0x93, 0x80, 0x00, 0xA2}, 77, 50.0008, ""},
+		{"bad temperature CRC", []byte{0x66, 0x66, 0x00, 0x80, 0x00, 0xA2}, 0, 0, "CRC check failed"},
+		{"bad humidity CRC", []byte{0x66, 0x66, 0x93, 0x80, 0x00, 0x00}, 0, 0, "CRC check failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus := &conntest.Playback{Ops: []conntest.IO{
+				{W: []byte{0x24, 0x00}},
+				{R: tt.data},
+			}, DontPanic: true}
+			s := newSHT31(bus, 0x44, 0, 1, 0)
+			s.conversionTime = 0
+
+			r, err := s.Read()
+			require.NoError(t, bus.Close())
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				assert.True(t, r.Time.IsZero())
+				return
+			}
+			require.NoError(t, err)
+			assert.InDelta(t, tt.temp, r.Temperature, 0.0001)
+			assert.InDelta(t, tt.hum, r.Humidity, 0.0001)
+		})
+	}
+}
+
+func TestSHT31CRC(t *testing.T) {
+	t.Parallel()
+
+	// the example from the datasheet
+	assert.Equal(t, byte(0x92), sht31CRC([]byte{0xBE, 0xEF}))
+}
@@ -0,0 +1,61 @@
+package sensor
+
+import (
+	"sync"
+	"thermostat/clock"
+)
+
+// Calibrated corrects another sensor's readings. The calibration can be changed while the sensor is in use.
+type Calibrated struct {
+	source    Source
+	slope     float64
+	intercept float64
+	humOffset float64
+	clock     clock.Clock
+	mutex     sync.Mutex
+}
+
+// NewCalibrated returns source with its temperature corrected to raw*slope + intercept, and humOffset added to its
+// humidity. Sources that can't tell when they were read are read as of clk's now.
+func NewCalibrated(source Source, slope, intercept, humOffset float64, clk clock.Clock) *Calibrated {
+	return &Calibrated{source: source, slope: slope, intercept: intercept, humOffset: humOffset, clock: clk}
+}
+
+// Set changes the calibration
+func (c *Calibrated) Set(slope, intercept, humOffset float64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.slope, c.intercept, c.humOffset = slope, intercept, humOffset
+}
+
+func (c *Calibrated) Temperature() float64 {
+	r, _ := c.Read()
+	return r.Temperature
+}
+
+func (c *Calibrated) Humidity() float64 {
+	r, _ := c.Read()
+	return r.Humidity
+}
+
+func (c *Calibrated) HasHumidity() bool {
+	return HasHumidity(c.source)
+}
+
+// Uncalibrated reads the sensor without correcting it
+func (c *Calibrated) Uncalibrated() (Reading, error) {
+	return Read(c.source, c.clock)
+}
+
+func (c *Calibrated) Read() (Reading, error) {
+	r, err := Read(c.source, c.clock)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	r.Temperature = r.Temperature*c.slope + c.intercept
+	if HasHumidity(c.source) {
+		r.Humidity += c.humOffset
+	}
+	return r, err
+}
@@ -0,0 +1,28 @@
+package sensor
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"thermostat/clock"
+)
+
+func TestCalibrated(t *testing.T) {
+	t.Parallel()
+
+	s := &fixedReading{Reading{Temperature: 70, Humidity: 40}}
+	c := NewCalibrated(s, 1, 0, 0, clock.New())
+	assert.Equal(t, 70.0, c.Temperature())
+
+	c.Set(0.5, 36, -2)
+	assert.Equal(t, 71.0, c.Temperature())
+	assert.Equal(t, 38.0, c.Humidity())
+	r, err := c.Uncalibrated()
+	require.NoError(t, err)
+	assert.Equal(t, 70.0, r.Temperature)
+
+	// probes without humidity stay at 0
+	c = NewCalibrated(NewDS18B20(w1Fixtures, "28-000005e2fdc3", 0, 1), 1, 0, 5, clock.New())
+	assert.Equal(t, 0.0, c.Humidity())
+	assert.False(t, c.HasHumidity())
+}
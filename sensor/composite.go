@@ -0,0 +1,204 @@
+package sensor
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"thermostat/clock"
+	"time"
+)
+
+// Method is how a Composite combines its sensors
+type Method string
+
+const (
+	Mean     Method = "mean"     // the average of every sensor
+	Weighted Method = "weighted" // the average of every sensor, by weight
+	Min      Method = "min"      // the coldest sensor
+	Max      Method = "max"      // the warmest sensor
+	Rooms    Method = "rooms"    // the average of the rooms listed for the active schedule, by weight, or every room if none are
+)
+
+type room struct {
+	name   string
+	source Source
+	weight float64
+}
+
+// Composite combines several sensors, usually in different rooms, into one.
+// Humidity is always the average of the sensors used for the temperature, by weight, leaving out sensors that don't
+// measure it.
+type Composite struct {
+	method   Method
+	rooms    []room
+	active   map[string]bool // rooms listed for the active schedule
+	readings []Reading
+	temp     float64
+	hum      float64
+	time     time.Time
+	err      error
+	clock    clock.Clock
+	mutex    sync.Mutex
+}
+
+// NewComposite returns a sensor combining sensors by method. Sensors that can't tell when they were read are read as
+// of clk's now.
+func NewComposite(method Method, clk clock.Clock) (*Composite, error) {
+	switch method {
+	case Mean, Weighted, Min, Max, Rooms:
+	case "":
+		method = Mean
+	default:
+		return nil, errors.New("unknown sensor method " + string(method))
+	}
+	return &Composite{method: method, clock: clk}, nil
+}
+
+// Add adds the named room's sensor, weighted by weight for the weighted and rooms methods
+func (c *Composite) Add(name string, source Source, weight float64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.rooms = append(c.rooms, room{name: name, source: source, weight: weight})
+}
+
+// SetRooms sets the rooms listed for the active schedule. Room names are not case sensitive.
+func (c *Composite) SetRooms(rooms []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.active = make(map[string]bool, len(rooms))
+	for _, r := range rooms {
+		c.active[strings.ToLower(r)] = true
+	}
+}
+
+func (c *Composite) Temperature() float64 {
+	r, _ := c.Read()
+	return r.Temperature
+}
+
+func (c *Composite) Humidity() float64 {
+	r, _ := c.Read()
+	return r.Humidity
+}
+
+// HasHumidity reports if any of the sensors measure humidity
+func (c *Composite) HasHumidity() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, r := range c.rooms {
+		if HasHumidity(r.source) {
+			return true
+		}
+	}
+	return false
+}
+
+// Read reads every sensor and combines the readings, leaving out sensors that fail. It only fails if every sensor it
+// would use fails, and the reading is as old as the oldest reading used.
+func (c *Composite) Read() (Reading, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.update()
+	return Reading{Temperature: c.temp, Humidity: c.hum, Time: c.time}, c.err
+}
+
+// Readings returns the last reading from each sensor
+func (c *Composite) Readings() []Reading {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	readings := make([]Reading, len(c.readings))
+	copy(readings, c.readings)
+	return readings
+}
+
+// update reads every sensor and combines the readings. Callers must hold the mutex.
+func (c *Composite) update() {
+	c.readings = make([]Reading, len(c.rooms))
+	for i, r := range c.rooms {
+		reading, err := Read(r.source, c.clock)
+		reading.Name = r.name
+		reading.Weight = r.weight
+		if err != nil {
+			reading.Error = err.Error()
+		}
+		c.readings[i] = reading
+	}
+
+	switch c.method {
+	case Min, Max:
+		pick := -1
+		for i, r := range c.readings {
+			if r.Error != "" {
+				continue
+			}
+			if pick < 0 || (c.method == Min && r.Temperature < c.readings[pick].Temperature) || (c.method == Max && r.Temperature > c.readings[pick].Temperature) {
+				pick = i
+			}
+		}
+		if pick >= 0 {
+			c.readings[pick].Used = true
+		}
+	case Rooms:
+		for i, r := range c.readings {
+			c.readings[i].Used = r.Error == "" && c.active[strings.ToLower(r.Name)]
+		}
+		if !c.anyUsed() {
+			c.useAll()
+		}
+	default:
+		c.useAll()
+	}
+
+	var temp, hum, weight, humWeight float64
+	var oldest time.Time
+	for i, r := range c.readings {
+		if !r.Used {
+			continue
+		}
+		w := r.Weight
+		if c.method == Mean {
+			w = 1
+		}
+		temp += r.Temperature * w
+		weight += w
+		if HasHumidity(c.rooms[i].source) {
+			hum += r.Humidity * w
+			humWeight += w
+		}
+		if oldest.IsZero() || r.Time.Before(oldest) {
+			oldest = r.Time
+		}
+	}
+	if weight <= 0 {
+		c.err = errors.New("none of the sensors could be read")
+		return
+	}
+	c.temp = temp / weight
+	c.hum = 0
+	if humWeight > 0 {
+		c.hum = hum / humWeight
+	}
+	c.time = oldest
+	c.err = nil
+}
+
+func (c *Composite) anyUsed() bool {
+	for _, r := range c.readings {
+		if r.Used {
+			return true
+		}
+	}
+	return false
+}
+
+// useAll uses every sensor that could be read
+func (c *Composite) useAll() {
+	for i, r := range c.readings {
+		c.readings[i].Used = r.Error == ""
+	}
+}
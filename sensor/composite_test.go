@@ -0,0 +1,118 @@
+package sensor
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"thermostat/clock"
+)
+
+type fixed struct {
+	temp, hum float64
+}
+
+func (f fixed) Temperature() float64 {
+	return f.temp
+}
+
+func (f fixed) Humidity() float64 {
+	return f.hum
+}
+
+func TestComposite(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		method Method
+		rooms  []string
+		temp   float64
+		hum    float64
+		used   []bool
+	}{
+		{Mean, nil, 71, 45, []bool{true, true, true}},
+		{Weighted, nil, 70.5, 45, []bool{true, true, true}},
+		{Min, nil, 66, 50, []bool{false, true, false}},
+		{Max, nil, 78, 40, []bool{false, false, true}},
+		{Rooms, []string{"bedroom", "office"}, 72, 45, []bool{false, true, true}},
+		{Rooms, []string{"bedroom"}, 66, 50, []bool{false, true, false}},
+		{Rooms, []string{"attic"}, 70.5, 45, []bool{true, true, true}},
+		{Rooms, nil, 70.5, 45, []bool{true, true, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.method), func(t *testing.T) {
+			c, err := NewComposite(tt.method, clock.New())
+			require.NoError(t, err)
+			c.Add("hallway", fixed{69, 45}, 2)
+			c.Add("bedroom", fixed{66, 50}, 1)
+			c.Add("office", fixed{78, 40}, 1)
+			c.SetRooms(tt.rooms)
+
+			assert.Equal(t, tt.temp, c.Temperature())
+			assert.Equal(t, tt.hum, c.Humidity())
+			readings := c.Readings()
+			require.Len(t, readings, 3)
+			for i, r := range readings {
+				assert.Equal(t, tt.used[i], r.Used, r.Name)
+			}
+			assert.Equal(t, 66.0, readings[1].Temperature)
+		})
+	}
+
+	_, err := NewComposite("median", clock.New())
+	assert.Error(t, err)
+}
+
+type broken struct {
+	fixed
+}
+
+func (b broken) Read() (Reading, error) {
+	return Reading{Temperature: b.temp, Humidity: b.hum}, errors.New("no response")
+}
+
+func TestComposite_Read(t *testing.T) {
+	t.Parallel()
+
+	c, err := NewComposite(Min, clock.New())
+	require.NoError(t, err)
+	c.Add("bedroom", broken{fixed{40, 50}}, 1)
+	c.Add("hallway", fixed{70, 45}, 1)
+
+	// failing sensors are left out
+	r, err := c.Read()
+	require.NoError(t, err)
+	assert.Equal(t, 70.0, r.Temperature)
+	readings := c.Readings()
+	assert.Equal(t, "no response", readings[0].Error)
+	assert.False(t, readings[0].Used)
+
+	c, err = NewComposite(Mean, clock.New())
+	require.NoError(t, err)
+	c.Add("bedroom", broken{fixed{40, 50}}, 1)
+	_, err = c.Read()
+	assert.Error(t, err)
+}
+
+type thermometer struct {
+	fixed
+}
+
+func (thermometer) HasHumidity() bool {
+	return false
+}
+
+func TestComposite_humidity(t *testing.T) {
+	t.Parallel()
+
+	c, err := NewComposite(Mean, clock.New())
+	require.NoError(t, err)
+	c.Add("hallway", fixed{70, 45}, 1)
+	c.Add("attic", thermometer{fixed{80, 0}}, 1)
+
+	r, err := c.Read()
+	require.NoError(t, err)
+	assert.Equal(t, 75.0, r.Temperature)
+	assert.Equal(t, 45.0, r.Humidity)
+}
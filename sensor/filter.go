@@ -0,0 +1,209 @@
+package sensor
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"thermostat/clock"
+)
+
+// Filter smooths a sensor's readings, or rejects ones that can't be right
+type Filter interface {
+	// Apply returns the filtered reading for r, or false if r is rejected
+	Apply(r Reading) (Reading, bool)
+}
+
+// Filtered runs another sensor's readings through filters, in order. Rejected readings are dropped, leaving the last
+// filtered reading in place.
+type Filtered struct {
+	source   Source
+	filters  []Filter
+	raw      Reading // the latest reading from the source
+	filtered Reading
+	clock    clock.Clock
+	mutex    sync.Mutex
+}
+
+// NewFiltered returns source with its readings run through filters. Sources that can't tell when they were read are
+// read as of clk's now.
+func NewFiltered(source Source, clk clock.Clock, filters ...Filter) *Filtered {
+	return &Filtered{source: source, filters: filters, clock: clk}
+}
+
+func (f *Filtered) Temperature() float64 {
+	r, _ := f.Read()
+	return r.Temperature
+}
+
+func (f *Filtered) Humidity() float64 {
+	r, _ := f.Read()
+	return r.Humidity
+}
+
+func (f *Filtered) HasHumidity() bool {
+	return HasHumidity(f.source)
+}
+
+// Read returns the filtered reading, with the source's latest reading as Raw. Sources that fail return the last
+// filtered reading along with the error.
+func (f *Filtered) Read() (Reading, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	r, err := Read(f.source, f.clock)
+	if err != nil {
+		f.raw = r
+		return f.reading(), err
+	}
+	// sensors return the same reading until they're read again, and each reading should only be filtered once
+	if !r.Time.IsZero() && r.Time.Equal(f.raw.Time) {
+		return f.reading(), nil
+	}
+
+	f.raw = r
+	for _, filter := range f.filters {
+		var ok bool
+		if r, ok = filter.Apply(r); !ok {
+			return f.reading(), nil
+		}
+	}
+	f.filtered = r
+	return f.reading(), nil
+}
+
+// reading returns the filtered reading along with the raw one. Callers must hold the mutex.
+func (f *Filtered) reading() Reading {
+	r := f.filtered
+	raw := f.raw
+	r.Raw = &raw
+	return r
+}
+
+// MovingAverage averages the last size readings
+type MovingAverage struct {
+	size   int
+	window []Reading
+}
+
+func NewMovingAverage(size int) (*MovingAverage, error) {
+	if size < 1 {
+		return nil, errors.New("the moving average needs a size of at least 1")
+	}
+	return &MovingAverage{size: size}, nil
+}
+
+func (m *MovingAverage) Apply(r Reading) (Reading, bool) {
+	m.window = window(m.window, r, m.size)
+
+	var temp, hum float64
+	for _, w := range m.window {
+		temp += w.Temperature
+		hum += w.Humidity
+	}
+	r.Temperature = temp / float64(len(m.window))
+	r.Humidity = hum / float64(len(m.window))
+	return r, true
+}
+
+// Median takes the median of the last size readings, which ignores the odd bad reading without lagging as much as an
+// average
+type Median struct {
+	size   int
+	window []Reading
+}
+
+func NewMedian(size int) (*Median, error) {
+	if size < 1 {
+		return nil, errors.New("the median needs a size of at least 1")
+	}
+	return &Median{size: size}, nil
+}
+
+func (m *Median) Apply(r Reading) (Reading, bool) {
+	m.window = window(m.window, r, m.size)
+
+	temps := make([]float64, len(m.window))
+	hums := make([]float64, len(m.window))
+	for i, w := range m.window {
+		temps[i] = w.Temperature
+		hums[i] = w.Humidity
+	}
+	r.Temperature = median(temps)
+	r.Humidity = median(hums)
+	return r, true
+}
+
+// window appends r to readings, dropping the oldest readings past size
+func window(readings []Reading, r Reading, size int) []Reading {
+	readings = append(readings, r)
+	if len(readings) > size {
+		readings = readings[len(readings)-size:]
+	}
+	return readings
+}
+
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	mid := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[mid-1] + values[mid]) / 2
+	}
+	return values[mid]
+}
+
+// Exponential smooths readings, weighting each new reading by alpha and the running value by 1-alpha
+type Exponential struct {
+	alpha   float64
+	started bool
+	temp    float64
+	hum     float64
+}
+
+func NewExponential(alpha float64) (*Exponential, error) {
+	if alpha <= 0 || alpha > 1 {
+		return nil, errors.New("exponential smoothing needs an alpha above 0, and no more than 1")
+	}
+	return &Exponential{alpha: alpha}, nil
+}
+
+func (e *Exponential) Apply(r Reading) (Reading, bool) {
+	if !e.started {
+		e.temp, e.hum, e.started = r.Temperature, r.Humidity, true
+	} else {
+		e.temp = e.alpha*r.Temperature + (1-e.alpha)*e.temp
+		e.hum = e.alpha*r.Humidity + (1-e.alpha)*e.hum
+	}
+	r.Temperature = e.temp
+	r.Humidity = e.hum
+	return r, true
+}
+
+// RateLimit rejects readings where the temperature moved more than maxRate degrees per minute since the last reading
+// it accepted. A real change keeps showing up, so after maxRejects readings in a row are rejected, the next is accepted.
+type RateLimit struct {
+	maxRate    float64
+	maxRejects int
+	last       Reading
+	rejects    int
+}
+
+func NewRateLimit(maxRate float64, maxRejects int) (*RateLimit, error) {
+	if maxRate <= 0 {
+		return nil, errors.New("the rate limit needs a maximum rate above 0")
+	}
+	return &RateLimit{maxRate: maxRate, maxRejects: maxRejects}, nil
+}
+
+func (l *RateLimit) Apply(r Reading) (Reading, bool) {
+	if !l.last.Time.IsZero() && l.rejects < l.maxRejects {
+		minutes := math.Max(1, r.Time.Sub(l.last.Time).Minutes())
+		if math.Abs(r.Temperature-l.last.Temperature) > l.maxRate*minutes {
+			l.rejects++
+			return r, false
+		}
+	}
+	l.rejects = 0
+	l.last = r
+	return r, true
+}
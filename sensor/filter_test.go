@@ -0,0 +1,122 @@
+package sensor
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"thermostat/clock"
+	"time"
+)
+
+// script returns each of its readings in turn, a minute apart
+type script struct {
+	temps []float64
+	next  int
+	start time.Time
+}
+
+func (s *script) Temperature() float64 {
+	r, _ := s.Read()
+	return r.Temperature
+}
+
+func (s *script) Humidity() float64 {
+	return 50
+}
+
+func (s *script) Read() (Reading, error) {
+	r := Reading{Temperature: s.temps[s.next], Humidity: 50, Time: s.start.Add(time.Minute * time.Duration(s.next))}
+	if s.next < len(s.temps)-1 {
+		s.next++
+	}
+	return r, nil
+}
+
+func TestFilters(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	average, err := NewMovingAverage(3)
+	require.NoError(t, err)
+	median, err := NewMedian(3)
+	require.NoError(t, err)
+	exponential, err := NewExponential(0.5)
+	require.NoError(t, err)
+	rate, err := NewRateLimit(2, 2)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		filter   Filter
+		temps    []float64
+		expected []float64
+	}{
+		{"moving average", average, []float64{70, 72, 74, 76}, []float64{70, 71, 72, 74}},
+		{"median", median, []float64{70, 90, 71, 72, 40}, []float64{70, 80, 71, 72, 71}},
+		{"exponential", exponential, []float64{70, 72, 72, 68}, []float64{70, 71, 71.5, 69.75}},
+		// the spike is rejected, and the step is accepted once it's been rejected twice
+		{"rate limit", rate, []float64{70, 71, 90, 71, 80, 80, 80}, []float64{70, 71, 71, 71, 71, 71, 80}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewFiltered(&script{temps: tt.temps, start: start}, clock.New(), tt.filter)
+			for i, temp := range tt.temps {
+				r, err := f.Read()
+				require.NoError(t, err)
+				assert.Equal(t, tt.expected[i], r.Temperature, "reading %d", i)
+				require.NotNil(t, r.Raw)
+				assert.Equal(t, temp, r.Raw.Temperature, "reading %d", i)
+			}
+		})
+	}
+}
+
+func TestFiltered_sameReading(t *testing.T) {
+	t.Parallel()
+
+	average, err := NewMovingAverage(2)
+	require.NoError(t, err)
+	now := time.Now()
+	s := &fixedReading{Reading{Temperature: 70, Time: now}}
+	f := NewFiltered(s, clock.New(), average)
+	assert.Equal(t, 70.0, f.Temperature())
+
+	s.r = Reading{Temperature: 72, Time: now}
+	assert.Equal(t, 70.0, f.Temperature(), "a reading the sensor already returned isn't filtered again")
+
+	s.r = Reading{Temperature: 72, Time: now.Add(time.Second * 20)}
+	assert.Equal(t, 71.0, f.Temperature())
+	assert.Equal(t, 71.0, f.Temperature())
+}
+
+type fixedReading struct {
+	r Reading
+}
+
+func (f *fixedReading) Temperature() float64 {
+	return f.r.Temperature
+}
+
+func (f *fixedReading) Humidity() float64 {
+	return f.r.Humidity
+}
+
+func (f *fixedReading) Read() (Reading, error) {
+	return f.r, nil
+}
+
+func TestFilterValidation(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewMovingAverage(0)
+	assert.Error(t, err)
+	_, err = NewMedian(0)
+	assert.Error(t, err)
+	_, err = NewExponential(0)
+	assert.Error(t, err)
+	_, err = NewExponential(1.5)
+	assert.Error(t, err)
+	_, err = NewRateLimit(0, 3)
+	assert.Error(t, err)
+}
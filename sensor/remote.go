@@ -0,0 +1,80 @@
+package sensor
+
+import (
+	"fmt"
+	"sync"
+	"thermostat/clock"
+	"time"
+)
+
+// Remote is a sensor somewhere else on the network, like a microcontroller in a bedroom, that pushes its readings to the
+// thermostat. It fails once it has gone maxAge without a reading.
+type Remote struct {
+	name           string
+	maxAge         time.Duration
+	tempOffset     float64
+	humCalibration float64
+	clock          clock.Clock
+
+	temp     float64
+	hum      float64
+	hasHum   bool
+	received time.Time // when the last reading arrived
+	mutex    sync.Mutex
+}
+
+func NewRemote(name string, maxAge time.Duration, tempOffset, humCalibration float64, clk clock.Clock) *Remote {
+	return &Remote{
+		name:           name,
+		maxAge:         maxAge,
+		tempOffset:     tempOffset,
+		humCalibration: humCalibration,
+		clock:          clk,
+	}
+}
+
+// Push records a reading that just arrived. hum is nil for sensors that don't measure humidity.
+func (s *Remote) Push(temp float64, hum *float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.temp = temp + s.tempOffset
+	s.hasHum = hum != nil
+	s.hum = 0
+	if hum != nil {
+		s.hum = *hum + s.humCalibration
+	}
+	s.received = s.clock.Now()
+}
+
+func (s *Remote) Temperature() float64 {
+	r, _ := s.Read()
+	return r.Temperature
+}
+
+func (s *Remote) Humidity() float64 {
+	r, _ := s.Read()
+	return r.Humidity
+}
+
+// HasHumidity reports if the last reading included humidity
+func (s *Remote) HasHumidity() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.hasHum
+}
+
+// Read returns the last reading pushed, failing if there hasn't been one within maxAge
+func (s *Remote) Read() (Reading, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	r := Reading{Temperature: s.temp, Humidity: s.hum, Time: s.received}
+	if s.received.IsZero() {
+		return r, fmt.Errorf("remote sensor %s hasn't sent a reading", s.name)
+	}
+	if age := s.clock.Now().Sub(s.received); s.maxAge > 0 && age > s.maxAge {
+		return r, fmt.Errorf("remote sensor %s hasn't sent a reading in %s", s.name, age.Round(time.Second))
+	}
+	return r, nil
+}
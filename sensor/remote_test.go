@@ -0,0 +1,43 @@
+package sensor
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"thermostat/clock"
+	"time"
+)
+
+func TestRemote_Read(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(now)
+	s := NewRemote("bedroom", time.Minute*5, -1, 2, clk)
+
+	_, err := s.Read()
+	assert.EqualError(t, err, "remote sensor bedroom hasn't sent a reading")
+
+	hum := 40.0
+	s.Push(70, &hum)
+	clk.Advance(time.Minute)
+	r, err := s.Read()
+	require.NoError(t, err)
+	assert.Equal(t, 69.0, r.Temperature)
+	assert.Equal(t, 42.0, r.Humidity)
+	assert.Equal(t, now, r.Time)
+	assert.True(t, s.HasHumidity())
+
+	// the last reading is still returned once it's stale
+	clk.Advance(time.Minute * 5)
+	r, err = s.Read()
+	assert.EqualError(t, err, "remote sensor bedroom hasn't sent a reading in 6m0s")
+	assert.Equal(t, 69.0, r.Temperature)
+
+	s.Push(71, nil)
+	r, err = s.Read()
+	require.NoError(t, err)
+	assert.Equal(t, 70.0, r.Temperature)
+	assert.Equal(t, 0.0, r.Humidity)
+	assert.False(t, s.HasHumidity())
+}
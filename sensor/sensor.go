@@ -3,7 +3,12 @@ package sensor
 import (
 	"github.com/sirupsen/logrus"
 	"math"
+	"periph.io/x/periph/conn"
+	"periph.io/x/periph/conn/i2c"
+	"periph.io/x/periph/conn/i2c/i2creg"
 	"periph.io/x/periph/host"
+	"thermostat/clock"
+	"time"
 )
 
 func init() {
@@ -12,10 +17,86 @@ func init() {
 	}
 }
 
+// Source is a sensor
+type Source interface {
+	Temperature() float64
+	Humidity() float64
+}
+
+// Reader is implemented by sensors that can tell when they fail
+type Reader interface {
+	// Read returns the last good reading, along with the error from the latest attempt to read the sensor
+	Read() (Reading, error)
+}
+
+// Hygrometer is implemented by sensors that may not measure humidity
+type Hygrometer interface {
+	HasHumidity() bool
+}
+
+// hasHumidity reports if s measures humidity. Sensors that don't implement Hygrometer are assumed to.
+func HasHumidity(s Source) bool {
+	if h, ok := s.(Hygrometer); ok {
+		return h.HasHumidity()
+	}
+	return true
+}
+
+// Reading is a sensor's measurements, and when they were taken
+type Reading struct {
+	Name        string `json:",omitempty"`
+	Temperature float64
+	Humidity    float64
+	Pressure    float64 `json:",omitempty"` // in hPa, from sensors that measure it
+	Weight      float64 `json:",omitempty"`
+	Used        bool    `json:",omitempty"` // the reading counted towards a Composite's combined values
+	Error       string  `json:",omitempty"` // why the latest attempt to read the sensor failed
+	Time        time.Time
+	Raw         *Reading `json:",omitempty"` // the reading before it was filtered, from Filtered sensors
+}
+
+// Read reads s. Sensors that don't implement Reader never fail, and are read as of clk's now.
+func Read(s Source, clk clock.Clock) (Reading, error) {
+	if r, ok := s.(Reader); ok {
+		return r.Read()
+	}
+	return Reading{Temperature: s.Temperature(), Humidity: s.Humidity(), Time: clk.Now()}, nil
+}
+
+// openI2C returns a point-to-point connection to the device at addr on the default I²C bus
+func openI2C(addr uint16) conn.Conn {
+	i2cbus, err := i2creg.Open("")
+	if err != nil {
+		logrus.WithError(err).Panic("Could not open i2c bus")
+	}
+	return &i2c.Dev{Bus: i2cbus, Addr: addr}
+}
+
 func FarenheitFromCelcius(celcius float64) float64 {
 	return celcius*1.8 + 32
 }
 
+func CelciusFromFarenheit(farenheit float64) float64 {
+	return (farenheit - 32) / 1.8
+}
+
+// DewPoint calculates the dew point in degrees F from the given temperature in degrees F and relative humidity
+//
+// https://doi.org/10.1175/1520-0450(1996)035<0601:IMFAOS>2.0.CO;2
+// Uses the Magnus formula with the Alduchov and Eskridge coefficients, which is within 0.4C of the exact value
+// between -40C and 50C:
+// γ = ln(RH/100) + a*T/(b+T)
+// Td = b*γ/(a-γ)
+// where T is in degrees C, a = 17.625, and b = 243.04C. Humidity below 1% is treated as 1%, since the dew point
+// falls without bound as the humidity approaches 0.
+func DewPoint(temp, hum float64) float64 {
+	const a, b = 17.625, 243.04
+	hum = math.Max(hum, 1)
+	t := CelciusFromFarenheit(temp)
+	gamma := math.Log(hum/100) + a*t/(b+t)
+	return FarenheitFromCelcius(b * gamma / (a - gamma))
+}
+
 // heatIndex calculates the heat index from the given temperature and humidity
 //
 // https://www.wpc.ncep.noaa.gov/html/heatindex_equation.shtml
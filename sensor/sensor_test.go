@@ -3,6 +3,8 @@ package sensor
 import (
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"thermostat/clock"
+	"time"
 )
 
 func TestFarenheitFromCelcius(t *testing.T) {
@@ -55,3 +57,44 @@ func TestHeatIndex(t *testing.T) {
 		assert.InDelta(t, tt.expected, temp, 0.0001, "Test %d: %.2f℉, %.2f% hum", i, tt.temp, tt.hum)
 	}
 }
+
+func TestDewPoint(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		temp     float64
+		hum      float64
+		expected float64
+	}{
+		{77, 50, 56.94},
+		{68, 100, 68},
+		{70, 45, 47.69},
+		{40, 80, 34.33},
+		{70, 0, -35.16},
+	}
+
+	for i, tt := range tests {
+		dew := DewPoint(tt.temp, tt.hum)
+		assert.InDelta(t, tt.expected, dew, 0.01, "Test %d: %.2f℉, %.2f% hum", i, tt.temp, tt.hum)
+	}
+}
+
+// plain is a sensor that can't tell when it was read
+type plain float64
+
+func (p plain) Temperature() float64 {
+	return float64(p)
+}
+
+func (p plain) Humidity() float64 {
+	return 40
+}
+
+func TestRead(t *testing.T) {
+	t.Parallel()
+
+	clk := clock.NewFake(time.Date(2020, 1, 5, 0, 0, 0, 0, time.UTC))
+	r, err := Read(plain(70), clk)
+	assert.NoError(t, err)
+	assert.Equal(t, Reading{Temperature: 70, Humidity: 40, Time: clk.Now()}, r)
+}
@@ -0,0 +1,98 @@
+package sensor
+
+import (
+	"sync"
+	"thermostat/clock"
+	"time"
+)
+
+// Equipment is the heating and cooling equipment that drives a simulated house
+type Equipment interface {
+	AC() bool
+	AC2() bool
+	Heat() bool
+	Heat2() bool
+}
+
+// Simulation is a virtual sensor in a house with a simple thermal model.
+// The house loses heat to the outdoors in proportion to the temperature difference, and each running heating or
+// cooling stage changes the temperature at a fixed rate.
+type Simulation struct {
+	equipment Equipment
+	clock     clock.Clock
+
+	outdoor  float64 // ℉
+	loss     float64 // fraction of the indoor/outdoor difference lost per hour
+	heatRate float64 // ℉ per hour, per stage
+	coolRate float64 // ℉ per hour, per stage
+	temp     float64
+	hum      float64
+
+	lastUpdate time.Time
+	mutex      sync.Mutex
+}
+
+// NewSimulation returns a simulated sensor whose house changes temperature as clk's time passes
+func NewSimulation(equipment Equipment, temp, hum, outdoor, loss, heatRate, coolRate float64, clk clock.Clock) *Simulation {
+	return &Simulation{
+		equipment:  equipment,
+		clock:      clk,
+		outdoor:    outdoor,
+		loss:       loss,
+		heatRate:   heatRate,
+		coolRate:   coolRate,
+		temp:       temp,
+		hum:        hum,
+		lastUpdate: clk.Now(),
+	}
+}
+
+func (s *Simulation) Temperature() float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.update(s.clock.Now())
+	return s.temp
+}
+
+// Read returns the current temperature and humidity. The simulation never fails.
+func (s *Simulation) Read() (Reading, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.update(s.clock.Now())
+	return Reading{Temperature: s.temp, Humidity: s.hum, Time: s.lastUpdate}, nil
+}
+
+func (s *Simulation) Humidity() float64 {
+	return s.hum
+}
+
+// update advances the model to now, assuming the equipment has been in its current state since the last update
+func (s *Simulation) update(now time.Time) {
+	var rate float64
+	if s.equipment.Heat() {
+		rate += s.heatRate
+	}
+	if s.equipment.Heat2() {
+		rate += s.heatRate
+	}
+	if s.equipment.AC() {
+		rate -= s.coolRate
+	}
+	if s.equipment.AC2() {
+		rate -= s.coolRate
+	}
+
+	// step through time so heat loss tracks the changing indoor temperature
+	const step = time.Minute
+	for elapsed := now.Sub(s.lastUpdate); elapsed > 0; elapsed -= step {
+		hours := step.Hours()
+		if elapsed < step {
+			hours = elapsed.Hours()
+		}
+		s.temp += (rate + s.loss*(s.outdoor-s.temp)) * hours
+	}
+
+	s.lastUpdate = now
+}
@@ -0,0 +1,61 @@
+package sensor
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"thermostat/clock"
+	"time"
+)
+
+type equipment struct {
+	ac, ac2, heat, heat2 bool
+}
+
+func (e equipment) AC() bool {
+	return e.ac
+}
+
+func (e equipment) AC2() bool {
+	return e.ac2
+}
+
+func (e equipment) Heat() bool {
+	return e.heat
+}
+
+func (e equipment) Heat2() bool {
+	return e.heat2
+}
+
+func TestSimulation_update(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		equipment equipment
+		outdoor   float64
+		loss      float64
+		expected  float64
+	}{
+		{"idle at outdoor temp", equipment{}, 70, 0.1, 70},
+		{"heat", equipment{heat: true}, 70, 0, 74},
+		{"two stage heat", equipment{heat: true, heat2: true}, 70, 0, 78},
+		{"cool", equipment{ac: true}, 70, 0, 67},
+		{"two stage cool", equipment{ac: true, ac2: true}, 70, 0, 64},
+		{"heat loss", equipment{}, 50, 0.1, 68.1},                // 50 + 20e^-0.1
+		{"heat gain", equipment{}, 90, 0.1, 71.9},                // 90 - 20e^-0.1
+		{"heat with loss", equipment{heat: true}, 50, 0.1, 71.9}, // 90 - 20e^-0.1
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewFake(time.Now())
+			s := NewSimulation(tt.equipment, 70, 45, tt.outdoor, tt.loss, 4, 3, clk)
+			clk.Advance(time.Hour)
+			r, err := s.Read()
+			assert.NoError(t, err)
+			assert.InDelta(t, tt.expected, r.Temperature, 0.01)
+			assert.Equal(t, clk.Now(), r.Time)
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package system
+
+import (
+	"github.com/sirupsen/logrus"
+	"math"
+	"time"
+)
+
+const (
+	settleBand      = 0.25             // degrees the temperature must turn back before an overshoot is measured
+	settleTimeout   = 30 * time.Minute // longest an overshoot is measured for
+	maxAnticipation = 5                // most degrees the strategy will anticipate
+)
+
+// anticipation is a hysteresis strategy that learns how far the temperature keeps moving after heat or AC shuts off,
+// and shuts off early by that much, even before the temperature is back inside the range. Heat or AC still only starts
+// past the edge of the range, and not while the temperature is still coasting from the last time it shut off.
+type anticipation struct {
+	rate      float64            // how quickly new overshoot measurements replace old ones, from 0 to 1
+	overshoot map[Demand]float64 // learned overshoot, in degrees
+	learned   map[Demand]bool
+
+	lastRunning Demand
+	measuring   Demand // what shut off, while its overshoot is measured
+	offTemp     float64
+	offTime     time.Time
+	peak        float64 // furthest the temperature moved past offTemp
+}
+
+func newAnticipation(key string) *anticipation {
+	return &anticipation{
+		rate:      configFloat(key+".rate", 0.3),
+		overshoot: make(map[Demand]float64),
+		learned:   make(map[Demand]bool),
+	}
+}
+
+func (a *anticipation) Demand(now time.Time, temp float64, r Range, running Demand) Demand {
+	a.measure(now, temp, running)
+
+	heatOff := r.Min + r.Correction - a.overshoot[Heating]
+	coolOff := r.Max - r.Correction + a.overshoot[Cooling]
+	d := hysteresisDemand(temp, r, running, heatOff, coolOff)
+	if running == Idle && d == a.measuring {
+		return Idle // the temperature is still coasting back into the range
+	}
+	return d
+}
+
+// measure tracks how far the temperature moves after heat or AC shuts off, and learns from it once the temperature turns
+// back, the equipment starts again, or the measurement times out
+func (a *anticipation) measure(now time.Time, temp float64, running Demand) {
+	if a.lastRunning != Idle && running != a.lastRunning {
+		a.measuring = a.lastRunning
+		a.offTemp = temp
+		a.offTime = now
+		a.peak = temp
+	}
+	a.lastRunning = running
+
+	if a.measuring == Idle {
+		return
+	}
+
+	moved := temp - a.peak // how much further the temperature went in the direction of the equipment
+	if a.measuring == Cooling {
+		moved = a.peak - temp
+	}
+	if moved > 0 {
+		a.peak = temp
+	}
+	if running == Idle && moved > -settleBand && now.Sub(a.offTime) < settleTimeout {
+		return
+	}
+
+	overshoot := math.Abs(a.peak - a.offTemp)
+	d := a.measuring
+	a.measuring = Idle
+	if a.learned[d] {
+		overshoot = a.overshoot[d] + a.rate*(overshoot-a.overshoot[d])
+	}
+	a.overshoot[d] = math.Min(overshoot, maxAnticipation)
+	a.learned[d] = true
+
+	logrus.WithFields(logrus.Fields{
+		"equipment": d.String(),
+		"overshoot": a.overshoot[d],
+	}).Info("learned overshoot")
+}
@@ -0,0 +1,95 @@
+package system
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestAnticipation(t *testing.T) {
+	t.Parallel()
+
+	r := Range{Min: 68, Max: 76, Correction: 1}
+	a := &anticipation{rate: 0.5, overshoot: make(map[Demand]float64), learned: make(map[Demand]bool)}
+	now := time.Now()
+	step := func(temp float64, running Demand) Demand {
+		now = now.Add(time.Minute)
+		return a.Demand(now, temp, r, running)
+	}
+
+	// without any measurements, it behaves like hysteresis
+	assert.Equal(t, Heating, step(67.5, Idle))
+	assert.Equal(t, Heating, step(68.5, Heating))
+	assert.Equal(t, Idle, step(69, Heating))
+
+	// the temperature keeps climbing 2 degrees after the heat shuts off
+	assert.Equal(t, Idle, step(69, Idle))
+	assert.Equal(t, Idle, step(70, Idle))
+	assert.Equal(t, Idle, step(71, Idle))
+	assert.Equal(t, Idle, step(70.5, Idle))
+	assert.Equal(t, float64(2), a.overshoot[Heating])
+
+	// so the heat shuts off a degree before it reaches the range
+	assert.Equal(t, Heating, step(67.5, Idle))
+	assert.Equal(t, Idle, step(68, Heating))
+
+	// a smaller overshoot is blended in
+	assert.Equal(t, Idle, step(68, Idle))
+	assert.Equal(t, Idle, step(69, Idle))
+	assert.Equal(t, Idle, step(68.5, Idle))
+	assert.Equal(t, 1.5, a.overshoot[Heating])
+	assert.Zero(t, a.overshoot[Cooling])
+}
+
+func TestAnticipation_Timeout(t *testing.T) {
+	t.Parallel()
+
+	r := Range{Min: 68, Max: 76, Correction: 1}
+	a := &anticipation{rate: 0.5, overshoot: make(map[Demand]float64), learned: make(map[Demand]bool)}
+	now := time.Now()
+
+	a.Demand(now, 75, r, Cooling)
+	a.Demand(now.Add(time.Minute), 75, r, Idle)
+	a.Demand(now.Add(10*time.Minute), 74.5, r, Idle)
+	assert.False(t, a.learned[Cooling])
+	a.Demand(now.Add(time.Hour), 74.5, r, Idle)
+	assert.True(t, a.learned[Cooling])
+	assert.Equal(t, 0.5, a.overshoot[Cooling])
+}
+
+func TestAnticipation_BeyondCorrection(t *testing.T) {
+	t.Parallel()
+
+	r := Range{Min: 68, Max: 76, Correction: 0.5}
+	a := &anticipation{
+		rate:      0.5,
+		overshoot: map[Demand]float64{Heating: 2, Cooling: 2},
+		learned:   map[Demand]bool{Heating: true, Cooling: true},
+	}
+	now := time.Now()
+	step := func(temp float64, running Demand) Demand {
+		now = now.Add(time.Minute)
+		return a.Demand(now, temp, r, running)
+	}
+
+	// the heat shuts off 1.5 degrees below the range, and the overshoot carries the temperature back into it
+	assert.Equal(t, Heating, step(66, Idle))
+	assert.Equal(t, Heating, step(66.4, Heating))
+	assert.Equal(t, Idle, step(66.6, Heating))
+	assert.Equal(t, Idle, step(67, Idle), "the heat doesn't restart while the temperature is still rising")
+	assert.Equal(t, Idle, step(68, Idle))
+	assert.Equal(t, Idle, step(68.5, Idle))
+	assert.Equal(t, Idle, step(68.2, Idle))
+	assert.InDelta(t, 1.75, a.overshoot[Heating], 0.001)
+
+	// the AC shuts off above the range the same way
+	assert.Equal(t, Cooling, step(77, Idle))
+	assert.Equal(t, Idle, step(77.4, Cooling))
+	assert.Equal(t, Idle, step(76.5, Idle), "the AC doesn't restart while the temperature is still falling")
+	assert.Equal(t, Idle, step(75.5, Idle))
+	assert.Equal(t, Idle, step(75.8, Idle))
+	assert.InDelta(t, 1.5, a.overshoot[Cooling], 0.001)
+
+	// once the temperature turns back, it starts again past the edge of the range
+	assert.Equal(t, Heating, step(67.5, Idle))
+}
@@ -0,0 +1,71 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"thermostat/clock"
+	"thermostat/db/calibration"
+	"thermostat/sensor"
+)
+
+var (
+	calibrated      = make(map[string]*sensor.Calibrated)
+	calibratedMutex sync.Mutex
+)
+
+// withCalibration wraps s in the named sensor's calibration from the database, and keeps track of it so Calibrate can
+// change the calibration while the sensor is in use. A sensor that hasn't been calibrated starts with seed, its
+// calibration from the config. Zones' sensors are named for the zone, rooms' sensors are named <zone>/<room>, and the
+// outdoor sensor is named outdoor. Sensors that can't tell when they were read are read as of clk's now.
+func withCalibration(ctx context.Context, s Sensor, seed calibration.Calibration, clk clock.Clock) (Sensor, error) {
+	name := seed.Sensor
+	c, err := calibration.Seed(ctx, seed)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load calibration for %s: %w", name, err)
+	}
+	cal := sensor.NewCalibrated(s, c.Slope, c.Intercept, c.HumOffset, clk)
+
+	calibratedMutex.Lock()
+	calibrated[name] = cal
+	calibratedMutex.Unlock()
+	return cal, nil
+}
+
+// CalibratedSensor returns the named sensor, before any filters
+func CalibratedSensor(name string) (*sensor.Calibrated, error) {
+	calibratedMutex.Lock()
+	defer calibratedMutex.Unlock()
+
+	if s, ok := calibrated[name]; ok {
+		return s, nil
+	}
+	return nil, fmt.Errorf("no sensor named %s is running", name)
+}
+
+// CalibratedSensors returns the names of every sensor that can be calibrated, sorted
+func CalibratedSensors() []string {
+	calibratedMutex.Lock()
+	defer calibratedMutex.Unlock()
+
+	names := make([]string, 0, len(calibrated))
+	for name := range calibrated {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Calibrate saves c, and applies it to the running sensor
+func Calibrate(ctx context.Context, c calibration.Calibration) error {
+	s, err := CalibratedSensor(c.Sensor)
+	if err != nil {
+		return err
+	}
+	if err := c.Save(ctx); err != nil {
+		return err
+	}
+	s.Set(c.Slope, c.Intercept, c.HumOffset)
+	return nil
+}
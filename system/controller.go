@@ -3,213 +3,466 @@ package system
 import (
 	"fmt"
 	"github.com/sirupsen/logrus"
-	"log"
-	"periph.io/x/periph/conn/gpio"
-	"periph.io/x/periph/conn/gpio/gpioreg"
-	"runtime/debug"
+	"github.com/spf13/viper"
 	"sync"
+	"thermostat/clock"
+	"thermostat/relay"
 	"time"
 )
 
-const switchInterval = 120
+// timing holds the protection timers for a single stage
+type timing struct {
+	minOn      time.Duration // how long the stage must run once started
+	minOff     time.Duration // how long the stage must rest once stopped
+	minCycle   time.Duration // how long from one start to the next
+	fanLead    time.Duration // how long to wait after starting the stage before starting the fan
+	fanOverrun time.Duration // how long to run the fan after the stage stops
+}
 
-type hvac struct {
-	fan  bool
-	ac   bool
-	heat bool
+func loadTiming(key string) timing {
+	return timing{
+		minOn:      time.Second * time.Duration(viper.GetInt(key+".minOn")),
+		minOff:     time.Second * time.Duration(viper.GetInt(key+".minOff")),
+		minCycle:   time.Second * time.Duration(viper.GetInt(key+".minCycle")),
+		fanLead:    time.Second * time.Duration(viper.GetInt(key+".fanLead")),
+		fanOverrun: time.Second * time.Duration(viper.GetInt(key+".fanOverrun")),
+	}
+}
 
-	last  time.Time
-	mutex sync.Mutex
+// Deferred is returned when a protection timer keeps an output from switching
+type Deferred struct {
+	Output string    // the output that was not switched
+	On     bool      // the requested state
+	Timer  string    // the timer that deferred the request: minOn, minOff, or minCycle
+	Until  time.Time // when the output may switch
+}
+
+func (d *Deferred) Error() string {
+	return fmt.Sprintf("switching %s to %t deferred by %s until %s", d.Output, d.On, d.Timer, d.Until.Format(time.Kitchen))
+}
 
-	fanPin  gpio.PinIO
-	heatPin gpio.PinIO
-	acPin   gpio.PinIO
+// stage is a single heating or cooling relay
+type stage struct {
+	name    string
+	on      bool
+	last    time.Time // when the stage last switched
+	started time.Time // when the stage last turned on
+	timing  timing
+	relay   relay.Relay
 }
 
-// NewHVAC returns a new HVAC controller using the given fan, ac, and heat GPIO pins
-func NewHVAC(fan, ac, heat int) *hvac {
-	cont := &hvac{}
+func (s stage) installed() bool {
+	return s.relay != nil
+}
 
-	pin := fmt.Sprintf("GPIO%d", fan)
-	cont.fanPin = gpioreg.ByName(pin)
-	if cont.fanPin == nil {
-		logrus.WithField("pin", pin).Panic("Failed to find pin")
+// check returns a *Deferred error if the stage's timers don't allow it to switch to on at now
+func (s stage) check(now time.Time, on bool) error {
+	var timer string
+	var until time.Time
+	if on {
+		if until = s.last.Add(s.timing.minOff); now.Before(until) {
+			timer = "minOff"
+		} else if until = s.started.Add(s.timing.minCycle); now.Before(until) {
+			timer = "minCycle"
+		}
+	} else if until = s.last.Add(s.timing.minOn); now.Before(until) {
+		timer = "minOn"
+	}
+	if timer == "" {
+		return nil
 	}
 
-	pin = fmt.Sprintf("GPIO%d", ac)
-	cont.acPin = gpioreg.ByName(pin)
-	if cont.acPin == nil {
-		logrus.WithField("pin", pin).Panic("Failed to find pin")
+	logrus.WithFields(logrus.Fields{
+		"output": s.name,
+		"on":     on,
+		"timer":  timer,
+		"until":  until.String(),
+	}).Info("deferring stage switch")
+	return &Deferred{Output: s.name, On: on, Timer: timer, Until: until}
+}
+
+func (s *stage) set(now time.Time, on bool) error {
+	if err := s.relay.Set(on); err != nil {
+		return err
 	}
 
-	pin = fmt.Sprintf("GPIO%d", heat)
-	cont.heatPin = gpioreg.ByName(pin)
-	if cont.heatPin == nil {
-		logrus.WithField("pin", pin).Panic("Failed to find pin")
+	s.on = on
+	s.last = now
+	if on {
+		s.started = now
 	}
+	return nil
+}
 
-	cont.Reset()
+// trySet switches the stage if it is installed and its timers allow it, returning true if the stage is now in the requested state
+func (s *stage) trySet(now time.Time, on bool) (bool, error) {
+	if on == s.on {
+		return true, nil
+	}
+	if !s.installed() {
+		return !on, nil
+	}
+	if err := s.check(now, on); err != nil {
+		return false, err
+	}
 
-	return cont
+	logrus.WithFields(logrus.Fields{
+		"on":    on,
+		"relay": s.relay.String(),
+	}).Info("toggling stage")
+
+	if err := s.set(now, on); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
-func (c *hvac) Reset() {
-	if err := c.fanPin.Out(gpio.Low); err != nil {
-		logrus.WithField("pin", c.fanPin.String()).Fatal(err)
+// reset turns the relay off, regardless of its timers
+func (s *stage) reset() error {
+	if !s.installed() {
+		return nil
 	}
-	if err := c.acPin.Out(gpio.Low); err != nil {
-		logrus.WithField("pin", c.acPin.String()).Fatal(err)
+	if err := s.relay.Set(false); err != nil {
+		return err
 	}
-	if err := c.heatPin.Out(gpio.Low); err != nil {
-		logrus.WithField("pin", c.heatPin.String()).Fatal(err)
+	s.on = false
+	return nil
+}
+
+// blower is the fan. It runs when it is called for on its own, or to follow heat or AC.
+type blower struct {
+	stage
+	clock  clock.Clock
+	call   bool // the fan is called for on its own
+	follow bool // the fan is following heat or AC
+	mutex  sync.Mutex
+}
+
+// apply switches the fan on if it is called for or following heat or AC. Callers must hold the mutex.
+func (b *blower) apply() (bool, error) {
+	on := b.call || b.follow
+	if on == b.on {
+		return on, nil
 	}
+
+	logrus.WithFields(logrus.Fields{
+		"on":     on,
+		"call":   b.call,
+		"follow": b.follow,
+	}).Info("toggling fan")
+
+	err := b.set(b.clock.Now(), on)
+	return b.on, err
 }
 
-func (c hvac) Fan() bool {
-	return c.fan
+// running reports if the fan is on
+func (b *blower) running() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.on
 }
 
-func (c hvac) AC() bool {
-	return c.ac
+// setCall calls for the fan to run on its own. The fan keeps running while it follows heat or AC, whatever the call.
+func (b *blower) setCall(on bool) (bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.call = on
+	return b.apply()
 }
 
-func (c hvac) Heat() bool {
-	return c.heat
+// setFollow starts or stops following heat or AC
+func (b *blower) setFollow(on bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.follow = on
+	if _, err := b.apply(); err != nil {
+		logrus.WithError(err).Error("failed to switch fan")
+	}
 }
 
-func (c *hvac) SetFan(on bool) bool {
-	if on == c.fan {
-		return on
+// reset turns the fan off and forgets any call for it
+func (b *blower) reset() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.call = false
+	b.follow = false
+	return b.stage.reset()
+}
+
+type hvac struct {
+	fan   blower
+	ac    stage
+	ac2   stage
+	heat  stage
+	heat2 stage
+
+	accessories
+	queue
+	clock clock.Clock
+	mutex sync.Mutex
+}
+
+// NewHVAC returns a new HVAC controller using the given fan, ac, and heat relays, with protection timers run by clk.
+// Second stage ac and heat relays are optional, and may be nil if they are not installed.
+func NewHVAC(fan, ac, heat, ac2, heat2 relay.Relay, acc Accessories, clk clock.Clock) (*hvac, error) {
+	cont := &hvac{
+		fan:   blower{stage: stage{name: "fan", relay: fan}, clock: clk},
+		ac:    stage{name: "ac", relay: ac, timing: loadTiming("cool")},
+		ac2:   stage{name: "ac2", relay: ac2, timing: loadTiming("cool.stage2")},
+		heat:  stage{name: "heat", relay: heat, timing: loadTiming("heat")},
+		heat2: stage{name: "heat2", relay: heat2, timing: loadTiming("heat.stage2")},
+
+		accessories: newAccessories(acc, clk),
+		queue:       queue{clock: clk},
+		clock:       clk,
 	}
 
-	if !on && (c.ac || c.heat) {
-		on = true
+	if err := cont.Reset(); err != nil {
+		return nil, err
 	}
 
-	logrus.WithField("on", on).Info("toggling fan")
+	return cont, nil
+}
 
-	level := gpio.Low
-	if on {
-		level = gpio.High
+func (c *hvac) Reset() error {
+	c.clear()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	firstErr := c.fan.reset()
+	if firstErr != nil {
+		logrus.WithField("relay", c.fan.relay.String()).WithError(firstErr).Error("failed to reset relay")
 	}
-	if err := c.fanPin.Out(level); err != nil {
-		log.Fatal(err)
+	for _, s := range []*stage{&c.ac, &c.ac2, &c.heat, &c.heat2} {
+		if err := s.reset(); err != nil {
+			logrus.WithField("relay", s.relay.String()).WithError(err).Error("failed to reset relay")
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
 	}
+	if err := c.accessories.reset(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
 
-	c.fan = on
-	return c.Fan()
+func (c *hvac) Fan() bool {
+	return c.fan.running()
 }
 
-func (c *hvac) SetAC(on bool) bool {
-	if on == c.ac {
-		return on
+func (c *hvac) AC() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.ac.on
+}
+
+func (c *hvac) AC2() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.ac2.on
+}
+
+func (c *hvac) Heat() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.heat.on
+}
+
+func (c *hvac) Heat2() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.heat2.on
+}
+
+func (c *hvac) Outputs() map[string]bool {
+	fan := c.fan.running() // the fan has its own lock, so read it without holding the controller's
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.outputs(map[string]bool{
+		"fan":   fan,
+		"ac":    c.ac.on,
+		"ac2":   c.ac2.on,
+		"heat":  c.heat.on,
+		"heat2": c.heat2.on,
+	})
+}
+
+// SetFan calls for the fan to run on its own. The fan always runs while heat or AC runs.
+func (c *hvac) SetFan(on bool) (bool, error) {
+	return c.fan.setCall(on)
+}
+
+// followFan starts or stops the fan after the fan lead or overrun time of t
+func (c *hvac) followFan(t timing, on bool) {
+	delay := t.fanOverrun
+	if on {
+		delay = t.fanLead
 	}
-	if c.heat {
-		logrus.Error("Illegal attempt to engage AC while heat is on")
-		return c.AC()
+
+	// the controller's lock is taken before the fan's, as everywhere else
+	c.clock.AfterFunc(delay, func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		if (c.ac.on || c.heat.on) != on { // heat or AC switched again before the fan caught up
+			return
+		}
+		c.fan.setFollow(on)
+	})
+}
+
+// SetAC switches the AC, queueing the switch if a protection timer defers it
+func (c *hvac) SetAC(on bool) (bool, error) {
+	if !on {
+		c.cancel("ac2")
 	}
+	return c.run("ac", on, c.setAC)
+}
 
+func (c *hvac) setAC(on bool) (bool, error) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	now := time.Now()
-	if !c.canSwitch(now, on) {
-		return c.ac
+	if on == c.ac.on {
+		return on, nil
+	}
+	if c.heat.on {
+		logrus.Error("Illegal attempt to engage AC while heat is on")
+		return c.ac.on, nil
 	}
 
-	if on {
-		time.AfterFunc(time.Second*15, func() {
-			c.SetFan(true)
-		})
-	} else {
-		// this needs to be long enough to dehumidify the air duct
-		time.AfterFunc(time.Second*30, func() {
-			c.SetFan(false)
-		})
+	now := c.clock.Now()
+	if err := c.ac.check(now, on); err != nil {
+		return c.ac.on, err
+	}
+	// the second stage can't run on its own
+	if !on {
+		if off, err := c.ac2.trySet(now, false); !off {
+			return c.ac.on, err
+		}
 	}
 
 	logrus.WithField("on", on).Info("toggling AC")
 
-	level := gpio.Low
-	if on {
-		level = gpio.High
-	}
-	if err := c.acPin.Out(level); err != nil {
-		log.Fatal(err)
+	if err := c.ac.set(now, on); err != nil {
+		return c.ac.on, err
 	}
+	c.followFan(c.ac.timing, on)
+
+	return c.ac.on, nil
+}
 
-	c.ac = on
-	c.last = now
-	return c.AC()
+// SetAC2 switches the second stage AC, queueing the switch if a protection timer defers it
+func (c *hvac) SetAC2(on bool) (bool, error) {
+	return c.run("ac2", on, c.setAC2)
 }
 
-func (c *hvac) SetHeat(on bool) bool {
-	if on == c.heat {
-		return on
+func (c *hvac) setAC2(on bool) (bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if on == c.ac2.on {
+		return on, nil
 	}
-	if c.ac {
-		logrus.Error("Illegal attempt to engage heat while AC is on")
-		return c.Heat()
+	if on && !c.ac.on {
+		logrus.Error("Illegal attempt to engage second stage AC while the first stage is off")
+		return c.ac2.on, nil
 	}
 
+	_, err := c.ac2.trySet(c.clock.Now(), on)
+	return c.ac2.on, err
+}
+
+// SetHeat switches the heat, queueing the switch if a protection timer defers it
+func (c *hvac) SetHeat(on bool) (bool, error) {
+	if !on {
+		c.cancel("heat2")
+	}
+	return c.run("heat", on, c.setHeat)
+}
+
+func (c *hvac) setHeat(on bool) (bool, error) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	now := time.Now()
-	if !c.canSwitch(now, on) {
-		return c.heat
+	if on == c.heat.on {
+		return on, nil
+	}
+	if c.ac.on {
+		logrus.Error("Illegal attempt to engage heat while AC is on")
+		return c.heat.on, nil
 	}
 
-	logrus.WithField("on", on).Info("toggling heat")
-
-	level := gpio.Low
-	if on {
-		level = gpio.High
+	now := c.clock.Now()
+	if err := c.heat.check(now, on); err != nil {
+		return c.heat.on, err
 	}
-	if err := c.heatPin.Out(level); err != nil {
-		log.Fatal(err)
+	// the second stage can't run on its own
+	if !on {
+		if off, err := c.heat2.trySet(now, false); !off {
+			return c.heat.on, err
+		}
 	}
 
-	if on {
-		// this is probably too short, but I don't want the heater to overheat
-		time.AfterFunc(time.Second*15, func() {
-			c.SetFan(true)
-		})
-	} else {
-		time.AfterFunc(time.Second*30, func() {
-			c.SetFan(false)
-		})
+	logrus.WithField("on", on).Info("toggling heat")
+
+	if err := c.heat.set(now, on); err != nil {
+		return c.heat.on, err
 	}
+	// the fan lead is probably too short, but I don't want the heater to overheat
+	c.followFan(c.heat.timing, on)
+
+	return c.heat.on, nil
+}
 
-	c.heat = on
-	c.last = now
-	return c.Heat()
+// SetHeat2 switches the second stage heat, queueing the switch if a protection timer defers it
+func (c *hvac) SetHeat2(on bool) (bool, error) {
+	return c.run("heat2", on, c.setHeat2)
 }
 
-func (c hvac) canSwitch(now time.Time, on bool) bool {
-	delta := time.Since(c.last).Round(time.Second)
-	if on && delta < time.Second*switchInterval {
-		logrus.WithFields(logrus.Fields{
-			"since": delta.String(),
-			"stack": string(debug.Stack()),
-		}).Warn("Attempted to engage a system too soon")
-		return false
+func (c *hvac) setHeat2(on bool) (bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if on == c.heat2.on {
+		return on, nil
+	}
+	if on && !c.heat.on {
+		logrus.Error("Illegal attempt to engage second stage heat while the first stage is off")
+		return c.heat2.on, nil
 	}
 
-	return true
+	_, err := c.heat2.trySet(c.clock.Now(), on)
+	return c.heat2.on, err
 }
 
-func (c hvac) Test() {
+func (c *hvac) Test() {
 	c.SetFan(true)
-	time.Sleep(time.Second * (switchInterval + 1))
+	time.Sleep(time.Second * 5)
 	c.SetAC(true)
-	time.Sleep(time.Second * (switchInterval + 1))
+	if c.ac2.installed() {
+		time.Sleep(c.ac2.timing.minOff + time.Second)
+		c.SetAC2(true)
+		time.Sleep(c.ac2.timing.minOn + time.Second)
+		c.SetAC2(false)
+	}
+	time.Sleep(c.ac.timing.minOn + time.Second)
 	c.SetAC(false)
-	time.Sleep(time.Second * (switchInterval + 1))
+	time.Sleep(c.ac.timing.fanOverrun + time.Second)
 	c.SetFan(true)
 	c.SetHeat(true)
-	time.Sleep(time.Second * (switchInterval + 1))
+	if c.heat2.installed() {
+		time.Sleep(c.heat2.timing.minOff + time.Second)
+		c.SetHeat2(true)
+		time.Sleep(c.heat2.timing.minOn + time.Second)
+		c.SetHeat2(false)
+	}
+	time.Sleep(c.heat.timing.minOn + time.Second)
 	c.SetHeat(false)
-	time.Sleep(time.Second * (switchInterval + 1))
+	time.Sleep(c.heat.timing.fanOverrun + time.Second)
 	c.SetFan(false)
 }
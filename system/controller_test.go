@@ -0,0 +1,223 @@
+package system
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"thermostat/clock"
+	"thermostat/relay"
+	"time"
+)
+
+func testHVAC(journal *relay.Journal, clk clock.Clock) *hvac {
+	fanTiming := timing{fanLead: time.Millisecond, fanOverrun: 2 * time.Millisecond}
+	return &hvac{
+		fan:         blower{stage: stage{name: "fan", relay: relay.NewFake("fan", journal)}, clock: clk},
+		ac:          stage{name: "ac", relay: relay.NewFake("ac", journal), timing: fanTiming},
+		ac2:         stage{name: "ac2", relay: relay.NewFake("ac2", journal)},
+		heat:        stage{name: "heat", relay: relay.NewFake("heat", journal), timing: fanTiming},
+		accessories: newAccessories(Accessories{}, clk),
+		queue:       queue{clock: clk},
+		clock:       clk,
+	}
+}
+
+func on(t *testing.T) func(state bool, err error) {
+	return func(state bool, err error) {
+		t.Helper()
+		require.NoError(t, err)
+		assert.True(t, state)
+	}
+}
+
+func off(t *testing.T) func(state bool, err error) {
+	return func(state bool, err error) {
+		t.Helper()
+		require.NoError(t, err)
+		assert.False(t, state)
+	}
+}
+
+func TestStage_Check(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	tests := []struct {
+		name     string
+		on       bool
+		last     time.Duration // how long ago the stage last switched
+		started  time.Duration // how long ago the stage last turned on
+		expected string        // the timer that defers the switch, if any
+	}{
+		{"start after rest", true, 10 * time.Minute, 20 * time.Minute, ""},
+		{"start too soon after stopping", true, time.Minute, 20 * time.Minute, "minOff"},
+		{"start too soon after starting", true, 5 * time.Minute, 10 * time.Minute, "minCycle"},
+		{"stop after running", false, 5 * time.Minute, 5 * time.Minute, ""},
+		{"stop too soon after starting", false, time.Minute, time.Minute, "minOn"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := stage{
+				name:    "ac",
+				on:      !tt.on,
+				last:    now.Add(-tt.last),
+				started: now.Add(-tt.started),
+				timing:  timing{minOn: 3 * time.Minute, minOff: 2 * time.Minute, minCycle: 15 * time.Minute},
+			}
+
+			err := s.check(now, tt.on)
+			if tt.expected == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var d *Deferred
+			require.True(t, errors.As(err, &d))
+			assert.Equal(t, "ac", d.Output)
+			assert.Equal(t, tt.on, d.On)
+			assert.Equal(t, tt.expected, d.Timer)
+			assert.True(t, d.Until.After(now))
+		})
+	}
+}
+
+func TestHVAC_Deferred(t *testing.T) {
+	t.Parallel()
+
+	c := testHVAC(nil, clock.New())
+	c.heat.timing.minOn = time.Hour
+	c.heat.timing.minOff = time.Hour
+	on(t)(c.SetHeat(true))
+
+	state, err := c.SetHeat(false)
+	assert.True(t, state)
+	assert.True(t, c.heat.relay.(*relay.Fake).On())
+	var d *Deferred
+	require.True(t, errors.As(err, &d))
+	assert.Equal(t, "heat", d.Output)
+	assert.Equal(t, "minOn", d.Timer)
+
+	c.heat.last = c.heat.last.Add(-time.Hour)
+	off(t)(c.SetHeat(false))
+	state, err = c.SetHeat(true)
+	assert.False(t, state)
+	require.True(t, errors.As(err, &d))
+	assert.Equal(t, "minOff", d.Timer)
+}
+
+func TestHVAC_Stage2(t *testing.T) {
+	t.Parallel()
+
+	c := testHVAC(nil, clock.New())
+	off(t)(c.SetAC2(true)) // second stage must not run without the first stage
+	on(t)(c.SetAC(true))
+	on(t)(c.SetAC2(true))
+	assert.True(t, c.ac2.relay.(*relay.Fake).On())
+
+	off(t)(c.SetAC(false))
+	assert.False(t, c.AC2(), "second stage must turn off with the first stage")
+	assert.False(t, c.ac2.relay.(*relay.Fake).On())
+
+	on(t)(c.SetHeat(true))
+	off(t)(c.SetHeat2(true)) // second stage is not installed
+}
+
+func TestHVAC_Stage2MinOn(t *testing.T) {
+	t.Parallel()
+
+	c := testHVAC(nil, clock.New())
+	c.ac2.timing.minOn = time.Hour
+	on(t)(c.SetAC(true))
+	on(t)(c.SetAC2(true))
+	state, err := c.SetAC(false) // first stage must wait for the second stage minimum on time
+	assert.True(t, state)
+	assert.True(t, c.AC2())
+	var d *Deferred
+	require.True(t, errors.As(err, &d))
+	assert.Equal(t, "ac2", d.Output)
+	assert.Equal(t, "minOn", d.Timer)
+}
+
+func TestHVAC_FanSequence(t *testing.T) {
+	t.Parallel()
+
+	journal := &relay.Journal{}
+	c := testHVAC(journal, clock.New())
+	on(t)(c.SetAC(true))
+	time.Sleep(10 * time.Millisecond)
+	off(t)(c.SetAC(false))
+	time.Sleep(10 * time.Millisecond)
+
+	events := journal.Events()
+	require.Len(t, events, 4)
+	expected := []relay.Event{
+		{Relay: "ac", On: true},
+		{Relay: "fan", On: true},
+		{Relay: "ac", On: false},
+		{Relay: "fan", On: false},
+	}
+	for i, e := range expected {
+		assert.Equal(t, e.Relay, events[i].Relay, "event %d", i)
+		assert.Equal(t, e.On, events[i].On, "event %d", i)
+	}
+	assert.True(t, events[1].Time.Sub(events[0].Time) >= c.ac.timing.fanLead)
+	assert.True(t, events[3].Time.Sub(events[2].Time) >= c.ac.timing.fanOverrun)
+}
+
+func TestHVAC_FanCall(t *testing.T) {
+	t.Parallel()
+
+	c := testHVAC(nil, clock.New())
+	on(t)(c.SetFan(true))
+	on(t)(c.SetAC(true))
+	time.Sleep(10 * time.Millisecond)
+	off(t)(c.SetAC(false))
+	time.Sleep(10 * time.Millisecond)
+	assert.True(t, c.Fan(), "the fan call must outlast the fan overrun")
+
+	off(t)(c.SetFan(false))
+	on(t)(c.SetHeat(true))
+	time.Sleep(10 * time.Millisecond)
+	on(t)(c.SetFan(false)) // the fan must keep running with the heat
+	assert.True(t, c.fan.relay.(*relay.Fake).On())
+}
+
+func TestHVAC_Accessories(t *testing.T) {
+	t.Parallel()
+
+	c := testHVAC(nil, clock.New())
+	off(t)(c.SetDehumidifier(true)) // not installed
+	assert.NotContains(t, c.Outputs(), "dehumidifier")
+
+	c.accessories = newAccessories(Accessories{Dehumidifier: relay.NewFake("dehumidifier", nil)}, c.clock)
+	on(t)(c.SetDehumidifier(true))
+	assert.True(t, c.Outputs()["dehumidifier"])
+
+	require.NoError(t, c.Reset())
+	assert.False(t, c.dehumidifier.relay.(*relay.Fake).On())
+}
+
+func TestHVAC_Interlock(t *testing.T) {
+	t.Parallel()
+
+	c := testHVAC(nil, clock.New())
+	on(t)(c.SetHeat(true))
+	off(t)(c.SetAC(true)) // AC must not run while heat is on
+	assert.False(t, c.ac.relay.(*relay.Fake).On())
+}
+
+func TestHVAC_RelayError(t *testing.T) {
+	t.Parallel()
+
+	c := testHVAC(nil, clock.New())
+	failure := errors.New("relay failure")
+	c.ac.relay.(*relay.Fake).Fail(failure)
+
+	state, err := c.SetAC(true)
+	assert.Equal(t, failure, err)
+	assert.False(t, state)
+	assert.False(t, c.AC())
+
+	assert.Equal(t, failure, c.Reset())
+}
@@ -0,0 +1,383 @@
+package system
+
+import (
+	"github.com/sirupsen/logrus"
+	"sort"
+	"sync"
+	"thermostat/clock"
+	"thermostat/relay"
+	"time"
+)
+
+// Arbiter shares one air handler between zones, each behind a motorized damper. Each zone controls the air handler
+// through its own damper zone, and the arbiter runs the air handler in one mode at a time for the zones calling for it.
+// When some zones call for heat and others for AC, the side with the highest priority zone goes first, and the other
+// side gets a turn once the first has run for the changeover time. While the blower runs, at least minOpen dampers are
+// kept open so the air handler isn't starved of airflow. When it doesn't run, every damper rests open.
+type Arbiter struct {
+	name       string
+	equipment  Equipment
+	minOpen    int
+	changeover time.Duration
+	clock      clock.Clock
+
+	zones     []*DamperZone // by priority, highest first
+	mode      Demand
+	modeSince time.Time
+	errs      map[string]error // from switching each of the equipment's outputs, the last time the arbiter applied calls
+	tested    bool
+	mutex     sync.Mutex
+}
+
+// NewArbiter returns an arbiter sharing equipment between zones
+func NewArbiter(name string, equipment Equipment, minOpen int, changeover time.Duration, clk clock.Clock) *Arbiter {
+	return &Arbiter{
+		name:       name,
+		equipment:  equipment,
+		minOpen:    minOpen,
+		changeover: changeover,
+		clock:      clk,
+		errs:       make(map[string]error),
+	}
+}
+
+// DamperZone is a zone's view of an arbiter's equipment. It implements Controller. Heat and AC only report running
+// while the arbiter is serving the zone's call.
+type DamperZone struct {
+	arbiter  *Arbiter
+	name     string
+	damper   stage // on when open
+	priority int
+
+	fan, ac, ac2, heat, heat2 bool // what the zone calls for
+}
+
+// Zone returns the named zone's controller, which opens and closes damper as the zone is served.
+// Zones with a higher priority are served first when zones call for both heat and AC.
+func (a *Arbiter) Zone(name string, damper relay.Relay, priority int) *DamperZone {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	z := &DamperZone{
+		arbiter:  a,
+		name:     name,
+		damper:   stage{name: name + " damper", relay: damper},
+		priority: priority,
+	}
+	zones := a.zones[:0]
+	for _, other := range a.zones {
+		if other.name != name {
+			zones = append(zones, other)
+		}
+	}
+	a.zones = append(zones, z)
+	sort.SliceStable(a.zones, func(i, j int) bool {
+		return a.zones[i].priority > a.zones[j].priority
+	})
+	return z
+}
+
+// calling reports if the zone calls for d
+func (z *DamperZone) calling(d Demand) bool {
+	switch d {
+	case Heating:
+		return z.heat
+	case Cooling:
+		return z.ac
+	default:
+		return false
+	}
+}
+
+// choose decides which mode to run the equipment in. Callers must hold the mutex.
+func (a *Arbiter) choose(now time.Time) Demand {
+	heat, cool := -1, -1 // the priority of the highest priority zone calling for each, or -1 if none are
+	for _, z := range a.zones {
+		if z.heat && heat < 0 {
+			heat = z.priority
+		}
+		if z.ac && cool < 0 {
+			cool = z.priority
+		}
+	}
+
+	switch {
+	case heat < 0 && cool < 0:
+		return Idle
+	case cool < 0:
+		return Heating
+	case heat < 0:
+		return Cooling
+	case a.mode == Idle && cool > heat:
+		return Cooling
+	case a.mode == Idle:
+		return Heating
+	case now.Sub(a.modeSince) >= a.changeover:
+		if a.mode == Heating {
+			return Cooling
+		}
+		return Heating
+	default:
+		return a.mode
+	}
+}
+
+// apply settles the zones' calls, then switches the dampers and the equipment to match. Callers must hold the mutex.
+func (a *Arbiter) apply(now time.Time) {
+	if mode := a.choose(now); mode != a.mode {
+		logrus.WithFields(logrus.Fields{
+			"airHandler": a.name,
+			"from":       a.mode.String(),
+			"to":         mode.String(),
+		}).Info("changing air handler mode")
+		a.mode = mode
+		a.modeSince = now
+	}
+
+	var fan, stage2 bool
+	open := make(map[*DamperZone]bool)
+	for _, z := range a.zones {
+		fan = fan || z.fan
+		switch a.mode {
+		case Idle:
+			open[z] = z.fan
+		case Heating:
+			open[z] = z.heat
+			stage2 = stage2 || (z.heat && z.heat2)
+		case Cooling:
+			open[z] = z.ac
+			stage2 = stage2 || (z.ac && z.ac2)
+		}
+	}
+	a.setDampers(now, open, fan || a.mode != Idle || a.equipment.Fan())
+
+	a.errs = make(map[string]error)
+	set := func(output string, f func(on bool) (bool, error), on bool) {
+		if _, err := f(on); err != nil {
+			a.errs[output] = err
+		}
+	}
+	if a.mode != Cooling {
+		set("ac", a.equipment.SetAC, false)
+	}
+	if a.mode != Heating {
+		set("heat", a.equipment.SetHeat, false)
+	}
+	switch {
+	case a.mode == Cooling && !a.equipment.Heat(): // heat may still be finishing its minimum run
+		set("ac", a.equipment.SetAC, true)
+		if a.equipment.AC() {
+			set("ac2", a.equipment.SetAC2, stage2)
+		}
+	case a.mode == Heating && !a.equipment.AC():
+		set("heat", a.equipment.SetHeat, true)
+		if a.equipment.Heat() {
+			set("heat2", a.equipment.SetHeat2, stage2)
+		}
+	}
+	set("fan", a.equipment.SetFan, fan)
+}
+
+// setDampers opens the dampers in open, adding more in priority order until at least minOpen are open while the blower
+// runs. Every damper opens when it doesn't. Dampers are opened before any are closed. Callers must hold the mutex.
+func (a *Arbiter) setDampers(now time.Time, open map[*DamperZone]bool, blower bool) {
+	count := 0
+	for _, z := range a.zones {
+		if !blower {
+			open[z] = true
+		}
+		if open[z] {
+			count++
+		}
+	}
+	for _, z := range a.zones {
+		if count >= a.minOpen {
+			break
+		}
+		if !open[z] {
+			open[z] = true
+			count++
+		}
+	}
+
+	for _, on := range []bool{true, false} {
+		for _, z := range a.zones {
+			if open[z] != on {
+				continue
+			}
+			if _, err := z.damper.trySet(now, on); err != nil {
+				logrus.WithError(err).WithField("zone", z.name).Error("failed to switch damper")
+			}
+		}
+	}
+}
+
+// call records the zone's call for output and applies it, returning whether the zone is being served
+func (z *DamperZone) call(output string, call *bool, on bool) (bool, error) {
+	a := z.arbiter
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	*call = on
+	if !z.ac {
+		z.ac2 = false
+	}
+	if !z.heat {
+		z.heat2 = false
+	}
+	a.apply(a.clock.Now())
+
+	state := z.state(output)
+	if state == on || output == "fan" {
+		return state, a.errs[output]
+	}
+	if err := a.errs[output]; err != nil {
+		return state, err
+	}
+	if on && a.mode != demandFor(output) {
+		return state, z.waiting(output)
+	}
+	return state, nil
+}
+
+// demandFor returns the mode that serves output
+func demandFor(output string) Demand {
+	switch output {
+	case "ac", "ac2":
+		return Cooling
+	case "heat", "heat2":
+		return Heating
+	default:
+		return Idle
+	}
+}
+
+// waiting returns the deferral for an output the zone calls for but isn't being served. Callers must hold the mutex.
+func (z *DamperZone) waiting(output string) *Deferred {
+	return &Deferred{Output: output, On: true, Timer: "changeover", Until: z.arbiter.modeSince.Add(z.arbiter.changeover)}
+}
+
+// state returns if output is running for the zone. Callers must hold the mutex.
+func (z *DamperZone) state(output string) bool {
+	a := z.arbiter
+	switch output {
+	case "fan":
+		return z.damper.on && a.equipment.Fan()
+	case "ac":
+		return z.ac && a.mode == Cooling && a.equipment.AC()
+	case "ac2":
+		return z.ac2 && a.mode == Cooling && a.equipment.AC2()
+	case "heat":
+		return z.heat && a.mode == Heating && a.equipment.Heat()
+	case "heat2":
+		return z.heat2 && a.mode == Heating && a.equipment.Heat2()
+	default:
+		return false
+	}
+}
+
+func (z *DamperZone) get(output string) bool {
+	z.arbiter.mutex.Lock()
+	defer z.arbiter.mutex.Unlock()
+	return z.state(output)
+}
+
+func (z *DamperZone) Fan() bool {
+	return z.get("fan")
+}
+
+func (z *DamperZone) SetFan(on bool) (bool, error) {
+	return z.call("fan", &z.fan, on)
+}
+
+func (z *DamperZone) AC() bool {
+	return z.get("ac")
+}
+
+func (z *DamperZone) SetAC(on bool) (bool, error) {
+	return z.call("ac", &z.ac, on)
+}
+
+func (z *DamperZone) AC2() bool {
+	return z.get("ac2")
+}
+
+func (z *DamperZone) SetAC2(on bool) (bool, error) {
+	return z.call("ac2", &z.ac2, on)
+}
+
+func (z *DamperZone) Heat() bool {
+	return z.get("heat")
+}
+
+func (z *DamperZone) SetHeat(on bool) (bool, error) {
+	return z.call("heat", &z.heat, on)
+}
+
+func (z *DamperZone) Heat2() bool {
+	return z.get("heat2")
+}
+
+func (z *DamperZone) SetHeat2(on bool) (bool, error) {
+	return z.call("heat2", &z.heat2, on)
+}
+
+// Outputs returns the state of the shared equipment's relays, along with the zone's damper
+func (z *DamperZone) Outputs() map[string]bool {
+	z.arbiter.mutex.Lock()
+	defer z.arbiter.mutex.Unlock()
+
+	outputs := z.arbiter.equipment.Outputs()
+	outputs["damper"] = z.damper.on
+	return outputs
+}
+
+// Pending returns the shared equipment's deferred switches, along with the zone's calls waiting on the other mode
+func (z *DamperZone) Pending() map[string]Deferred {
+	z.arbiter.mutex.Lock()
+	defer z.arbiter.mutex.Unlock()
+
+	pending := z.arbiter.equipment.Pending()
+	for _, output := range []string{"ac", "heat"} {
+		d := demandFor(output)
+		if _, ok := pending[output]; !ok && z.calling(d) && z.arbiter.mode != d {
+			pending[output] = *z.waiting(output)
+		}
+	}
+	return pending
+}
+
+// Reset drops the zone's calls. The shared equipment keeps serving the other zones.
+func (z *DamperZone) Reset() error {
+	z.arbiter.mutex.Lock()
+	defer z.arbiter.mutex.Unlock()
+
+	z.fan, z.ac, z.ac2, z.heat, z.heat2 = false, false, false, false, false
+	z.arbiter.apply(z.arbiter.clock.Now())
+	return nil
+}
+
+// Test cycles the zone's damper. The shared equipment is tested along with the first of its zones.
+func (z *DamperZone) Test() {
+	a := z.arbiter
+	a.mutex.Lock()
+	test := !a.tested
+	a.tested = true
+	_, err := z.damper.trySet(a.clock.Now(), false)
+	a.mutex.Unlock()
+	if err != nil {
+		logrus.WithError(err).WithField("zone", z.name).Error("failed to close damper")
+	}
+
+	time.Sleep(time.Second * 5)
+	a.mutex.Lock()
+	_, err = z.damper.trySet(a.clock.Now(), true)
+	a.mutex.Unlock()
+	if err != nil {
+		logrus.WithError(err).WithField("zone", z.name).Error("failed to open damper")
+	}
+
+	if test {
+		a.equipment.Test()
+	}
+}
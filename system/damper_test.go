@@ -0,0 +1,108 @@
+package system
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"thermostat/clock"
+	"thermostat/relay"
+	"time"
+)
+
+func testArbiter(minOpen int) (*Arbiter, *DamperZone, *DamperZone) {
+	a := NewArbiter("test", testHVAC(nil, clock.New()), minOpen, time.Hour, clock.New())
+	up := a.Zone("up", relay.NewFake("up damper", nil), 1)
+	down := a.Zone("down", relay.NewFake("down damper", nil), 0)
+	return a, up, down
+}
+
+func TestArbiter(t *testing.T) {
+	t.Parallel()
+	a, up, down := testArbiter(1)
+
+	on(t)(up.SetAC(true))
+	assert.True(t, up.AC())
+	assert.False(t, down.AC())
+	assert.True(t, up.damper.on)
+	assert.False(t, down.damper.on)
+
+	// the other zone waits for its turn
+	state, err := down.SetHeat(true)
+	assert.False(t, state)
+	var d *Deferred
+	require.True(t, errors.As(err, &d))
+	assert.Equal(t, "changeover", d.Timer)
+	assert.Contains(t, down.Pending(), "heat")
+	assert.False(t, a.equipment.Heat())
+
+	// and is served as soon as the first zone is satisfied
+	off(t)(up.SetAC(false))
+	assert.True(t, down.Heat())
+	assert.False(t, a.equipment.AC())
+	assert.True(t, down.damper.on)
+	assert.False(t, up.damper.on)
+	assert.Empty(t, down.Pending())
+
+	// every damper opens once nothing runs
+	off(t)(down.SetHeat(false))
+	assert.True(t, up.damper.on)
+	assert.True(t, down.damper.on)
+}
+
+func TestArbiter_Changeover(t *testing.T) {
+	t.Parallel()
+	a, up, down := testArbiter(1)
+
+	on(t)(down.SetHeat(true))
+	_, err := up.SetAC(true)
+	require.Error(t, err)
+	assert.Equal(t, Heating, a.mode)
+
+	// once heat has had the changeover time, AC gets a turn
+	a.mutex.Lock()
+	a.modeSince = time.Now().Add(-2 * time.Hour)
+	a.mutex.Unlock()
+	_, err = up.SetAC(true)
+	require.NoError(t, err)
+	assert.Equal(t, Cooling, a.mode)
+	assert.True(t, up.AC())
+	assert.False(t, down.Heat())
+	assert.False(t, down.damper.on)
+}
+
+func TestArbiter_Choose(t *testing.T) {
+	t.Parallel()
+	a, up, down := testArbiter(1)
+	now := time.Now()
+
+	assert.Equal(t, Idle, a.choose(now))
+
+	// the highest priority zone goes first
+	up.heat, down.ac = true, true
+	assert.Equal(t, Heating, a.choose(now))
+	up.heat, up.ac, down.ac, down.heat = false, true, false, true
+	assert.Equal(t, Cooling, a.choose(now))
+
+	// the running mode keeps going until the changeover time
+	a.mode, a.modeSince = Heating, now.Add(-time.Minute)
+	assert.Equal(t, Heating, a.choose(now))
+	a.modeSince = now.Add(-time.Hour)
+	assert.Equal(t, Cooling, a.choose(now))
+}
+
+func TestArbiter_MinOpen(t *testing.T) {
+	t.Parallel()
+	_, up, down := testArbiter(2)
+
+	on(t)(down.SetAC(true))
+	assert.True(t, down.damper.on)
+	assert.True(t, up.damper.on, "a second damper is kept open for airflow")
+	assert.False(t, up.AC())
+
+	on(t)(down.SetAC2(true))
+	assert.True(t, down.AC2())
+	require.NoError(t, down.Reset())
+	assert.False(t, down.AC())
+	assert.False(t, down.ac2)
+}
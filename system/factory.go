@@ -0,0 +1,366 @@
+package system
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"sort"
+	"strings"
+	"sync"
+	"thermostat/clock"
+	"thermostat/db/calibration"
+	"thermostat/relay"
+	"thermostat/sensor"
+	"time"
+)
+
+// Equipment is a zone's controller, along with its power on self test
+type Equipment interface {
+	Controller
+	Test()
+}
+
+// configKeys finds the config keys for a zone, or for an air handler shared between zones
+type configKeys struct {
+	prefix   string // zones.<name>. or airHandlers.<name>.
+	fallback bool   // hardware keys fall back to the top level keys, so single zone configs keep working
+}
+
+func zoneKeys(zone string) configKeys {
+	return configKeys{prefix: "zones." + zone + ".", fallback: zone == "default"}
+}
+
+// hardware returns the config key for a piece of hardware
+func (k configKeys) hardware(key string) string {
+	if k.fallback && !viper.IsSet(k.prefix+key) {
+		return key
+	}
+	return k.prefix + key
+}
+
+// setting returns the config key for a tuning setting, which falls back to the top level key
+func (k configKeys) setting(key string) string {
+	if !viper.IsSet(k.prefix + key) {
+		return key
+	}
+	return k.prefix + key
+}
+
+// NewEquipment builds the named zone's controller from the config, with protection timers run by clk. Zones with an
+// airHandler share its equipment through a damper.
+func NewEquipment(zone string, clk clock.Clock) (Equipment, error) {
+	keys := zoneKeys(zone)
+	if key := keys.hardware("airHandler"); viper.IsSet(key) {
+		a, err := loadArbiter(viper.GetString(key), clk)
+		if err != nil {
+			return nil, err
+		}
+		damper, err := relay.FromConfig(keys.hardware("damper"))
+		if err != nil {
+			return nil, err
+		}
+		if damper == nil && viper.GetString("backend") == "simulation" {
+			damper = relay.NewFake(zone+" damper", nil)
+		}
+		if damper == nil {
+			return nil, fmt.Errorf("zone %s has no damper configured", zone)
+		}
+		return a.Zone(zone, damper, viper.GetInt(keys.setting("damperPriority"))), nil
+	}
+
+	return newEquipment(zone, keys, clk)
+}
+
+// newEquipment builds a controller from the config under keys
+func newEquipment(name string, keys configKeys, clk clock.Clock) (Equipment, error) {
+	if viper.GetString("backend") == "simulation" {
+		return newSimulatedHVAC(keys, clk)
+	}
+
+	var err error
+	relays := make(map[string]relay.Relay)
+	for _, output := range []string{"fan", "ac", "ac2", "heat", "heat2", "aux", "humidifier", "dehumidifier", "fanLow"} {
+		if relays[output], err = loadRelay(keys, output); err != nil {
+			return nil, err
+		}
+	}
+	required := []string{"fan", "ac", "heat"}
+	controller := viper.GetString(keys.setting("controller"))
+	if controller == "heatPump" {
+		required = append(required, "aux")
+	}
+	for _, output := range required {
+		if relays[output] == nil {
+			return nil, fmt.Errorf("%s has no relay configured for %s", name, output)
+		}
+	}
+
+	acc := Accessories{
+		Humidifier:   relays["humidifier"],
+		Dehumidifier: relays["dehumidifier"],
+		FanLow:       relays["fanLow"],
+	}
+	switch controller {
+	case "heatPump":
+		cached, err := LoadOutdoor(clk)
+		if err != nil {
+			return nil, err
+		}
+		if key := keys.hardware("heatPump.outdoorSensor"); viper.IsSet(key) {
+			s, err := newSource(context.Background(), name+"/outdoor", viper.GetString(keys.hardware("heatPump.outdoorSensorType")),
+				viper.GetString(key), 0, 1, 0, clk)
+			if err != nil {
+				return nil, fmt.Errorf("%s outdoor sensor: %w", name, err)
+			}
+			cached = NewCachedOutdoor(SensorOutdoor{Sensor: s, Clock: clk}, time.Second*time.Duration(viper.GetInt("outdoor.cacheTime")), clk)
+		}
+		// the controller reads the outdoor temperature while it holds its lock, so it only reads it from the cache
+		var outdoor Outdoor
+		if cached != nil {
+			outdoor = cached
+		}
+		return NewHeatPump(relays["fan"], relays["ac"], relays["ac2"], relays["heat"], relays["aux"], outdoor, acc, clk)
+	default:
+		return NewHVAC(relays["fan"], relays["ac"], relays["heat"], relays["ac2"], relays["heat2"], acc, clk)
+	}
+}
+
+var (
+	arbiters      = make(map[string]*Arbiter)
+	arbitersMutex sync.Mutex
+)
+
+// loadArbiter returns the arbiter for the named air handler, building its equipment from airHandlers.<name> the first
+// time it is needed
+func loadArbiter(name string, clk clock.Clock) (*Arbiter, error) {
+	arbitersMutex.Lock()
+	defer arbitersMutex.Unlock()
+
+	if a, ok := arbiters[name]; ok {
+		return a, nil
+	}
+	keys := configKeys{prefix: "airHandlers." + name + "."}
+	equipment, err := newEquipment("air handler "+name, keys, clk)
+	if err != nil {
+		return nil, err
+	}
+
+	a := NewArbiter(name, equipment,
+		int(configFloat(keys.prefix+"minOpen", 1)),
+		time.Second*time.Duration(configFloat(keys.prefix+"changeover", 1200)), clk)
+	arbiters[name] = a
+	return a, nil
+}
+
+// loadRelay builds the relay for the named output from relays.<name> in the config, falling back to the <name>Pin
+// GPIO pin. Returns nil if neither is configured, or if the pin is negative.
+func loadRelay(keys configKeys, name string) (relay.Relay, error) {
+	r, err := relay.FromConfig(keys.hardware("relays." + name))
+	if err != nil || r != nil {
+		return r, err
+	}
+
+	pin := keys.hardware(name + "Pin")
+	if !viper.IsSet(pin) || viper.GetInt(pin) < 0 {
+		return nil, nil
+	}
+	return relay.NewGPIO(viper.GetInt(pin), false)
+}
+
+// NewSensor builds the named zone's sensor from the config, with its calibration from the database. The simulation
+// backend models equipment's effect on the zone.
+func NewSensor(ctx context.Context, zone string, equipment sensor.Equipment, clk clock.Clock) (Sensor, error) {
+	keys := zoneKeys(zone)
+	if viper.GetString("backend") == "simulation" {
+		return withCalibration(ctx, sensor.NewSimulation(equipment,
+			viper.GetFloat64(keys.setting("simulation.temperature")),
+			viper.GetFloat64(keys.setting("simulation.humidity")),
+			viper.GetFloat64(keys.setting("simulation.outdoor")),
+			viper.GetFloat64(keys.setting("simulation.loss")),
+			viper.GetFloat64(keys.setting("simulation.heatRate")),
+			viper.GetFloat64(keys.setting("simulation.coolRate")), clk), calibration.Default(zone), clk)
+	}
+
+	if key := keys.hardware("sensors"); viper.IsSet(key) {
+		return newComposite(ctx, zone, keys, key, clk)
+	}
+
+	key := keys.hardware("tempSensor")
+	if !viper.IsSet(key) {
+		return nil, fmt.Errorf("zone %s has no temperature sensor configured", zone)
+	}
+	s, err := newSource(ctx, zone, viper.GetString(keys.hardware("tempSensorType")), viper.GetString(key),
+		viper.GetFloat64(keys.setting("tempCorrection")),
+		configFloat(keys.setting("temperatureRangeDivider"), 1),
+		viper.GetFloat64(keys.setting("humCorrection")), clk)
+	if err != nil {
+		return nil, err
+	}
+	return withFilters(s, keys.hardware("filters"), clk)
+}
+
+// newComposite builds a sensor combining each room's sensor under key, using the zone's sensorMethod
+func newComposite(ctx context.Context, zone string, keys configKeys, key string, clk clock.Clock) (Sensor, error) {
+	c, err := sensor.NewComposite(sensor.Method(viper.GetString(keys.hardware("sensorMethod"))), clk)
+	if err != nil {
+		return nil, err
+	}
+
+	rooms := make([]string, 0, 4)
+	for room := range viper.GetStringMap(key) {
+		rooms = append(rooms, room)
+	}
+	if len(rooms) == 0 {
+		return nil, fmt.Errorf("zone %s has no sensors configured", zone)
+	}
+	sort.Strings(rooms)
+	for _, room := range rooms {
+		k := key + "." + room + "."
+		s, err := roomSensor(ctx, zone+"/"+room, k, clk)
+		if err != nil {
+			return nil, fmt.Errorf("sensor %s in zone %s: %w", room, zone, err)
+		}
+		c.Add(room, s, configFloat(k+"weight", 1))
+	}
+	return c, nil
+}
+
+// roomSensor builds the named sensor in a composite sensor from the config under k
+func roomSensor(ctx context.Context, name, k string, clk clock.Clock) (sensor.Source, error) {
+	typ := viper.GetString(k + "type")
+	addr := viper.GetString(k + "address")
+	if typ == "ds18b20" || typ == "remote" {
+		addr = viper.GetString(k + "id")
+	}
+	s, err := newSource(ctx, name, typ, addr,
+		viper.GetFloat64(k+"tempCorrection"),
+		configFloat(k+"temperatureRangeDivider", 1),
+		viper.GetFloat64(k+"humCorrection"), clk)
+	if err != nil {
+		return nil, err
+	}
+	return withFilters(s, k+"filters", clk)
+}
+
+// filterConfig is one of a sensor's filters in the config
+type filterConfig struct {
+	Type       string
+	Size       int
+	Alpha      float64
+	MaxRate    float64
+	MaxRejects int
+}
+
+// withFilters wraps s in the filters listed at key, if there are any, reading s as of clk's now if it can't tell when it
+// was read
+func withFilters(s Sensor, key string, clk clock.Clock) (Sensor, error) {
+	if !viper.IsSet(key) {
+		return s, nil
+	}
+	var configs []filterConfig
+	if err := viper.UnmarshalKey(key, &configs); err != nil {
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+
+	filters := make([]sensor.Filter, len(configs))
+	for i, c := range configs {
+		var err error
+		switch c.Type {
+		case "average":
+			filters[i], err = sensor.NewMovingAverage(c.Size)
+		case "median":
+			filters[i], err = sensor.NewMedian(c.Size)
+		case "exponential":
+			filters[i], err = sensor.NewExponential(c.Alpha)
+		case "rate":
+			maxRejects := c.MaxRejects
+			if maxRejects == 0 {
+				maxRejects = 3
+			}
+			filters[i], err = sensor.NewRateLimit(c.MaxRate, maxRejects)
+		default:
+			err = errors.New("unknown filter type " + c.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return sensor.NewFiltered(s, clk, filters...), nil
+}
+
+// newSource builds the named sensor of type typ at addr, which is an i2c bus address, a 1-Wire id for DS18B20 probes,
+// or the name of a remote sensor, with its calibration from the database. The sensor reads raw values, and the
+// temperature offset and divider and the humidity offset from the config are only its first calibration. Remote
+// sensors' first calibration is under remoteSensors.<name> instead, and they keep time with clk.
+func newSource(ctx context.Context, name, typ, addr string, tempOffset, tempDivider, humOffset float64, clk clock.Clock) (Sensor, error) {
+	var s Sensor
+	switch typ {
+	case "ds18b20":
+		if addr == "" {
+			return nil, errors.New("ds18b20 sensors need an id")
+		}
+		s = sensor.NewDS18B20(viper.GetString("w1.root"), addr, 0, 1)
+	case "remote":
+		remote, err := RemoteSensor(addr, clk)
+		if err != nil {
+			return nil, err
+		}
+		key := "remoteSensors." + strings.ToLower(addr) + "."
+		s, tempOffset, tempDivider, humOffset = remote, viper.GetFloat64(key+"tempCorrection"), 1, viper.GetFloat64(key+"humCorrection")
+	default:
+		i2c, err := sensorAddress(addr)
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case "", "hih6020":
+			s = sensor.NewHIH6020(i2c, 0, 1, 0)
+		case "bme280":
+			s = sensor.NewBME280(i2c, 0, 1, 0)
+		case "sht31":
+			s = sensor.NewSHT31(i2c, 0, 1, 0)
+		default:
+			return nil, errors.New("unknown sensor type " + typ)
+		}
+	}
+
+	seed, err := calibration.FromOffset(name, tempOffset, tempDivider, humOffset)
+	if err != nil {
+		return nil, fmt.Errorf("sensor %s: %w", name, err)
+	}
+	return withCalibration(ctx, s, seed, clk)
+}
+
+// sensorAddress parses an i2c bus address in the form 0x##
+func sensorAddress(s string) (uint16, error) {
+	if len(s) != 4 || s[:2] != "0x" {
+		return 0, errors.New("sensor address " + s + " is not in the form 0x##")
+	}
+	addr, err := hex.DecodeString(s[2:])
+	if err != nil {
+		return 0, err
+	}
+	return uint16(addr[0]), nil
+}
+
+// StartZone builds the named zone's controller and sensor from the config, and starts monitoring it
+func StartZone(ctx context.Context, name string) (*Zone, error) {
+	clk := clock.New()
+	equipment, err := NewEquipment(name, clk)
+	if err != nil {
+		return nil, err
+	}
+	sens, err := NewSensor(ctx, name, equipment, clk)
+	if err != nil {
+		return nil, err
+	}
+
+	z, err := NewZone(ctx, name, equipment, sens, clk)
+	if err != nil {
+		return nil, err
+	}
+	z.Startup()
+	return z, nil
+}
@@ -0,0 +1,157 @@
+package system
+
+import (
+	"context"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"thermostat/clock"
+	"thermostat/db/calibration"
+	"thermostat/sensor"
+)
+
+func TestConfigKeys(t *testing.T) {
+	viper.Set("zones.default.relays.fan", map[string]interface{}{"type": "fake"})
+	viper.Set("zones.upstairs.tempCorrection", 1.5)
+
+	assert.Equal(t, "zones.default.relays.fan", zoneKeys("default").hardware("relays.fan"))
+	assert.Equal(t, "relays.ac", zoneKeys("default").hardware("relays.ac"))
+	assert.Equal(t, "zones.upstairs.relays.ac", zoneKeys("upstairs").hardware("relays.ac"))
+
+	assert.Equal(t, "zones.upstairs.tempCorrection", zoneKeys("upstairs").setting("tempCorrection"))
+	assert.Equal(t, "humCorrection", zoneKeys("upstairs").setting("humCorrection"))
+}
+
+func TestNewEquipment(t *testing.T) {
+	viper.Set("zones.TestNewEquipment.relays", map[string]interface{}{
+		"fan":  map[string]interface{}{"type": "fake"},
+		"ac":   map[string]interface{}{"type": "fake"},
+		"heat": map[string]interface{}{"type": "fake"},
+	})
+
+	e, err := NewEquipment(t.Name(), clock.New())
+	require.NoError(t, err)
+	assert.Contains(t, e.Outputs(), "fan")
+	assert.NotContains(t, e.Outputs(), "humidifier")
+
+	// a heat pump also needs aux heat
+	viper.Set("zones.TestNewEquipment.controller", "heatPump")
+	_, err = NewEquipment(t.Name(), clock.New())
+	assert.Error(t, err)
+
+	_, err = NewEquipment("unconfigured", clock.New())
+	assert.Error(t, err)
+}
+
+func TestNewEquipment_OutdoorSensor(t *testing.T) {
+	viper.Set("zones.TestNewEquipment_OutdoorSensor.controller", "heatPump")
+	viper.Set("zones.TestNewEquipment_OutdoorSensor.relays", map[string]interface{}{
+		"fan":  map[string]interface{}{"type": "fake"},
+		"ac":   map[string]interface{}{"type": "fake"},
+		"heat": map[string]interface{}{"type": "fake"},
+		"aux":  map[string]interface{}{"type": "fake"},
+	})
+	viper.Set("zones.TestNewEquipment_OutdoorSensor.heatPump.outdoorSensor", "TestNewEquipment_OutdoorSensor")
+	viper.Set("zones.TestNewEquipment_OutdoorSensor.heatPump.outdoorSensorType", "remote")
+	viper.Set("remoteSensors.TestNewEquipment_OutdoorSensor", map[string]interface{}{})
+	clk := clock.New()
+
+	e, err := NewEquipment(t.Name(), clk)
+	require.NoError(t, err)
+	remote, err := RemoteSensor(t.Name(), clk)
+	require.NoError(t, err)
+	remote.Push(35, nil)
+
+	require.IsType(t, &heatPump{}, e)
+	require.IsType(t, &CachedOutdoor{}, e.(*heatPump).outdoor)
+	temp, err := e.(*heatPump).outdoor.(*CachedOutdoor).source.Outdoor()
+	require.NoError(t, err)
+	assert.Equal(t, 35.0, temp)
+	assert.Contains(t, CalibratedSensors(), t.Name()+"/outdoor")
+}
+
+func TestSensorAddress(t *testing.T) {
+	t.Parallel()
+
+	addr, err := sensorAddress("0x27")
+	require.NoError(t, err)
+	assert.Equal(t, uint16(0x27), addr)
+	_, err = sensorAddress("27")
+	assert.Error(t, err)
+	_, err = sensorAddress("0xzz")
+	assert.Error(t, err)
+}
+
+func TestNewSensor_Composite(t *testing.T) {
+	viper.Set("zones.TestNewSensor_Composite.sensors", map[string]interface{}{
+		"hallway": map[string]interface{}{"address": "27"},
+	})
+
+	_, err := NewSensor(context.TODO(), t.Name(), nil, clock.New())
+	assert.Error(t, err)
+}
+
+func TestNewSource_SeedsCalibration(t *testing.T) {
+	ctx := context.TODO()
+	name := "remote" + t.Name()
+	viper.Set("remoteSensors."+name, map[string]interface{}{"tempCorrection": 1.5, "humCorrection": -2})
+
+	s, err := newSource(ctx, t.Name(), "remote", name, 0, 1, 0, clock.New())
+	require.NoError(t, err)
+	remote, err := RemoteSensor(name, clock.New())
+	require.NoError(t, err)
+	hum := 40.0
+	remote.Push(70, &hum)
+
+	// the config's corrections are applied once, by the calibration
+	c, err := CalibratedSensor(t.Name())
+	require.NoError(t, err)
+	raw, err := c.Uncalibrated()
+	require.NoError(t, err)
+	assert.Equal(t, 70.0, raw.Temperature)
+	assert.Equal(t, 71.5, s.Temperature())
+	assert.Equal(t, 38.0, s.Humidity())
+
+	saved, err := calibration.Get(ctx, t.Name())
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, saved.Intercept)
+
+	// after that, the calibration in the database replaces the config
+	viper.Set("remoteSensors."+name+".tempCorrection", 3)
+	s, err = newSource(ctx, t.Name(), "remote", name, 0, 1, 0, clock.New())
+	require.NoError(t, err)
+	assert.Equal(t, 71.5, s.Temperature())
+}
+
+func TestWithFilters(t *testing.T) {
+	viper.Set("TestWithFilters.filters", []interface{}{
+		map[string]interface{}{"type": "median", "size": 3},
+		map[string]interface{}{"type": "rate", "maxRate": 2},
+	})
+	viper.Set("TestWithFilters.bad", []interface{}{
+		map[string]interface{}{"type": "exponential", "alpha": 2},
+	})
+
+	s, err := withFilters(constantSensor(70), "TestWithFilters.filters", clock.New())
+	require.NoError(t, err)
+	assert.IsType(t, &sensor.Filtered{}, s)
+	assert.Equal(t, 70.0, s.Temperature())
+
+	s, err = withFilters(constantSensor(70), "TestWithFilters.missing", clock.New())
+	require.NoError(t, err)
+	assert.Equal(t, constantSensor(70), s)
+
+	_, err = withFilters(constantSensor(70), "TestWithFilters.bad", clock.New())
+	assert.Error(t, err)
+}
+
+type constantSensor float64
+
+func (s constantSensor) Temperature() float64 {
+	return float64(s)
+}
+
+func (s constantSensor) Humidity() float64 {
+	return 0
+}
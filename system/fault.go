@@ -0,0 +1,81 @@
+package system
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"math"
+	"thermostat/sensor"
+	"time"
+)
+
+// Fault describes why a zone stopped trusting its sensor
+type Fault struct {
+	Reason string
+	Since  time.Time
+}
+
+// faultDetector decides if a zone's sensor readings can be trusted
+type faultDetector struct {
+	maxAge  time.Duration // oldest a reading may be
+	stuck   time.Duration // longest the temperature may go without changing. 0 to never call it stuck
+	min     float64       // coldest believable temperature
+	max     float64       // warmest believable temperature
+	maxJump float64       // most degrees the temperature may move per minute
+
+	last    sensor.Reading
+	changed time.Time // when the temperature last changed
+	jumped  string    // why the temperature last jumped, until enough readings agree again
+	agreed  int       // readings that have agreed since the jump
+}
+
+// jumpSettle is how many readings in a row must agree with each other before a jump stops being a fault
+const jumpSettle = 3
+
+func newFaultDetector() faultDetector {
+	return faultDetector{
+		maxAge:  time.Second * time.Duration(viper.GetInt("sensor.maxAge")),
+		stuck:   time.Second * time.Duration(viper.GetInt("sensor.stuck")),
+		min:     viper.GetFloat64("sensor.minTemp"),
+		max:     viper.GetFloat64("sensor.maxTemp"),
+		maxJump: viper.GetFloat64("sensor.maxJump"),
+	}
+}
+
+// check returns why the reading r, which failed with err, can't be trusted at now, or "" if it can
+func (d *faultDetector) check(now time.Time, r sensor.Reading, err error) string {
+	if err != nil {
+		return "the sensor failed: " + err.Error()
+	}
+	if age := now.Sub(r.Time); age > d.maxAge {
+		return fmt.Sprintf("the reading is %s old", age.Round(time.Second))
+	}
+	if r.Temperature < d.min || r.Temperature > d.max {
+		return fmt.Sprintf("%.1f is out of range", r.Temperature)
+	}
+
+	last := d.last
+	d.last = r
+	if last.Time.IsZero() || r.Temperature != last.Temperature {
+		d.changed = now
+	}
+	if d.stuck > 0 && now.Sub(d.changed) >= d.stuck {
+		return fmt.Sprintf("the temperature hasn't changed since %s", d.changed.Format(time.Kitchen))
+	}
+
+	// a jump is only a fault until the readings agree with each other again
+	if !last.Time.IsZero() {
+		minutes := math.Max(1, r.Time.Sub(last.Time).Minutes())
+		if jump := math.Abs(r.Temperature - last.Temperature); jump > d.maxJump*minutes {
+			d.jumped, d.agreed = fmt.Sprintf("the temperature jumped %.1f degrees", jump), 0
+			return d.jumped
+		}
+	}
+	if d.jumped != "" {
+		if d.agreed++; d.agreed < jumpSettle {
+			return d.jumped
+		}
+		d.jumped = ""
+	}
+
+	return ""
+}
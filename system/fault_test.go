@@ -0,0 +1,192 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"thermostat/clock"
+	"thermostat/db/reading"
+	"thermostat/db/zone"
+	"thermostat/sensor"
+	"time"
+)
+
+func testFaultDetector() faultDetector {
+	return faultDetector{maxAge: 5 * time.Minute, stuck: 6 * time.Hour, min: 32, max: 120, maxJump: 5}
+}
+
+func TestFaultDetector(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	reading := func(temp float64, age time.Duration) sensor.Reading {
+		return sensor.Reading{Temperature: temp, Time: now.Add(-age)}
+	}
+
+	tests := []struct {
+		name    string
+		last    sensor.Reading
+		reading sensor.Reading
+		err     error
+		fault   bool
+	}{
+		{"good", sensor.Reading{}, reading(70, 0), nil, false},
+		{"failed", sensor.Reading{}, reading(70, 0), errors.New("i2c"), true},
+		{"stale", sensor.Reading{}, reading(70, 10*time.Minute), nil, true},
+		{"too cold", sensor.Reading{}, reading(-40, 0), nil, true},
+		{"too hot", sensor.Reading{}, reading(257, 0), nil, true},
+		{"small change", reading(70, time.Minute), reading(71, 0), nil, false},
+		{"jump", reading(70, time.Minute), reading(90, 0), nil, true},
+		{"slow change over a long time", reading(70, 30*time.Minute), reading(90, 0), nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := testFaultDetector()
+			d.last = tt.last
+			assert.Equal(t, tt.fault, d.check(now, tt.reading, tt.err) != "")
+		})
+	}
+}
+
+func TestFaultDetector_Stuck(t *testing.T) {
+	t.Parallel()
+
+	d := testFaultDetector()
+	now := time.Now()
+	for i := 0; i < 6*60; i++ {
+		at := now.Add(time.Duration(i) * time.Minute)
+		require.Empty(t, d.check(at, sensor.Reading{Temperature: 70, Time: at}, nil))
+	}
+	at := now.Add(6 * time.Hour)
+	assert.NotEmpty(t, d.check(at, sensor.Reading{Temperature: 70, Time: at}, nil))
+	at = at.Add(time.Minute)
+	assert.Empty(t, d.check(at, sensor.Reading{Temperature: 70.1, Time: at}, nil))
+}
+
+type failingSensor struct {
+	err error
+}
+
+func (s *failingSensor) Temperature() float64 {
+	return 70
+}
+
+func (s *failingSensor) Humidity() float64 {
+	return 40
+}
+
+func (s *failingSensor) Read() (sensor.Reading, error) {
+	return sensor.Reading{Temperature: 70, Humidity: 40, Time: time.Now()}, s.err
+}
+
+func TestZone_Read(t *testing.T) {
+	t.Parallel()
+
+	s := &failingSensor{err: errors.New("i2c")}
+	z := &Zone{sensor: s, faults: testFaultDetector()}
+	now := time.Now()
+
+	z.read(context.TODO(), now)
+	require.NotNil(t, z.Fault())
+	assert.Contains(t, z.Fault().Reason, "i2c")
+
+	// the fault keeps when it started
+	z.read(context.TODO(), now.Add(time.Minute))
+	assert.Equal(t, now, z.Fault().Since)
+
+	s.err = nil
+	temp, hum, measured := z.read(context.TODO(), time.Now())
+	assert.Nil(t, z.Fault())
+	assert.Equal(t, 70.0, temp)
+	assert.Equal(t, 40.0, hum)
+	assert.True(t, measured)
+}
+
+func TestZone_Failsafe(t *testing.T) {
+	hour := time.Date(2020, 1, 1, 3, 0, 0, 0, time.Local)
+	defer viper.Set("sensor.failsafe", "off")
+
+	c := testHVAC(nil, clock.New())
+	z := &Zone{controller: c}
+	on(t)(c.SetHeat(true))
+	z.failsafe(hour, 0, false, make(map[string]string))
+	assert.False(t, c.Heat())
+
+	viper.Set("sensor.failsafe", "freeze")
+	reasons := make(map[string]string)
+	z.failsafe(hour.Add(time.Minute), 0, false, reasons)
+	assert.True(t, c.Heat())
+	assert.Contains(t, reasons, "heat")
+	z.failsafe(hour.Add(30*time.Minute), 0, false, make(map[string]string))
+	assert.False(t, c.Heat())
+}
+
+func TestZone_FailsafeAboveFreezing(t *testing.T) {
+	hour := time.Date(2020, 1, 1, 4, 0, 0, 0, time.Local)
+	viper.Set("sensor.failsafe", "freeze")
+	defer viper.Set("sensor.failsafe", "off")
+
+	c := testHVAC(nil, clock.New())
+	z := &Zone{controller: c}
+	reasons := make(map[string]string)
+	z.failsafe(hour, 40, true, reasons)
+	assert.True(t, c.Heat(), "a suspect reading below freezeTemp")
+	assert.Contains(t, reasons, "heat")
+
+	reasons = make(map[string]string)
+	z.failsafe(hour.Add(time.Minute), 62, true, reasons)
+	assert.False(t, c.Heat(), "the suspect reading is back above freezeTemp")
+	assert.NotContains(t, reasons, "heat")
+}
+
+func TestFaultDetector_Jump(t *testing.T) {
+	t.Parallel()
+
+	d := testFaultDetector()
+	now := time.Now()
+	at := func(i int) time.Time {
+		return now.Add(time.Duration(i) * time.Minute)
+	}
+	require.Empty(t, d.check(at(0), sensor.Reading{Temperature: 70, Time: at(0)}, nil))
+	assert.Equal(t, "the temperature jumped 20.0 degrees", d.check(at(1), sensor.Reading{Temperature: 90, Time: at(1)}, nil))
+	// it takes three readings agreeing with each other to trust the sensor again
+	assert.NotEmpty(t, d.check(at(2), sensor.Reading{Temperature: 90.1, Time: at(2)}, nil))
+	assert.NotEmpty(t, d.check(at(3), sensor.Reading{Temperature: 90, Time: at(3)}, nil))
+	assert.Empty(t, d.check(at(4), sensor.Reading{Temperature: 90.1, Time: at(4)}, nil))
+}
+
+func TestZone_ReadRecords(t *testing.T) {
+	t.Parallel()
+	ctx := context.TODO()
+
+	zn, err := zone.New(ctx, t.Name())
+	require.NoError(t, err)
+	c, err := sensor.NewComposite(sensor.Mean, clock.New())
+	require.NoError(t, err)
+	c.Add("office", &failingSensor{}, 1)
+	c.Add("bedroom", &failingSensor{err: errors.New("i2c")}, 1)
+	z := &Zone{zoneID: zn.ID, sensor: c, faults: testFaultDetector()}
+	now := time.Now()
+
+	z.read(ctx, now)
+	readings, err := reading.Between(ctx, zn.ID, now.Add(-time.Minute), now.Add(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, readings, 3)
+	assert.Equal(t, "office", readings[0].Sensor)
+	assert.True(t, readings[0].Used)
+	assert.Equal(t, "bedroom", readings[1].Sensor)
+	assert.Contains(t, readings[1].Error, "i2c")
+	assert.Equal(t, "", readings[2].Sensor, "the combined reading comes last")
+	assert.Equal(t, 70.0, readings[2].Temperature)
+	assert.True(t, readings[2].Used)
+
+	// readings from more than sensor.history days ago are forgotten
+	z.read(ctx, now.AddDate(0, 0, viper.GetInt("sensor.history")+1))
+	readings, err = reading.Between(ctx, zn.ID, now.Add(-time.Minute), now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Empty(t, readings)
+}
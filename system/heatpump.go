@@ -0,0 +1,429 @@
+package system
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"sync"
+	"thermostat/clock"
+	"thermostat/relay"
+	"time"
+)
+
+// EmergencyHeater is implemented by controllers that can heat using only auxiliary heat
+type EmergencyHeater interface {
+	EmergencyHeat() bool
+	SetEmergencyHeat(on bool) (bool, error)
+}
+
+type heatPump struct {
+	cooling   bool
+	heating   bool
+	emergency bool
+
+	fan         blower
+	compressor  stage
+	compressor2 stage
+	valve       stage // O/B reversing valve
+	aux         stage
+
+	energizeOnCool bool // true for an O valve, false for a B valve
+	balancePoint   float64
+	outdoor        Outdoor
+
+	cool timing // compressor timing while cooling
+	heat timing // compressor timing while heating, or aux timing in emergency heat
+
+	accessories
+	queue
+	clock clock.Clock
+	mutex sync.Mutex
+}
+
+// NewHeatPump returns a new heat pump controller using the given fan, compressor, reversing valve, and auxiliary heat
+// relays. The second stage compressor relay is optional, and may be nil if it is not installed.
+// outdoor may be nil, in which case auxiliary heat is never locked out. Protection timers are run by clk.
+func NewHeatPump(fan, compressor, compressor2, reversingValve, aux relay.Relay, outdoor Outdoor, acc Accessories, clk clock.Clock) (*heatPump, error) {
+	cont := &heatPump{
+		fan:            blower{stage: stage{name: "fan", relay: fan}, clock: clk},
+		compressor:     stage{name: "compressor", relay: compressor},
+		compressor2:    stage{name: "compressor2", relay: compressor2, timing: loadTiming("cool.stage2")},
+		valve:          stage{name: "reversingValve", relay: reversingValve},
+		aux:            stage{name: "aux", relay: aux, timing: loadTiming("heat.stage2")},
+		energizeOnCool: viper.GetString("heatPump.reversingValve") != "B",
+		balancePoint:   viper.GetFloat64("heatPump.balancePoint"),
+		outdoor:        outdoor,
+		cool:           loadTiming("cool"),
+		heat:           loadTiming("heat"),
+		accessories:    newAccessories(acc, clk),
+		queue:          queue{clock: clk},
+		clock:          clk,
+	}
+
+	if err := cont.Reset(); err != nil {
+		return nil, err
+	}
+
+	return cont, nil
+}
+
+func (c *heatPump) Reset() error {
+	c.clear()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	firstErr := c.fan.reset()
+	if firstErr != nil {
+		logrus.WithField("relay", c.fan.relay.String()).WithError(firstErr).Error("failed to reset relay")
+	}
+	for _, s := range []*stage{&c.compressor, &c.compressor2, &c.valve, &c.aux} {
+		if err := s.reset(); err != nil {
+			logrus.WithField("relay", s.relay.String()).WithError(err).Error("failed to reset relay")
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if err := c.accessories.reset(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	c.cooling = false
+	c.heating = false
+	return firstErr
+}
+
+func (c *heatPump) Fan() bool {
+	return c.fan.running()
+}
+
+func (c *heatPump) AC() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.cooling
+}
+
+func (c *heatPump) AC2() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.ac2()
+}
+
+// ac2 reports if the second stage is cooling. Callers must hold the mutex.
+func (c *heatPump) ac2() bool {
+	return c.cooling && c.compressor2.on
+}
+
+func (c *heatPump) Heat() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.heating
+}
+
+// Heat2 is the auxiliary heat
+func (c *heatPump) Heat2() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.heat2()
+}
+
+// heat2 reports if the auxiliary heat is running. Callers must hold the mutex.
+func (c *heatPump) heat2() bool {
+	return c.heating && c.aux.on
+}
+
+func (c *heatPump) EmergencyHeat() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.emergency
+}
+
+func (c *heatPump) Outputs() map[string]bool {
+	fan := c.fan.running() // the fan has its own lock, so read it without holding the controller's
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.outputs(map[string]bool{
+		"fan":            fan,
+		"compressor":     c.compressor.on,
+		"compressor2":    c.compressor2.on,
+		"reversingValve": c.valve.on,
+		"aux":            c.aux.on,
+		"emergency":      c.emergency,
+	})
+}
+
+// SetFan calls for the fan to run on its own. The fan always runs while heat or AC runs.
+func (c *heatPump) SetFan(on bool) (bool, error) {
+	return c.fan.setCall(on)
+}
+
+// followFan starts or stops the fan after the fan lead or overrun time of t
+func (c *heatPump) followFan(t timing, on bool) {
+	delay := t.fanOverrun
+	if on {
+		delay = t.fanLead
+	}
+
+	// the controller's lock is taken before the fan's, as everywhere else
+	c.clock.AfterFunc(delay, func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		if (c.cooling || c.heating) != on { // heating or cooling switched again before the fan caught up
+			return
+		}
+		c.fan.setFollow(on)
+	})
+}
+
+// SetAC switches cooling, queueing the switch if a protection timer defers it
+func (c *heatPump) SetAC(on bool) (bool, error) {
+	if !on {
+		c.cancel("ac2")
+	}
+	return c.run("ac", on, c.setAC)
+}
+
+func (c *heatPump) setAC(on bool) (bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if on == c.cooling {
+		return on, nil
+	}
+	if c.heating {
+		logrus.Error("Illegal attempt to engage AC while heat is on")
+		return c.cooling, nil
+	}
+
+	now := c.clock.Now()
+	c.compressor.timing = c.cool
+	if err := c.compressor.check(now, on); err != nil {
+		return c.cooling, err
+	}
+	// the second stage can't run on its own
+	if !on {
+		if off, err := c.compressor2.trySet(now, false); !off {
+			return c.cooling, err
+		}
+	}
+
+	logrus.WithField("on", on).Info("toggling AC")
+
+	if on {
+		if err := c.setValve(now, true); err != nil {
+			return c.cooling, err
+		}
+	}
+	if err := c.compressor.set(now, on); err != nil {
+		return c.cooling, err
+	}
+	c.followFan(c.cool, on)
+
+	c.cooling = on
+	return c.cooling, nil
+}
+
+// SetAC2 switches the second stage compressor while cooling, queueing the switch if a protection timer defers it
+func (c *heatPump) SetAC2(on bool) (bool, error) {
+	return c.run("ac2", on, c.setAC2)
+}
+
+func (c *heatPump) setAC2(on bool) (bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if on == c.ac2() {
+		return on, nil
+	}
+	if on && !c.cooling {
+		logrus.Error("Illegal attempt to engage second stage AC while the first stage is off")
+		return c.ac2(), nil
+	}
+
+	_, err := c.compressor2.trySet(c.clock.Now(), on)
+	return c.ac2(), err
+}
+
+// SetHeat switches heating, queueing the switch if a protection timer defers it
+func (c *heatPump) SetHeat(on bool) (bool, error) {
+	if !on {
+		c.cancel("heat2")
+	}
+	return c.run("heat", on, c.setHeat)
+}
+
+func (c *heatPump) setHeat(on bool) (bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if on == c.heating {
+		return on, nil
+	}
+	if c.cooling {
+		logrus.Error("Illegal attempt to engage heat while AC is on")
+		return c.heating, nil
+	}
+
+	now := c.clock.Now()
+	logrus.WithFields(logrus.Fields{
+		"on":        on,
+		"emergency": c.emergency,
+	}).Info("toggling heat")
+
+	if c.emergency {
+		// auxiliary heat is the only heat source, so it gets first stage timing
+		aux := c.aux
+		aux.timing = c.heat
+		if err := aux.check(now, on); err != nil {
+			return c.heating, err
+		}
+		if err := c.aux.set(now, on); err != nil {
+			return c.heating, err
+		}
+	} else {
+		c.compressor.timing = c.heat
+		if err := c.compressor.check(now, on); err != nil {
+			return c.heating, err
+		}
+		// the second stage can't run on its own
+		if !on {
+			if off, err := c.aux.trySet(now, false); !off {
+				return c.heating, err
+			}
+		}
+		if on {
+			if err := c.setValve(now, false); err != nil {
+				return c.heating, err
+			}
+		}
+		if err := c.compressor.set(now, on); err != nil {
+			return c.heating, err
+		}
+	}
+	c.followFan(c.heat, on)
+
+	c.heating = on
+	return c.heating, nil
+}
+
+// SetHeat2 engages auxiliary heat to supplement the compressor, queueing the switch if a protection timer defers it.
+// Auxiliary heat is locked out when it is warmer outside than the balance point.
+func (c *heatPump) SetHeat2(on bool) (bool, error) {
+	return c.run("heat2", on, c.setHeat2)
+}
+
+func (c *heatPump) setHeat2(on bool) (bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if on == c.heat2() {
+		return on, nil
+	}
+	if on && !c.heating {
+		logrus.Error("Illegal attempt to engage auxiliary heat while the first stage is off")
+		return c.heat2(), nil
+	}
+	if c.emergency {
+		// auxiliary heat is already the first stage
+		return c.heat2(), nil
+	}
+	if on && c.auxLockedOut() {
+		return c.heat2(), nil
+	}
+
+	_, err := c.aux.trySet(c.clock.Now(), on)
+	return c.heat2(), err
+}
+
+// SetEmergencyHeat switches between the compressor and auxiliary heat. If heat is already running, the heat source is
+// changed over immediately, unless the protection timers of either heat source defer it. Deferred changeovers are
+// queued until the timer expires.
+func (c *heatPump) SetEmergencyHeat(on bool) (bool, error) {
+	return c.run("emergency", on, c.setEmergencyHeat)
+}
+
+func (c *heatPump) setEmergencyHeat(on bool) (bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if on == c.emergency {
+		return on, nil
+	}
+
+	logrus.WithField("on", on).Info("toggling emergency heat")
+
+	now := c.clock.Now()
+	if c.heating {
+		compressor := c.compressor
+		compressor.timing = c.heat
+		aux := c.aux
+		aux.timing = c.heat
+		if err := compressor.check(now, !on); err != nil {
+			return c.emergency, err
+		}
+		if err := aux.check(now, on); err != nil {
+			return c.emergency, err
+		}
+
+		var err error
+		if on {
+			if err = c.compressor.set(now, false); err == nil {
+				err = c.aux.set(now, true)
+			}
+		} else {
+			if err = c.aux.set(now, false); err == nil {
+				if err = c.setValve(now, false); err == nil {
+					err = c.compressor.set(now, true)
+				}
+			}
+		}
+		if err != nil {
+			return c.emergency, err
+		}
+	}
+
+	c.emergency = on
+	return c.emergency, nil
+}
+
+func (c *heatPump) auxLockedOut() bool {
+	if c.outdoor == nil {
+		return false
+	}
+
+	temp, err := c.outdoor.Outdoor()
+	if err != nil {
+		return false // better to run aux heat needlessly than to leave the house cold
+	}
+	if temp > c.balancePoint {
+		logrus.WithFields(logrus.Fields{
+			"outdoor":      temp,
+			"balancePoint": c.balancePoint,
+		}).Info("auxiliary heat is locked out above the balance point")
+		return true
+	}
+	return false
+}
+
+// setValve positions the reversing valve for cooling or heating. Callers must hold the mutex.
+func (c *heatPump) setValve(now time.Time, cool bool) error {
+	energize := cool == c.energizeOnCool
+	if c.valve.on == energize {
+		return nil
+	}
+	return c.valve.set(now, energize)
+}
+
+func (c *heatPump) Test() {
+	c.SetFan(true)
+	time.Sleep(time.Second * 5)
+	c.SetAC(true)
+	time.Sleep(c.cool.minOn + time.Second)
+	c.SetAC(false)
+	time.Sleep(c.cool.fanOverrun + time.Second)
+	c.SetHeat(true)
+	time.Sleep(c.aux.timing.minOff + time.Second)
+	c.SetHeat2(true)
+	time.Sleep(c.heat.minOn + time.Second)
+	c.SetHeat(false)
+	time.Sleep(c.heat.fanOverrun + time.Second)
+	c.SetFan(false)
+}
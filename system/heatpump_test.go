@@ -0,0 +1,107 @@
+package system
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"thermostat/clock"
+	"thermostat/relay"
+	"time"
+)
+
+func testHeatPump(energizeOnCool bool, outdoor Outdoor) *heatPump {
+	clk := clock.New()
+	return &heatPump{
+		fan:            blower{stage: stage{name: "fan", relay: relay.NewFake("fan", nil)}, clock: clk},
+		compressor:     stage{name: "compressor", relay: relay.NewFake("compressor", nil)},
+		valve:          stage{name: "reversingValve", relay: relay.NewFake("valve", nil)},
+		aux:            stage{name: "aux", relay: relay.NewFake("aux", nil)},
+		energizeOnCool: energizeOnCool,
+		balancePoint:   40,
+		outdoor:        outdoor,
+		accessories:    newAccessories(Accessories{}, clk),
+		queue:          queue{clock: clk},
+		clock:          clk,
+	}
+}
+
+func TestHeatPump_ReversingValve(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		energizeOnCool bool
+		cool           bool
+		expected       bool
+	}{
+		{"O cooling", true, true, true},
+		{"O heating", true, false, false},
+		{"B cooling", false, true, false},
+		{"B heating", false, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testHeatPump(tt.energizeOnCool, nil)
+			if tt.cool {
+				on(t)(c.SetAC(true))
+			} else {
+				on(t)(c.SetHeat(true))
+			}
+			assert.Equal(t, tt.expected, c.valve.relay.(*relay.Fake).On())
+			assert.True(t, c.compressor.relay.(*relay.Fake).On())
+			assert.False(t, c.aux.relay.(*relay.Fake).On())
+		})
+	}
+}
+
+func TestHeatPump_AuxLockout(t *testing.T) {
+	t.Parallel()
+
+	c := testHeatPump(true, StaticOutdoor(50))
+	on(t)(c.SetHeat(true))
+	off(t)(c.SetHeat2(true)) // aux heat should be locked out above the balance point
+
+	c = testHeatPump(true, StaticOutdoor(20))
+	on(t)(c.SetHeat(true))
+	on(t)(c.SetHeat2(true))
+	assert.True(t, c.Outputs()["aux"])
+
+	// without the outdoor temperature, aux heat is allowed
+	c = testHeatPump(true, &countingOutdoor{temps: []float64{50}, err: errors.New("timed out")})
+	on(t)(c.SetHeat(true))
+	on(t)(c.SetHeat2(true))
+	assert.True(t, c.Outputs()["aux"])
+}
+
+func TestHeatPump_EmergencyHeat(t *testing.T) {
+	t.Parallel()
+
+	c := testHeatPump(true, StaticOutdoor(50))
+	on(t)(c.SetHeat(true))
+	on(t)(c.SetEmergencyHeat(true))
+	assert.True(t, c.Heat())
+	assert.False(t, c.compressor.relay.(*relay.Fake).On())
+	assert.True(t, c.aux.relay.(*relay.Fake).On(), "emergency heat ignores the balance point")
+
+	off(t)(c.SetHeat(false))
+	assert.False(t, c.aux.relay.(*relay.Fake).On())
+}
+
+func TestHeatPump_EmergencyHeatDeferred(t *testing.T) {
+	t.Parallel()
+
+	c := testHeatPump(true, StaticOutdoor(50))
+	c.heat.minOn = time.Hour
+	on(t)(c.SetHeat(true))
+
+	state, err := c.SetEmergencyHeat(true) // the compressor must finish its minimum on time
+	assert.False(t, state)
+	assert.True(t, c.compressor.relay.(*relay.Fake).On())
+	assert.False(t, c.aux.relay.(*relay.Fake).On())
+	var d *Deferred
+	require.True(t, errors.As(err, &d))
+	assert.Equal(t, "compressor", d.Output)
+	assert.Equal(t, "minOn", d.Timer)
+}
@@ -0,0 +1,110 @@
+package system
+
+import (
+	"github.com/sirupsen/logrus"
+	"sync"
+	"thermostat/clock"
+	"thermostat/relay"
+)
+
+// Humidity is implemented by controllers that can drive humidity equipment. Outputs that are not installed never turn on.
+type Humidity interface {
+	Humidifier() bool
+	SetHumidifier(on bool) (bool, error)
+	Dehumidifier() bool
+	SetDehumidifier(on bool) (bool, error)
+	FanLow() bool
+	SetFanLow(on bool) (bool, error) // runs the fan at low speed, which removes more moisture while cooling
+}
+
+// Accessories are optional relays a controller may drive in addition to heating and cooling. Any of them may be nil.
+type Accessories struct {
+	Humidifier   relay.Relay
+	Dehumidifier relay.Relay
+	FanLow       relay.Relay
+}
+
+// accessories implements Humidity
+type accessories struct {
+	humidifier   stage
+	dehumidifier stage
+	fanLow       stage
+	clock        clock.Clock
+	mutex        sync.Mutex
+}
+
+func newAccessories(a Accessories, clk clock.Clock) accessories {
+	return accessories{
+		clock:        clk,
+		humidifier:   stage{name: "humidifier", relay: a.Humidifier},
+		dehumidifier: stage{name: "dehumidifier", relay: a.Dehumidifier},
+		fanLow:       stage{name: "fanLow", relay: a.FanLow},
+	}
+}
+
+func (a *accessories) Humidifier() bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.humidifier.on
+}
+
+func (a *accessories) SetHumidifier(on bool) (bool, error) {
+	return a.set(&a.humidifier, on)
+}
+
+func (a *accessories) Dehumidifier() bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.dehumidifier.on
+}
+
+func (a *accessories) SetDehumidifier(on bool) (bool, error) {
+	return a.set(&a.dehumidifier, on)
+}
+
+func (a *accessories) FanLow() bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.fanLow.on
+}
+
+func (a *accessories) SetFanLow(on bool) (bool, error) {
+	return a.set(&a.fanLow, on)
+}
+
+func (a *accessories) set(s *stage, on bool) (bool, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	_, err := s.trySet(a.clock.Now(), on)
+	return s.on, err
+}
+
+// outputs adds the state of each installed accessory to outputs
+func (a *accessories) outputs(outputs map[string]bool) map[string]bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for _, s := range []*stage{&a.humidifier, &a.dehumidifier, &a.fanLow} {
+		if s.installed() {
+			outputs[s.name] = s.on
+		}
+	}
+	return outputs
+}
+
+func (a *accessories) reset() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	var firstErr error
+	for _, s := range []*stage{&a.humidifier, &a.dehumidifier, &a.fanLow} {
+		if err := s.reset(); err != nil {
+			logrus.WithField("relay", s.relay.String()).WithError(err).Error("failed to reset relay")
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
@@ -1,27 +1,21 @@
 package system
 
 import (
+	"sort"
 	"thermostat/db/setting"
 	"time"
 )
 
-func currentSetting(schedules []setting.Setting) setting.Setting {
-	now := time.Now().Round(time.Second) // rounding this lets me prevent instantaneous schedule overlap, while also preventing brief gaps in scheduling
+// currentSetting returns the highest priority of schedules active at now
+func currentSetting(schedules []setting.Setting, now time.Time) setting.Setting {
+	now = now.Round(time.Second) // rounding this lets me prevent instantaneous schedule overlap, while also preventing brief gaps in scheduling
 	var current setting.Setting
 	for _, s := range schedules {
 		if s.Priority < current.Priority {
 			continue
 		}
 
-		if s.DayOfWeek&setting.WeekdayMask(now.Weekday()) == 0 {
-			continue
-		}
-		if s.StartDay.After(now) || s.EndDay.Before(now) {
-			continue
-		}
-
-		sec := daySeconds(now)
-		if sec < s.StartTime || sec > s.EndTime {
+		if !s.Active(now) {
 			continue
 		}
 
@@ -31,7 +25,56 @@ func currentSetting(schedules []setting.Setting) setting.Setting {
 	return current
 }
 
-func daySeconds(t time.Time) int {
-	hour, min, sec := t.Clock()
-	return hour*60*60 + min*60 + sec
+// Interval is a stretch of time where the same setting is in effect, shadowing the same lower priority settings
+type Interval struct {
+	Start   time.Time
+	End     time.Time
+	Setting setting.Setting   // the zero setting if none is active
+	Shadows []setting.Setting // settings that are active, but overridden by Setting
+}
+
+// Timeline resolves schedules into the intervals from from to to, in order, the way the zone will run them
+func Timeline(schedules []setting.Setting, from, to time.Time) []Interval {
+	changes := []time.Time{from}
+	for _, s := range schedules {
+		changes = append(changes, s.Changes(from, to)...)
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Before(changes[j])
+	})
+
+	var intervals []Interval
+	for _, t := range changes {
+		current := currentSetting(schedules, t)
+		var shadows []setting.Setting
+		for _, s := range schedules {
+			if s.ID != current.ID && s.Active(t.Round(time.Second)) {
+				shadows = append(shadows, s)
+			}
+		}
+
+		if last := len(intervals) - 1; last >= 0 {
+			if intervals[last].Setting.ID == current.ID && sameSettings(intervals[last].Shadows, shadows) {
+				continue
+			}
+			intervals[last].End = t
+		}
+		intervals = append(intervals, Interval{Start: t, Setting: current, Shadows: shadows})
+	}
+	intervals[len(intervals)-1].End = to
+
+	return intervals
+}
+
+// sameSettings reports if a and b list the same settings, in the same order
+func sameSettings(a, b []setting.Setting) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ID != b[i].ID {
+			return false
+		}
+	}
+	return true
 }
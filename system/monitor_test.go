@@ -16,16 +16,16 @@ import (
 func TestCurrentSetting(t *testing.T) {
 	t.Parallel()
 	ctx := context.TODO()
-	now := time.Now()
+	now := time.Date(2020, 1, 7, 12, 0, 0, 0, time.Local)
 
 	z, err := zone.New(ctx, t.Name())
 	require.NoError(t, err)
 
-	m1, err := mode.New(ctx, z.ID, "test1", 60, 85, 2)
+	m1, err := mode.New(ctx, z.ID, "test1", 60, 85, 2, mode.FanAuto, 0, 0, 0, 0)
 	require.NoError(t, err)
-	m2, err := mode.New(ctx, z.ID, "test2", 65, 85, 2)
+	m2, err := mode.New(ctx, z.ID, "test2", 65, 85, 2, mode.FanAuto, 0, 0, 0, 0)
 	require.NoError(t, err)
-	m3, err := mode.New(ctx, z.ID, "test3", 70, 80, 1)
+	m3, err := mode.New(ctx, z.ID, "test3", 70, 80, 1, mode.FanAuto, 0, 0, 0, 0)
 	require.NoError(t, err)
 
 	defaultSetting, err := setting.New(ctx, z.ID, m1.ID, setting.DEFAULT, 255, time.Unix(0, 0), time.Unix(7258118400, 0), 0, 86400)
@@ -78,7 +78,7 @@ func TestCurrentSetting(t *testing.T) {
 				settings = append(settings, s)
 			}
 
-			current := currentSetting(settings)
+			current := currentSetting(settings, now)
 			m := current.Mode(ctx)
 			assert.Equal(t, tt.expected.MinTemp, m.MinTemp)
 			assert.Equal(t, tt.expected.MaxTemp, m.MaxTemp)
@@ -86,3 +86,89 @@ func TestCurrentSetting(t *testing.T) {
 		})
 	}
 }
+
+func TestCurrentSetting_Wrap(t *testing.T) {
+	t.Parallel()
+
+	saturday := time.Date(2020, 1, 4, 0, 0, 0, 0, time.Local)
+	def := setting.Setting{ID: 1, Priority: setting.DEFAULT, DayOfWeek: 255, StartDay: time.Unix(0, 0), EndDay: time.Unix(7258118400, 0), StartTime: 0, EndTime: 86400}
+	night := setting.Setting{ID: 2, Priority: setting.SCHEDULED, DayOfWeek: setting.WeekdayMask(time.Saturday), StartDay: def.StartDay, EndDay: def.EndDay, StartTime: 22 * 60 * 60, EndTime: 6 * 60 * 60}
+	schedules := []setting.Setting{def, night}
+
+	tests := []struct {
+		name     string
+		now      time.Time
+		expected int64
+	}{
+		{"saturday morning", saturday.Add(3 * time.Hour), def.ID},
+		{"saturday night", saturday.Add(23 * time.Hour), night.ID},
+		{"midnight", saturday.AddDate(0, 0, 1), night.ID},
+		{"sunday morning", saturday.AddDate(0, 0, 1).Add(3 * time.Hour), night.ID},
+		{"sunday after end", saturday.AddDate(0, 0, 1).Add(7 * time.Hour), def.ID},
+		{"sunday night", saturday.AddDate(0, 0, 1).Add(23 * time.Hour), def.ID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, currentSetting(schedules, tt.now).ID)
+		})
+	}
+}
+
+func daySeconds(t time.Time) int {
+	hour, min, sec := t.Clock()
+	return hour*60*60 + min*60 + sec
+}
+
+func TestTimeline(t *testing.T) {
+	t.Parallel()
+
+	loc, err := time.LoadLocation("America/Chicago")
+	require.NoError(t, err)
+	monday := time.Date(2020, 1, 6, 0, 0, 0, 0, loc)
+	at := func(day, hour, sec int) time.Time {
+		return monday.AddDate(0, 0, day).Add(time.Duration(hour)*time.Hour + time.Duration(sec)*time.Second)
+	}
+
+	weekdays := 0
+	for d := time.Monday; d <= time.Friday; d++ {
+		weekdays |= setting.WeekdayMask(d)
+	}
+	def := setting.Setting{ID: 1, Priority: setting.DEFAULT, DayOfWeek: 255, StartDay: time.Unix(0, 0), EndDay: time.Unix(7258118400, 0), StartTime: 0, EndTime: 86400}
+	work := setting.Setting{ID: 2, Priority: setting.SCHEDULED, DayOfWeek: weekdays, StartDay: def.StartDay, EndDay: def.EndDay, StartTime: 9 * 3600, EndTime: 17 * 3600}
+	vacation := setting.Setting{ID: 3, Priority: setting.OVERRIDE, DayOfWeek: 255, StartDay: at(1, 12, 0), EndDay: def.EndDay, StartTime: 0, EndTime: 86400}
+
+	expected := []struct {
+		start, end time.Time
+		setting    int64
+		shadows    []int64
+	}{
+		{at(0, 0, 0), at(0, 9, 0), def.ID, nil},
+		{at(0, 9, 0), at(0, 17, 1), work.ID, []int64{def.ID}},
+		{at(0, 17, 1), at(1, 9, 0), def.ID, nil},
+		{at(1, 9, 0), at(1, 12, 0), work.ID, []int64{def.ID}},
+		{at(1, 12, 0), at(1, 17, 1), vacation.ID, []int64{def.ID, work.ID}},
+		{at(1, 17, 1), at(2, 0, 0), vacation.ID, []int64{def.ID}},
+	}
+
+	intervals := Timeline([]setting.Setting{def, work, vacation}, monday, at(2, 0, 0))
+	require.Len(t, intervals, len(expected))
+	for i, e := range expected {
+		assert.True(t, e.start.Equal(intervals[i].Start), "interval %d starts at %s", i, intervals[i].Start)
+		assert.True(t, e.end.Equal(intervals[i].End), "interval %d ends at %s", i, intervals[i].End)
+		assert.Equal(t, e.setting, intervals[i].Setting.ID, "interval %d", i)
+		var shadows []int64
+		for _, s := range intervals[i].Shadows {
+			shadows = append(shadows, s.ID)
+		}
+		assert.Equal(t, e.shadows, shadows, "interval %d", i)
+	}
+
+	// a setting that wraps past midnight
+	night := setting.Setting{ID: 4, Priority: setting.SCHEDULED, DayOfWeek: setting.WeekdayMask(time.Saturday), StartDay: def.StartDay, EndDay: def.EndDay, StartTime: 22 * 3600, EndTime: 6 * 3600}
+	intervals = Timeline([]setting.Setting{def, night}, at(5, 12, 0), at(6, 12, 0))
+	require.Len(t, intervals, 3)
+	assert.Equal(t, night.ID, intervals[1].Setting.ID)
+	assert.True(t, at(5, 22, 0).Equal(intervals[1].Start))
+	assert.True(t, at(6, 6, 1).Equal(intervals[1].End))
+}
@@ -0,0 +1,234 @@
+package system
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"thermostat/clock"
+	"thermostat/sensor"
+	"time"
+)
+
+// Outdoor provides the outdoor temperature
+type Outdoor interface {
+	// Outdoor returns the outdoor temperature in degrees F, or an error if it isn't known
+	Outdoor() (float64, error)
+}
+
+// SensorOutdoor reads the outdoor temperature from a sensor outside. Sensors that can't tell when they were read are read
+// as of Clock's now.
+type SensorOutdoor struct {
+	Sensor
+	Clock clock.Clock
+}
+
+func (s SensorOutdoor) Outdoor() (float64, error) {
+	r, err := sensor.Read(s.Sensor, s.Clock)
+	return r.Temperature, err
+}
+
+// StaticOutdoor is a fixed outdoor temperature, for testing, or for houses without any other source
+type StaticOutdoor float64
+
+func (s StaticOutdoor) Outdoor() (float64, error) {
+	return float64(s), nil
+}
+
+// HTTPOutdoor fetches the outdoor temperature from a weather service's JSON endpoint
+type HTTPOutdoor struct {
+	url     string
+	path    []string // the fields to follow to the temperature, with array elements by index
+	celcius bool     // the endpoint reports degrees C
+	client  *http.Client
+}
+
+// NewHTTPOutdoor returns a source that fetches url and finds the temperature at path, a list of fields separated by dots
+// like current.temp_f or properties.periods.0.temperature
+func NewHTTPOutdoor(url, path string, celcius bool) (*HTTPOutdoor, error) {
+	if url == "" || path == "" {
+		return nil, errors.New("the http outdoor temperature source needs a url and a path")
+	}
+	return &HTTPOutdoor{
+		url:     url,
+		path:    strings.Split(path, "."),
+		celcius: celcius,
+		client:  &http.Client{Timeout: 5 * time.Second},
+	}, nil
+}
+
+func (h *HTTPOutdoor) Outdoor() (float64, error) {
+	resp, err := h.client.Get(h.url)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("weather endpoint returned %s", resp.Status)
+	}
+
+	var data interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return 0, fmt.Errorf("unable to parse weather data: %w", err)
+	}
+	temp, err := jsonPath(data, h.path)
+	if err != nil {
+		return 0, err
+	}
+	if h.celcius {
+		temp = sensor.FarenheitFromCelcius(temp)
+	}
+	return temp, nil
+}
+
+// jsonPath follows path through data decoded from JSON to a number
+func jsonPath(data interface{}, path []string) (float64, error) {
+	for i, field := range path {
+		switch v := data.(type) {
+		case map[string]interface{}:
+			data = v[field]
+		case []interface{}:
+			n, err := strconv.Atoi(field)
+			if err != nil || n < 0 || n >= len(v) {
+				return 0, fmt.Errorf("%s is not an index of %s", field, strings.Join(path[:i], "."))
+			}
+			data = v[n]
+		default:
+			return 0, fmt.Errorf("%s has no field %s", strings.Join(path[:i], "."), field)
+		}
+	}
+
+	temp, ok := data.(float64)
+	if !ok {
+		return 0, fmt.Errorf("%s is not a number", strings.Join(path, "."))
+	}
+	return temp, nil
+}
+
+// CachedOutdoor keeps another source's last good temperature, so zones and controllers can read it as often as they
+// like without waiting on the source. The source is asked again in the background once the temperature is ttl old, or
+// a minute after it fails.
+type CachedOutdoor struct {
+	source  Outdoor
+	ttl     time.Duration
+	clock   clock.Clock
+	temp    float64
+	fetched time.Time // when temp was fetched, or zero if it never has been
+	err     error     // why the latest attempt failed, if it did
+	tried   time.Time // when the source was last asked
+	busy    bool      // the source is being asked
+	mutex   sync.Mutex
+}
+
+// outdoorRetry is how long to wait before asking a failed source again
+const outdoorRetry = time.Minute
+
+func NewCachedOutdoor(source Outdoor, ttl time.Duration, clk clock.Clock) *CachedOutdoor {
+	return &CachedOutdoor{source: source, ttl: ttl, clock: clk}
+}
+
+// Outdoor returns the last good temperature, or an error if there isn't one or it's more than three refreshes old
+func (c *CachedOutdoor) Outdoor() (float64, error) {
+	temp, fetched, err := c.Last()
+	if err == nil && c.clock.Now().Sub(fetched) > 3*c.ttl {
+		return 0, fmt.Errorf("the outdoor temperature is out of date, from %s", fetched.Format(time.Kitchen))
+	}
+	return temp, err
+}
+
+// Last returns the last good temperature and when it was fetched, or an error if none has been. It refreshes the
+// temperature in the background if it's due.
+func (c *CachedOutdoor) Last() (float64, time.Time, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := c.clock.Now()
+	wait := c.ttl
+	if c.err != nil && outdoorRetry < wait {
+		wait = outdoorRetry
+	}
+	if !c.busy && (c.tried.IsZero() || now.Sub(c.tried) >= wait) {
+		c.busy = true
+		c.tried = now
+		go c.refresh()
+	}
+
+	if c.fetched.IsZero() {
+		err := c.err
+		if err == nil {
+			err = errors.New("the outdoor temperature isn't known yet")
+		}
+		return 0, time.Time{}, err
+	}
+	return c.temp, c.fetched, nil
+}
+
+// refresh asks the source for the temperature, keeping the last good one if it fails
+func (c *CachedOutdoor) refresh() {
+	temp, err := c.source.Outdoor()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.busy = false
+	c.err = err
+	if err != nil {
+		logrus.WithError(err).Warn("failed to get the outdoor temperature")
+		return
+	}
+	c.temp, c.fetched = temp, c.clock.Now()
+}
+
+var (
+	outdoor       *CachedOutdoor
+	outdoorErr    error
+	outdoorMutex  sync.Mutex
+	outdoorLoaded bool
+)
+
+// LoadOutdoor returns the outdoor temperature source described by outdoor.type in the config, building it the first
+// time it's needed, when it keeps time with clk. Returns nil if none is configured.
+func LoadOutdoor(clk clock.Clock) (*CachedOutdoor, error) {
+	outdoorMutex.Lock()
+	defer outdoorMutex.Unlock()
+
+	if !outdoorLoaded {
+		outdoor, outdoorErr = newOutdoor(clk)
+		outdoorLoaded = true
+	}
+	return outdoor, outdoorErr
+}
+
+func newOutdoor(clk clock.Clock) (*CachedOutdoor, error) {
+	var source Outdoor
+	switch t := viper.GetString("outdoor.type"); t {
+	case "":
+		return nil, nil
+	case "sensor":
+		s, err := newSource(context.Background(), "outdoor", viper.GetString("outdoor.sensorType"), viper.GetString("outdoor.sensor"),
+			viper.GetFloat64("outdoor.tempCorrection"), configFloat("outdoor.temperatureRangeDivider", 1), 0, clk)
+		if err == nil {
+			s, err = withFilters(s, "outdoor.filters", clk)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("outdoor sensor: %w", err)
+		}
+		source = SensorOutdoor{Sensor: s, Clock: clk}
+	case "http":
+		h, err := NewHTTPOutdoor(viper.GetString("outdoor.url"), viper.GetString("outdoor.path"), viper.GetBool("outdoor.celcius"))
+		if err != nil {
+			return nil, err
+		}
+		source = h
+	case "static":
+		source = StaticOutdoor(viper.GetFloat64("outdoor.temperature"))
+	default:
+		return nil, errors.New("unknown outdoor temperature type " + t)
+	}
+	return NewCachedOutdoor(source, time.Second*time.Duration(viper.GetInt("outdoor.cacheTime")), clk), nil
+}
@@ -0,0 +1,151 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"thermostat/clock"
+	"time"
+)
+
+type countingOutdoor struct {
+	temps []float64
+	err   error
+	calls int
+	wait  chan struct{} // if set, each call waits for a send
+	mutex sync.Mutex
+}
+
+func (c *countingOutdoor) Outdoor() (float64, error) {
+	if c.wait != nil {
+		<-c.wait
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	temp := c.temps[c.calls%len(c.temps)]
+	c.calls++
+	return temp, c.err
+}
+
+func (c *countingOutdoor) fail(err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.err = err
+}
+
+func (c *countingOutdoor) count() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.calls
+}
+
+func TestHTTPOutdoor(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/current":
+			_, _ = fmt.Fprint(w, `{"current": {"temp_f": 41.5, "humidity": 80}}`)
+		case "/forecast":
+			_, _ = fmt.Fprint(w, `{"properties": {"periods": [{"temperature": 5}, {"temperature": 7}]}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		url     string
+		path    string
+		celcius bool
+		temp    float64
+		err     string
+	}{
+		{"field", srv.URL + "/current", "current.temp_f", false, 41.5, ""},
+		{"array index in celcius", srv.URL + "/forecast", "properties.periods.1.temperature", true, 44.6, ""},
+		{"missing field", srv.URL + "/current", "current.dewpoint", false, 0, "current.dewpoint is not a number"},
+		{"bad index", srv.URL + "/forecast", "properties.periods.2.temperature", false, 0, "2 is not an index of properties.periods"},
+		{"not an object", srv.URL + "/current", "current.temp_f.value", false, 0, "current.temp_f has no field value"},
+		{"not found", srv.URL + "/missing", "current.temp_f", false, 0, "weather endpoint returned 404 Not Found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := NewHTTPOutdoor(tt.url, tt.path, tt.celcius)
+			require.NoError(t, err)
+			temp, err := h.Outdoor()
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.InDelta(t, tt.temp, temp, 0.0001)
+		})
+	}
+
+	_, err := NewHTTPOutdoor(srv.URL, "", false)
+	assert.Error(t, err)
+}
+
+func TestCachedOutdoor(t *testing.T) {
+	t.Parallel()
+
+	clk := clock.NewFake(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+	source := &countingOutdoor{temps: []float64{30, 35}, wait: make(chan struct{})}
+	c := NewCachedOutdoor(source, time.Minute*10, clk)
+	// refreshed lets the source answer, then waits for the cache to take the answer
+	refreshed := func() {
+		source.wait <- struct{}{}
+		require.Eventually(t, func() bool {
+			c.mutex.Lock()
+			defer c.mutex.Unlock()
+			return !c.busy
+		}, time.Second, time.Millisecond)
+	}
+
+	_, _, err := c.Last()
+	assert.EqualError(t, err, "the outdoor temperature isn't known yet", "doesn't wait on the source")
+	refreshed()
+	temp, fetched, err := c.Last()
+	require.NoError(t, err)
+	assert.Equal(t, 30.0, temp)
+	assert.Equal(t, clk.Now(), fetched)
+
+	clk.Advance(time.Minute * 9)
+	temp, _ = c.Outdoor()
+	assert.Equal(t, 30.0, temp, "cached")
+	assert.Equal(t, 1, source.count())
+
+	clk.Advance(time.Minute)
+	temp, _ = c.Outdoor()
+	assert.Equal(t, 30.0, temp, "the last good temperature while the source is asked again")
+	refreshed()
+	temp, _ = c.Outdoor()
+	assert.Equal(t, 35.0, temp)
+
+	source.fail(errors.New("timed out"))
+	clk.Advance(time.Minute * 10)
+	c.Last()
+	refreshed()
+	temp, fetched, err = c.Last()
+	require.NoError(t, err, "failures keep the last good temperature")
+	assert.Equal(t, 35.0, temp)
+	assert.Equal(t, clk.Now().Add(-time.Minute*10), fetched)
+	assert.Equal(t, 3, source.count())
+
+	clk.Advance(time.Minute)
+	c.Last()
+	refreshed()
+	assert.Equal(t, 4, source.count(), "failures are retried sooner than the cache time")
+
+	clk.Advance(time.Minute * 20)
+	_, err = c.Outdoor()
+	assert.EqualError(t, err, "the outdoor temperature is out of date, from 12:10PM")
+	refreshed()
+}
@@ -0,0 +1,64 @@
+package system
+
+import (
+	"math"
+	"time"
+)
+
+// pid is a time proportional PID strategy. Each window, it runs heat or AC for a share of the window in proportion to how
+// far the temperature is from the target. Heating targets Correction degrees above Min, and cooling Correction degrees
+// below Max. Heating drives the zone below the middle of the range, and cooling above it.
+type pid struct {
+	kp     float64 // duty per degree
+	ki     float64 // duty per degree minute
+	kd     float64 // duty per degree per minute
+	window time.Duration
+
+	loop        Demand // the loop driving the zone
+	integral    float64
+	lastErr     float64
+	last        time.Time
+	windowStart time.Time
+	duty        float64
+}
+
+func newPID(key string) *pid {
+	return &pid{
+		kp:     configFloat(key+".kp", 0.5),
+		ki:     configFloat(key+".ki", 0.01),
+		kd:     configFloat(key+".kd", 0),
+		window: time.Second * time.Duration(configFloat(key+".window", 900)),
+	}
+}
+
+func (p *pid) Demand(now time.Time, temp float64, r Range, _ Demand) Demand {
+	loop, e := Heating, r.Min+r.Correction-temp
+	if temp > (r.Min+r.Max)/2 {
+		loop, e = Cooling, temp-(r.Max-r.Correction)
+	}
+
+	var derivative float64
+	if loop != p.loop {
+		p.loop = loop
+		p.integral = 0
+		p.windowStart = time.Time{}
+	} else if dt := now.Sub(p.last).Minutes(); dt > 0 {
+		p.integral += e * dt
+		if p.ki > 0 { // keep the integral from winding up past what it can contribute
+			p.integral = math.Max(0, math.Min(p.integral, 1/p.ki))
+		}
+		derivative = (e - p.lastErr) / dt
+	}
+	p.lastErr = e
+	p.last = now
+
+	if p.windowStart.IsZero() || now.Sub(p.windowStart) >= p.window {
+		p.windowStart = now
+		p.duty = math.Max(0, math.Min(1, p.kp*e+p.ki*p.integral+p.kd*derivative))
+	}
+
+	if now.Sub(p.windowStart) < time.Duration(p.duty*float64(p.window)) {
+		return loop
+	}
+	return Idle
+}
@@ -0,0 +1,45 @@
+package system
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestPID_Duty(t *testing.T) {
+	t.Parallel()
+
+	r := Range{Min: 68, Max: 76, Correction: 1}
+	p := &pid{kp: 0.5, window: 10 * time.Minute}
+	start := time.Now()
+
+	// 1 degree below the heating target runs heat for half of each window
+	assert.Equal(t, Heating, p.Demand(start, 68, r, Idle))
+	assert.Equal(t, Heating, p.Demand(start.Add(4*time.Minute), 68, r, Heating))
+	assert.Equal(t, Idle, p.Demand(start.Add(6*time.Minute), 68, r, Heating))
+	assert.Equal(t, Heating, p.Demand(start.Add(10*time.Minute), 68, r, Idle))
+
+	// at the target, nothing runs
+	p = &pid{kp: 0.5, window: 10 * time.Minute}
+	assert.Equal(t, Idle, p.Demand(start, 69, r, Idle))
+
+	// above the middle of the range, the cooling loop takes over
+	assert.Equal(t, Cooling, p.Demand(start.Add(time.Minute), 77, r, Idle))
+	assert.Equal(t, Cooling, p.loop)
+}
+
+func TestPID_Integral(t *testing.T) {
+	t.Parallel()
+
+	r := Range{Min: 68, Max: 76, Correction: 1}
+	p := &pid{ki: 0.01, window: 10 * time.Minute}
+	start := time.Now()
+
+	// a steady error builds up duty over time, but no further than full duty
+	p.Demand(start, 68.5, r, Idle)
+	for i := 1; i <= 100; i++ {
+		p.Demand(start.Add(time.Duration(i)*10*time.Minute), 68.5, r, Idle)
+	}
+	assert.InDelta(t, 1, p.duty, 0.0001)
+	assert.InDelta(t, 100, p.integral, 0.0001)
+}
@@ -0,0 +1,96 @@
+package system
+
+import (
+	"errors"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"thermostat/clock"
+)
+
+// queued is a switch waiting on a protection timer
+type queued struct {
+	Deferred
+	timer clock.Timer
+}
+
+// queue remembers switches deferred by protection timers and retries them as soon as the timer expires.
+// A newer request for the same call replaces any queued request.
+type queue struct {
+	clock   clock.Clock
+	pending map[string]*queued
+	mutex   sync.Mutex
+}
+
+// run switches call to on using f. If a protection timer defers the switch, it is queued to run again when the timer expires.
+func (q *queue) run(call string, on bool, f func(on bool) (bool, error)) (bool, error) {
+	q.cancel(call)
+
+	state, err := f(on)
+	var d *Deferred
+	if errors.As(err, &d) {
+		q.push(call, *d, on, f)
+	}
+	return state, err
+}
+
+func (q *queue) push(call string, d Deferred, on bool, f func(on bool) (bool, error)) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.pending == nil {
+		q.pending = make(map[string]*queued)
+	}
+
+	entry := &queued{Deferred: d}
+	entry.timer = q.clock.AfterFunc(d.Until.Sub(q.clock.Now()), func() {
+		q.mutex.Lock()
+		current := q.pending[call] == entry
+		q.mutex.Unlock()
+		if !current { // replaced or cancelled while the timer fired
+			return
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"call": call,
+			"on":   on,
+		}).Info("retrying deferred switch")
+		if _, err := q.run(call, on, f); err != nil && !errors.As(err, new(*Deferred)) {
+			logrus.WithError(err).WithField("call", call).Error("failed to retry deferred switch")
+		}
+	})
+	q.pending[call] = entry
+}
+
+// cancel drops any queued request for call
+func (q *queue) cancel(call string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if entry, ok := q.pending[call]; ok {
+		entry.timer.Stop()
+		delete(q.pending, call)
+	}
+}
+
+// clear drops every queued request
+func (q *queue) clear() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for call, entry := range q.pending {
+		entry.timer.Stop()
+		delete(q.pending, call)
+	}
+}
+
+// Pending returns the switches waiting on protection timers, by call, and when they will be retried
+func (q *queue) Pending() map[string]Deferred {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	pending := make(map[string]Deferred, len(q.pending))
+	for call, entry := range q.pending {
+		pending[call] = entry.Deferred
+	}
+	return pending
+}
@@ -0,0 +1,67 @@
+package system
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"thermostat/clock"
+	"time"
+)
+
+func TestQueue_Retry(t *testing.T) {
+	t.Parallel()
+
+	clk := clock.NewFake(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+	c := testHVAC(nil, clk)
+	c.heat.timing.minOff = 120 * time.Second
+	on(t)(c.SetHeat(true))
+	off(t)(c.SetHeat(false))
+
+	state, err := c.SetHeat(true)
+	assert.False(t, state)
+	require.True(t, errors.As(err, new(*Deferred)))
+
+	pending := c.Pending()
+	require.Contains(t, pending, "heat")
+	assert.True(t, pending["heat"].On)
+	assert.Equal(t, "minOff", pending["heat"].Timer)
+
+	clk.Advance(119 * time.Second)
+	assert.False(t, c.Heat())
+	clk.Advance(time.Second)
+	assert.True(t, c.Heat(), "deferred heat should run once the minimum off time expires")
+	assert.Empty(t, c.Pending())
+}
+
+func TestQueue_Cancel(t *testing.T) {
+	t.Parallel()
+
+	clk := clock.NewFake(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+	c := testHVAC(nil, clk)
+	c.heat.timing.minOff = 120 * time.Second
+	on(t)(c.SetHeat(true))
+	off(t)(c.SetHeat(false))
+
+	_, err := c.SetHeat(true)
+	require.Error(t, err)
+	off(t)(c.SetHeat(false)) // demand went away before the timer expired
+	assert.Empty(t, c.Pending())
+
+	clk.Advance(time.Hour)
+	assert.False(t, c.Heat())
+}
+
+func TestQueue_Reset(t *testing.T) {
+	t.Parallel()
+
+	c := testHVAC(nil, clock.New())
+	c.ac.timing.minOn = time.Hour
+	on(t)(c.SetAC(true))
+	_, err := c.SetAC(false)
+	require.Error(t, err)
+	assert.Contains(t, c.Pending(), "ac")
+
+	require.NoError(t, c.Reset())
+	assert.Empty(t, c.Pending())
+}
@@ -0,0 +1,142 @@
+package system
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"math"
+	"thermostat/db/mode"
+	"thermostat/db/setting"
+	"thermostat/db/zone"
+	"time"
+)
+
+const minRecoveryRun = 10 * time.Minute // shortest run a heating or cooling rate is learned from
+
+// Recovery describes a zone starting on its next schedule early, so the schedule's setpoint is met when it begins
+type Recovery struct {
+	ScheduleID int64
+	ModeID     int64
+	Demand     string    // heating or cooling
+	Start      time.Time // when the schedule begins
+}
+
+// recovery learns how quickly a zone heats and cools from its own runs
+type recovery struct {
+	rate  float64            // how quickly new measurements replace old ones, from 0 to 1
+	rates map[Demand]float64 // degrees per hour
+
+	running  Demand
+	runStart time.Time
+	runTemp  float64
+}
+
+// loadRecovery returns the rates the zone has already learned
+func loadRecovery(ctx context.Context, zoneID int64) (recovery, error) {
+	r := recovery{
+		rate:  viper.GetFloat64("recovery.rate"),
+		rates: make(map[Demand]float64),
+	}
+
+	rates, err := zone.Rates(ctx, zoneID)
+	if err != nil {
+		return recovery{}, err
+	}
+	for _, d := range []Demand{Heating, Cooling} {
+		if rate, ok := rates[d.String()]; ok {
+			r.rates[d] = rate
+		}
+	}
+	return r, nil
+}
+
+// observe learns from heat and AC runs as they end, returning what it learned a new rate for, or Idle
+func (r *recovery) observe(now time.Time, temp float64, running Demand) Demand {
+	if running == r.running {
+		return Idle
+	}
+
+	learned := Idle
+	if runtime := now.Sub(r.runStart); r.running != Idle && runtime >= minRecoveryRun {
+		change := temp - r.runTemp
+		if r.running == Cooling {
+			change = -change
+		}
+		if change > 0 {
+			measured := change / runtime.Hours()
+			if old, ok := r.rates[r.running]; ok {
+				measured = old + r.rate*(measured-old)
+			}
+			r.rates[r.running] = measured
+			learned = r.running
+		}
+	}
+
+	r.running = running
+	r.runStart = now
+	r.runTemp = temp
+	return learned
+}
+
+// lead returns how long d should take to move the zone from temp to target, capped at maxLead.
+// Returns false if no rate has been learned for d.
+func (r *recovery) lead(d Demand, temp, target float64, maxLead time.Duration) (time.Duration, bool) {
+	rate := r.rates[d]
+	if rate <= 0 {
+		return 0, false
+	}
+
+	lead := time.Duration(math.Abs(target-temp) / rate * float64(time.Hour))
+	if lead > maxLead {
+		lead = maxLead
+	}
+	return lead, true
+}
+
+// planRecovery decides if the zone should start on its next schedule early. If so, it returns the recovery and the next
+// schedule's mode. Otherwise it returns nil and current. Once a recovery starts, it lasts until the schedule begins.
+func (z *Zone) planRecovery(ctx context.Context, schedules []setting.Setting, now time.Time, temp float64, current mode.Mode) (*Recovery, mode.Mode) {
+	maxLead := time.Second * time.Duration(viper.GetInt("recovery.maxLead"))
+	start := setting.Next(now, schedules, z.setting.Priority)
+	if maxLead <= 0 || start.IsZero() || start.Sub(now) > maxLead {
+		return nil, current
+	}
+	next := currentSetting(schedules, start)
+	if next.ID == z.setting.ID {
+		return nil, current
+	}
+	m := next.Mode(ctx)
+
+	if z.recovery != nil && z.recovery.ScheduleID == next.ID && z.recovery.Start.Equal(start) {
+		return z.recovery, m
+	}
+
+	var d Demand
+	var target float64
+	switch {
+	case m.MinTemp > current.MinTemp && temp < m.MinTemp:
+		d, target = Heating, m.MinTemp+m.Correction
+	case m.MaxTemp < current.MaxTemp && temp > m.MaxTemp:
+		d, target = Cooling, m.MaxTemp-m.Correction
+	default:
+		return nil, current
+	}
+
+	lead, ok := z.rates.lead(d, temp, target, maxLead)
+	if !ok || start.Sub(now) > lead {
+		return nil, current
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"zone":     z.zoneID,
+		"schedule": next.ID,
+		"demand":   d.String(),
+		"start":    start.String(),
+	}).Info("starting recovery")
+	return &Recovery{
+		ScheduleID: next.ID,
+		ModeID:     m.ID,
+		Demand:     d.String(),
+		Start:      start,
+	}, m
+}
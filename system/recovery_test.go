@@ -0,0 +1,116 @@
+package system
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"thermostat/config"
+	"thermostat/db/mode"
+	"thermostat/db/setting"
+	"thermostat/db/zone"
+	"time"
+)
+
+func TestRecovery_Observe(t *testing.T) {
+	t.Parallel()
+
+	r := recovery{rate: 0.5, rates: make(map[Demand]float64)}
+	now := time.Now()
+
+	// runs shorter than minRecoveryRun aren't learned from
+	assert.Equal(t, Idle, r.observe(now, 65, Heating))
+	assert.Equal(t, Idle, r.observe(now.Add(5*time.Minute), 66, Idle))
+	assert.Empty(t, r.rates)
+
+	// the first run is taken as is
+	now = now.Add(time.Hour)
+	r.observe(now, 65, Heating)
+	assert.Equal(t, Heating, r.observe(now.Add(30*time.Minute), 67, Idle))
+	assert.Equal(t, float64(4), r.rates[Heating])
+
+	// later runs are blended in
+	now = now.Add(time.Hour)
+	r.observe(now, 65, Heating)
+	assert.Equal(t, Heating, r.observe(now.Add(time.Hour), 67, Idle))
+	assert.Equal(t, float64(3), r.rates[Heating])
+
+	// cooling is learned separately, and runs that lose ground are ignored
+	now = now.Add(time.Hour)
+	r.observe(now, 78, Cooling)
+	assert.Equal(t, Idle, r.observe(now.Add(time.Hour), 79, Idle))
+	r.observe(now.Add(2*time.Hour), 78, Cooling)
+	assert.Equal(t, Cooling, r.observe(now.Add(3*time.Hour), 77, Idle))
+	assert.Equal(t, float64(1), r.rates[Cooling])
+	assert.Equal(t, float64(3), r.rates[Heating])
+}
+
+func TestRecovery_Lead(t *testing.T) {
+	t.Parallel()
+
+	r := recovery{rates: map[Demand]float64{Heating: 2}}
+	lead, ok := r.lead(Heating, 68, 71, 4*time.Hour)
+	assert.True(t, ok)
+	assert.Equal(t, 90*time.Minute, lead)
+	lead, ok = r.lead(Heating, 60, 71, 4*time.Hour)
+	assert.True(t, ok)
+	assert.Equal(t, 4*time.Hour, lead)
+	_, ok = r.lead(Cooling, 78, 75, 4*time.Hour)
+	assert.False(t, ok)
+}
+
+func TestZone_PlanRecovery(t *testing.T) {
+	config.Ready()
+	ctx := context.Background()
+	y, month, d := time.Now().Date()
+	now := time.Date(y, month, d, 10, 0, 0, 0, time.Local)
+
+	z, err := zone.New(ctx, t.Name())
+	require.NoError(t, err)
+	away, err := mode.New(ctx, z.ID, "away", 60, 85, 1, mode.FanAuto, 0, 0, 0, 0)
+	require.NoError(t, err)
+	home, err := mode.New(ctx, z.ID, "home", 70, 76, 1, mode.FanAuto, 0, 0, 0, 0)
+	require.NoError(t, err)
+	defaultSetting, err := setting.New(ctx, z.ID, away.ID, setting.DEFAULT, 255, time.Unix(0, 0), time.Unix(7258118400, 0), 0, 86400)
+	require.NoError(t, err)
+	scheduled, err := setting.New(ctx, z.ID, home.ID, setting.SCHEDULED, 255, time.Unix(0, 0), time.Unix(7258118400, 0), 11*3600, 12*3600)
+	require.NoError(t, err)
+	schedules := []setting.Setting{defaultSetting, scheduled}
+
+	tests := []struct {
+		name       string
+		rates      map[Demand]float64
+		temp       float64
+		recovering bool
+	}{
+		{"nothing learned", map[Demand]float64{}, 65, false},
+		{"slow heat", map[Demand]float64{Heating: 2}, 65, true},
+		{"fast heat", map[Demand]float64{Heating: 10}, 65, false},
+		{"already warm", map[Demand]float64{Heating: 2}, 72, false},
+		{"slow AC", map[Demand]float64{Cooling: 1}, 80, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zn := &Zone{zoneID: z.ID, setting: defaultSetting, rates: recovery{rates: tt.rates}}
+			r, m := zn.planRecovery(ctx, schedules, now, tt.temp, away)
+			if !tt.recovering {
+				assert.Nil(t, r)
+				assert.Equal(t, away.ID, m.ID)
+				return
+			}
+			require.NotNil(t, r)
+			assert.Equal(t, scheduled.ID, r.ScheduleID)
+			assert.Equal(t, home.ID, r.ModeID)
+			assert.Equal(t, now.Add(time.Hour), r.Start)
+			assert.Equal(t, home.ID, m.ID)
+		})
+	}
+
+	// a recovery continues until the schedule begins, even once the temperature is in range
+	zn := &Zone{zoneID: z.ID, setting: defaultSetting, rates: recovery{rates: map[Demand]float64{Heating: 2}}}
+	zn.recovery, _ = zn.planRecovery(ctx, schedules, now, 65, away)
+	require.NotNil(t, zn.recovery)
+	r, m := zn.planRecovery(ctx, schedules, now.Add(30*time.Minute), 71, away)
+	assert.Equal(t, zn.recovery, r)
+	assert.Equal(t, home.ID, m.ID)
+}
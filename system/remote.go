@@ -0,0 +1,86 @@
+package system
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"strings"
+	"sync"
+	"thermostat/broker"
+	"thermostat/clock"
+	"thermostat/sensor"
+	"time"
+)
+
+var (
+	remotes      = make(map[string]*sensor.Remote)
+	remotesMutex sync.Mutex
+)
+
+// RemoteReading is a reading pushed by a remote sensor, over HTTP or MQTT
+type RemoteReading struct {
+	Temperature *float64
+	Humidity    *float64 // left out by sensors that don't measure humidity
+}
+
+// Push records the reading for s
+func (r RemoteReading) Push(s *sensor.Remote) error {
+	if r.Temperature == nil {
+		return errors.New("a temperature is required")
+	}
+	s.Push(*r.Temperature, r.Humidity)
+	return nil
+}
+
+// RunningRemoteSensor returns the named remote sensor if a zone or the outdoor temperature uses it. Names are not case
+// sensitive.
+func RunningRemoteSensor(name string) (*sensor.Remote, error) {
+	remotesMutex.Lock()
+	defer remotesMutex.Unlock()
+
+	if s, ok := remotes[strings.ToLower(name)]; ok {
+		return s, nil
+	}
+	return nil, fmt.Errorf("no remote sensor named %s is running", name)
+}
+
+// RemoteSensor returns the named remote sensor, building it from remoteSensors.<name> in the config and subscribing to
+// its MQTT topic the first time it's needed, when it keeps time with clk. Names are not case sensitive.
+func RemoteSensor(name string, clk clock.Clock) (*sensor.Remote, error) {
+	name = strings.ToLower(name)
+	remotesMutex.Lock()
+	defer remotesMutex.Unlock()
+
+	if s, ok := remotes[name]; ok {
+		return s, nil
+	}
+	key := "remoteSensors." + name
+	if name == "" || !viper.IsSet(key) {
+		return nil, fmt.Errorf("no remote sensor %s is configured", name)
+	}
+
+	key += "."
+	// the corrections are the sensor's first calibration, applied by the zone or room it's in
+	s := sensor.NewRemote(name, time.Second*time.Duration(configFloat(key+"maxAge", 300)), 0, 0, clk)
+	if topic := viper.GetString(key + "topic"); topic != "" {
+		err := broker.Subscribe(topic, func(_ mqtt.Client, msg mqtt.Message) {
+			var r RemoteReading
+			err := json.Unmarshal(msg.Payload(), &r)
+			if err == nil {
+				err = r.Push(s)
+			}
+			if err != nil {
+				logrus.WithError(err).WithField("topic", msg.Topic()).Warn("ignoring remote sensor reading")
+			}
+		})
+		if err != nil {
+			return nil, fmt.Errorf("remote sensor %s: %w", name, err)
+		}
+	}
+
+	remotes[name] = s
+	return s, nil
+}
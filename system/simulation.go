@@ -0,0 +1,45 @@
+package system
+
+import (
+	"github.com/spf13/viper"
+	"thermostat/clock"
+	"thermostat/relay"
+)
+
+// NewSimulatedHVAC returns an HVAC controller that keeps relay state in memory instead of driving real relays.
+// Second stage relays and accessories are simulated if they are configured for the zone.
+func NewSimulatedHVAC(zone string, clk clock.Clock) (*hvac, error) {
+	return newSimulatedHVAC(zoneKeys(zone), clk)
+}
+
+func newSimulatedHVAC(keys configKeys, clk clock.Clock) (*hvac, error) {
+	var ac2, heat2 relay.Relay
+	if installed(keys, "ac2") {
+		ac2 = relay.NewFake("ac2", nil)
+	}
+	if installed(keys, "heat2") {
+		heat2 = relay.NewFake("heat2", nil)
+	}
+
+	var acc Accessories
+	if installed(keys, "humidifier") {
+		acc.Humidifier = relay.NewFake("humidifier", nil)
+	}
+	if installed(keys, "dehumidifier") {
+		acc.Dehumidifier = relay.NewFake("dehumidifier", nil)
+	}
+	if installed(keys, "fanLow") {
+		acc.FanLow = relay.NewFake("fanLow", nil)
+	}
+
+	return NewHVAC(relay.NewFake("fan", nil), relay.NewFake("ac", nil), relay.NewFake("heat", nil), ac2, heat2, acc, clk)
+}
+
+// installed reports if the config has a relay or a pin for the named output
+func installed(keys configKeys, name string) bool {
+	if viper.IsSet(keys.hardware("relays." + name)) {
+		return true
+	}
+	pin := keys.hardware(name + "Pin")
+	return viper.IsSet(pin) && viper.GetInt(pin) >= 0
+}
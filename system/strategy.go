@@ -0,0 +1,95 @@
+package system
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"time"
+)
+
+// Demand is what a zone needs from its controller
+type Demand int
+
+const (
+	Idle Demand = iota
+	Cooling
+	Heating
+)
+
+func (d Demand) String() string {
+	switch d {
+	case Cooling:
+		return "cooling"
+	case Heating:
+		return "heating"
+	default:
+		return "idle"
+	}
+}
+
+// Range is the temperature range a zone is held in. Heat comes on below Min and AC above Max. Correction is how far
+// into the range the hysteresis strategy runs before shutting off.
+type Range struct {
+	Min        float64
+	Max        float64
+	Correction float64
+}
+
+// Strategy decides when a zone needs heat or AC. It is called once per monitoring cycle with the current temperature and
+// what is running, and may keep state between calls.
+type Strategy interface {
+	Demand(now time.Time, temp float64, r Range, running Demand) Demand
+}
+
+// NewStrategy builds the strategy described by the config section at key. The type field selects the strategy:
+// hysteresis (the default), pid, or anticipation.
+func NewStrategy(key string) (Strategy, error) {
+	switch t := viper.GetString(key + ".type"); t {
+	case "", "hysteresis":
+		return hysteresis{}, nil
+	case "pid":
+		return newPID(key), nil
+	case "anticipation":
+		return newAnticipation(key), nil
+	default:
+		return nil, fmt.Errorf("unknown strategy %s", t)
+	}
+}
+
+// hysteresis turns heat or AC on past the edge of the range, and off once it has run Correction degrees into the range
+type hysteresis struct{}
+
+func (hysteresis) Demand(_ time.Time, temp float64, r Range, running Demand) Demand {
+	return hysteresisDemand(temp, r, running, r.Min+r.Correction, r.Max-r.Correction)
+}
+
+// hysteresisDemand turns heat or AC on past the edge of r, and off at heatOff or coolOff
+func hysteresisDemand(temp float64, r Range, running Demand, heatOff, coolOff float64) Demand {
+	switch running {
+	case Cooling:
+		if temp <= coolOff {
+			return Idle
+		}
+		return Cooling
+	case Heating:
+		if temp >= heatOff {
+			return Idle
+		}
+		return Heating
+	default:
+		if temp > r.Max {
+			return Cooling
+		} else if temp < r.Min {
+			return Heating
+		}
+		return Idle
+	}
+}
+
+// configFloat returns the config value at key, or def if it isn't set. Strategies are configured per zone, so their
+// defaults can't be registered up front.
+func configFloat(key string, def float64) float64 {
+	if !viper.IsSet(key) {
+		return def
+	}
+	return viper.GetFloat64(key)
+}
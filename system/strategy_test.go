@@ -0,0 +1,56 @@
+package system
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestHysteresis(t *testing.T) {
+	t.Parallel()
+
+	r := Range{Min: 68, Max: 76, Correction: 1}
+	tests := []struct {
+		temp     float64
+		running  Demand
+		expected Demand
+	}{
+		{72, Idle, Idle},
+		{76, Idle, Idle},
+		{76.5, Idle, Cooling},
+		{75.5, Cooling, Cooling},
+		{75, Cooling, Idle},
+		{67.5, Idle, Heating},
+		{68.5, Heating, Heating},
+		{69, Heating, Idle},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test %d", i), func(t *testing.T) {
+			assert.Equal(t, tt.expected, hysteresis{}.Demand(time.Now(), tt.temp, r, tt.running))
+		})
+	}
+}
+
+func TestNewStrategy(t *testing.T) {
+	viper.Set("zones.strategyTest.strategy.type", "pid")
+	viper.Set("zones.strategyTest.strategy.kp", 2)
+	defer viper.Set("zones.strategyTest", nil)
+
+	s, err := NewStrategy("zones.strategyTest.strategy")
+	require.NoError(t, err)
+	require.IsType(t, &pid{}, s)
+	assert.Equal(t, float64(2), s.(*pid).kp)
+	assert.Equal(t, 0.01, s.(*pid).ki)
+
+	s, err = NewStrategy("zones.missing.strategy")
+	require.NoError(t, err)
+	assert.Equal(t, hysteresis{}, s)
+
+	viper.Set("zones.strategyTest.strategy.type", "magic")
+	_, err = NewStrategy("zones.strategyTest.strategy")
+	assert.Error(t, err)
+}
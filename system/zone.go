@@ -3,10 +3,20 @@ package system
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"math"
+	"reflect"
+	"sync"
+	"thermostat/clock"
+	"thermostat/config"
+	"thermostat/db/mode"
+	"thermostat/db/reading"
 	"thermostat/db/setting"
 	"thermostat/db/zone"
 	"thermostat/log"
+	"thermostat/sensor"
 	"time"
 )
 
@@ -15,50 +25,147 @@ type Sensor interface {
 	Humidity() float64
 }
 
+// CompositeSensor is implemented by sensors that combine several rooms' sensors
+type CompositeSensor interface {
+	SetRooms(rooms []string) // the rooms listed for the active schedule
+	Readings() []sensor.Reading
+}
+
 type Controller interface {
 	Fan() bool
-	SetFan(on bool) bool
+	SetFan(on bool) (bool, error)
 	AC() bool
-	SetAC(on bool) bool
+	SetAC(on bool) (bool, error)
+	AC2() bool
+	SetAC2(on bool) (bool, error)
 	Heat() bool
-	SetHeat(on bool) bool
-	Reset()
+	SetHeat(on bool) (bool, error)
+	Heat2() bool
+	SetHeat2(on bool) (bool, error)
+	Outputs() map[string]bool     // the state of every relay, by name
+	Pending() map[string]Deferred // switches waiting on protection timers, by call
+	Reset() error
+}
+
+// FanOverride runs the fan, or keeps it from running on its own, until a given time, whatever the mode's fan setting
+type FanOverride struct {
+	On    bool
+	Until time.Time
 }
 
 type Zone struct {
 	zoneID     int64
 	controller Controller
 	sensor     Sensor
+	strategy   Strategy
+	outdoor    *CachedOutdoor // nil if no source is configured
+	clock      clock.Clock
 	update     chan []setting.Setting
+	fan        chan struct{} // wakes the monitor when the fan override changes
+	stop       context.CancelFunc
+	stopped    chan struct{} // closed once the monitor has stopped
 
-	setting setting.Setting
+	// the monitor's state. The monitor takes mutex to change setting, reasons, recovery, and fault, which are read by
+	// other goroutines, and override is changed by OverrideFan
+	setting  setting.Setting
+	outputs  map[string]bool
+	reasons  map[string]string
+	override FanOverride
+	rates    recovery
+	recovery *Recovery
+	faults   faultDetector
+	fault    *Fault
+	mutex    sync.Mutex
 }
 
-func (z Zone) ID() int64 {
+func (z *Zone) ID() int64 {
 	return z.zoneID
 }
 
-func (z Zone) Controller() Controller {
+func (z *Zone) Controller() Controller {
 	return z.controller
 }
 
-func (z Zone) Sensor() Sensor {
+func (z *Zone) Sensor() Sensor {
 	return z.sensor
 }
 
-func (z Zone) Setting() setting.Setting {
+// Clock returns the clock the zone runs its schedules by
+func (z *Zone) Clock() clock.Clock {
+	return z.clock
+}
+
+func (z *Zone) Setting() setting.Setting {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
 	return z.setting
 }
 
-func (z Zone) Update(settings []setting.Setting) {
+func (z *Zone) Update(settings []setting.Setting) {
 	z.update <- settings
 }
 
+// Reasons explains why each output the zone called for is running, by output
+func (z *Zone) Reasons() map[string]string {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+	return z.reasons
+}
+
+// Recovery returns the schedule the zone is starting on early, or nil if it isn't
+func (z *Zone) Recovery() *Recovery {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+	return z.recovery
+}
+
+// Fault returns why the zone stopped trusting its sensor, or nil if it trusts it
+func (z *Zone) Fault() *Fault {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+	return z.fault
+}
+
+// Outdoor returns the last outdoor temperature fetched, and when it was fetched
+func (z *Zone) Outdoor() (float64, time.Time, error) {
+	if z.outdoor == nil {
+		return 0, time.Time{}, errors.New("no outdoor temperature source is configured")
+	}
+	return z.outdoor.Last()
+}
+
+// OverrideFan overrides the mode's fan setting until the given time. A zero time cancels the override. The monitor
+// applies it right away, without the caller waiting on it.
+func (z *Zone) OverrideFan(on bool, until time.Time) {
+	z.mutex.Lock()
+	z.override = FanOverride{On: on, Until: until}
+	z.mutex.Unlock()
+
+	select {
+	case z.fan <- struct{}{}:
+	default: // the monitor is already due to wake
+	}
+}
+
+// FanOverride returns the active fan override, or nil if there is none
+func (z *Zone) FanOverride() *FanOverride {
+	z.mutex.Lock()
+	override := z.override
+	z.mutex.Unlock()
+
+	if !z.clock.Now().Before(override.Until) {
+		return nil
+	}
+	return &override
+}
+
 func (z *Zone) monitor(ctx context.Context) {
 	defer func() {
 		if r := recover(); r != nil {
 			logrus.WithField("recovered", r).WithField("zone", z.zoneID).Error("monitoring panicked")
-			z.controller.Reset()
+			if err := z.controller.Reset(); err != nil {
+				logrus.WithError(err).WithField("zone", z.zoneID).Error("failed to reset controller")
+			}
 		}
 	}()
 
@@ -68,61 +175,396 @@ func (z *Zone) monitor(ctx context.Context) {
 		logrus.WithField("zoneID", z.ID()).WithError(err).Panic("unable to load settings to initialize zone monitoring")
 	}
 	if len(schedules) == 0 { // tests may delete all existing schedules. In that case, we need to wait for schedules to be provided
-		schedules = <-z.update
+		select {
+		case schedules = <-z.update:
+		case <-ctx.Done():
+			return
+		}
 	}
 
-	tick := time.NewTicker(interval)
+	tick := z.clock.NewTicker(interval)
+	defer tick.Stop()
 
-	var ac, heat bool
-	temp := z.sensor.Temperature()
+	var started time.Time    // when the first stage was engaged
+	var fanRan time.Duration // how long the fan has run this hour
+	var humidify, dehumidify bool
+	last := z.clock.Now()
+	temp, hum, measured := z.read(ctx, last)
+	// wait waits for the next cycle, returning false once the zone is stopped
+	wait := func() bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case schedules = <-z.update:
+		case <-z.fan:
+		case <-tick.C():
+			temp, hum, measured = z.read(ctx, z.clock.Now())
+		}
+		return true
+	}
 	for {
-		z.setting = currentSetting(schedules)
+		// schedules run on the configured timezone's wall clock
+		now := z.clock.Now().In(config.Location())
+		current := currentSetting(schedules, now)
+		z.mutex.Lock()
+		z.setting = current
+		z.mutex.Unlock()
+		if c, ok := z.sensor.(CompositeSensor); ok {
+			c.SetRooms(z.setting.Rooms)
+		}
 		mode := z.setting.Mode(ctx)
+		if !hourStart(now).Equal(hourStart(last)) {
+			fanRan = 0
+		} else if z.controller.Fan() {
+			fanRan += now.Sub(last)
+		}
+		last = now
+		reasons := make(map[string]string)
+
+		if z.fault != nil {
+			z.failsafe(now, temp, measured, reasons)
+			started, humidify, dehumidify = time.Time{}, false, false
+			z.mutex.Lock()
+			z.recovery, z.reasons = nil, reasons
+			z.mutex.Unlock()
+			z.logOutputs(temp, hum)
+			if !wait() {
+				return
+			}
+			continue
+		}
+		recovery, mode := z.planRecovery(ctx, schedules, now, temp, mode)
+		z.mutex.Lock()
+		z.recovery = recovery
+		z.mutex.Unlock()
+		// the controller may have applied deferred switches since the last cycle
+		ac, ac2, heat, heat2 := z.controller.AC(), z.controller.AC2(), z.controller.Heat(), z.controller.Heat2()
+
+		humidify, dehumidify = z.wantHumidity(mode, hum, humidify, dehumidify)
+		var humidifier, dehumidifier bool
+		humidity, hasHumidity := z.controller.(Humidity)
+		if hasHumidity {
+			// humidifying while cooling just makes the AC work harder
+			if humidifier = z.set("humidifier", humidity.SetHumidifier, humidify && !ac); humidifier {
+				reasons["humidifier"] = fmt.Sprintf("humidifying to %.0f%%", mode.MinHumidity+viper.GetFloat64("humidity.deadband"))
+			}
+			if dehumidifier = z.set("dehumidifier", humidity.SetDehumidifier, dehumidify); dehumidifier {
+				reasons["dehumidifier"] = fmt.Sprintf("dehumidifying to %.0f%%", mode.MaxHumidity-viper.GetFloat64("humidity.deadband"))
+			}
+		}
+		maxTemp := mode.MaxTemp
+		overcooling := dehumidify && !dehumidifier && overcool(mode) > 0
+		if overcooling {
+			maxTemp -= overcool(mode)
+		}
+
+		running := Idle
+		if ac {
+			running = Cooling
+		} else if heat {
+			running = Heating
+		}
+		if learned := z.rates.observe(now, temp, running); learned != Idle {
+			if err := zone.SetRate(ctx, z.zoneID, learned.String(), z.rates.rates[learned]); err != nil {
+				logrus.WithError(err).WithField("zone", z.zoneID).Error("failed to save learned rate")
+			}
+		}
+		demand := z.strategy.Demand(now, temp, Range{Min: mode.MinTemp, Max: maxTemp, Correction: mode.Correction}, running)
+
+		ac, heat = z.drive(ac, heat, demand)
 
 		switch {
+		case !ac && !heat:
+			started = time.Time{}
+		case started.IsZero():
+			started = now
+		}
+
+		if ac && !ac2 && wantStage2(temp-mode.MaxTemp, now.Sub(started)) {
+			ac2 = z.set("ac2", z.controller.SetAC2, true)
+		}
+		if heat && !heat2 && wantStage2(mode.MinTemp-temp, now.Sub(started)) {
+			heat2 = z.set("heat2", z.controller.SetHeat2, true)
+		}
+
+		switch {
+		case ac && overcooling:
+			reasons["ac"] = fmt.Sprintf("overcooling to %.1f to dehumidify", maxTemp-mode.Correction)
 		case ac:
-			if temp <= mode.MaxTemp-mode.Correction {
-				ac = z.controller.SetAC(false)
-			}
+			reasons["ac"] = fmt.Sprintf("cooling to %.1f", maxTemp-mode.Correction)
 		case heat:
-			if temp >= mode.MinTemp+mode.Correction {
-				heat = z.controller.SetHeat(false)
-			}
-		default:
-			if temp > mode.MaxTemp {
-				ac = z.controller.SetAC(true)
-			} else if temp < mode.MinTemp {
-				heat = z.controller.SetHeat(true)
+			reasons["heat"] = fmt.Sprintf("heating to %.1f", mode.MinTemp+mode.Correction)
+		}
+		if z.recovery != nil {
+			for _, output := range []string{"ac", "heat"} {
+				if reason, ok := reasons[output]; ok {
+					reasons[output] = fmt.Sprintf("%s for the schedule starting at %s", reason, z.recovery.Start.Format(time.Kitchen))
+				}
 			}
 		}
+		if ac && ac2 {
+			reasons["ac2"] = "the first stage needs help"
+		}
+		if heat && heat2 {
+			reasons["heat2"] = "the first stage needs help"
+		}
+		if hasHumidity {
+			// low speed removes more moisture
+			z.set("fanLow", humidity.SetFanLow, ac && overcooling)
+		}
 
-		log.Log(z.controller.Fan(), z.controller.AC(), z.controller.Heat(), temp, z.sensor.Humidity())
+		fan, reason := z.wantFan(mode, now, fanRan)
+		if !fan && (humidifier || dehumidifier) {
+			fan, reason = true, "moving air for humidity equipment"
+		}
+		z.set("fan", z.controller.SetFan, fan)
+		if fan {
+			reasons["fan"] = reason
+		}
+		z.mutex.Lock()
+		z.reasons = reasons
+		z.mutex.Unlock()
+		z.logOutputs(temp, hum)
+		if !wait() {
+			return
+		}
+	}
+}
 
-		select {
-		case schedules = <-z.update:
-		case <-tick.C:
-			temp = z.sensor.Temperature()
+// logOutputs logs the controller's outputs when they change, and records them in the activity log
+func (z *Zone) logOutputs(temp, hum float64) {
+	if outputs := z.controller.Outputs(); !reflect.DeepEqual(outputs, z.outputs) {
+		fields := logrus.Fields{"zone": z.zoneID}
+		for k, v := range outputs {
+			fields[k] = v
 		}
+		logrus.WithFields(fields).Info("outputs changed")
+		z.outputs = outputs
+	}
+
+	var outdoor *float64
+	if t, _, err := z.Outdoor(); err == nil {
+		outdoor = &t
+	}
+	log.Log(z.controller.Fan(), z.controller.AC(), z.controller.Heat(), temp, hum, outdoor)
+}
+
+// read reads the zone's sensor, raising a fault if the reading can't be trusted, or clearing it once it can. measured is
+// false if the sensor failed to read at all.
+func (z *Zone) read(ctx context.Context, now time.Time) (temp, hum float64, measured bool) {
+	r, err := sensor.Read(z.sensor, z.clock)
+	if c, ok := z.sensor.(CompositeSensor); ok {
+		z.record(ctx, now, c.Readings(), r, err)
+	}
+	reason := z.faults.check(now, r, err)
+	fault := z.fault
+	switch {
+	case reason != "" && fault == nil:
+		logrus.WithFields(logrus.Fields{"zone": z.zoneID, "reason": reason}).Error("sensor fault")
+		fault = &Fault{Reason: reason, Since: now}
+	case reason != "":
+		fault = &Fault{Reason: reason, Since: fault.Since}
+	case fault != nil:
+		logrus.WithFields(logrus.Fields{"zone": z.zoneID, "since": fault.Since.String()}).Warn("sensor fault cleared")
+		fault = nil
+	}
+	z.mutex.Lock()
+	z.fault = fault
+	z.mutex.Unlock()
+	return r.Temperature, r.Humidity, err == nil
+}
+
+// record stores each of a composite sensor's readings along with the combined reading, and forgets the zone's readings
+// from more than sensor.history days ago
+func (z *Zone) record(ctx context.Context, now time.Time, rooms []sensor.Reading, combined sensor.Reading, err error) {
+	readings := make([]reading.Reading, 0, len(rooms)+1)
+	for _, r := range rooms {
+		readings = append(readings, reading.Reading{Sensor: r.Name, Temperature: r.Temperature, Humidity: r.Humidity, Used: r.Used, Error: r.Error, Time: r.Time})
+	}
+	all := reading.Reading{Temperature: combined.Temperature, Humidity: combined.Humidity, Used: err == nil, Time: combined.Time}
+	if err != nil {
+		all.Error = err.Error()
+	}
+	readings = append(readings, all)
+
+	if err := reading.Add(ctx, z.zoneID, now, readings); err != nil {
+		logrus.WithError(err).WithField("zone", z.zoneID).Error("failed to record readings")
+	}
+	if err := reading.Prune(ctx, z.zoneID, now.AddDate(0, 0, -viper.GetInt("sensor.history"))); err != nil {
+		logrus.WithError(err).WithField("zone", z.zoneID).Error("failed to forget old readings")
+	}
+}
+
+// failsafe holds the zone in its safe state while its sensor can't be trusted. Everything is off, unless sensor.failsafe
+// is freeze, which runs the heat for sensor.freezeRuntime at the start of each hour. The heat stays off while the
+// suspect reading temp is above sensor.freezeTemp, unless the sensor didn't measure anything.
+func (z *Zone) failsafe(now time.Time, temp float64, measured bool, reasons map[string]string) {
+	heat := viper.GetString("sensor.failsafe") == "freeze" &&
+		now.Sub(hourStart(now)) < time.Second*time.Duration(viper.GetInt("sensor.freezeRuntime")) &&
+		(!measured || temp <= viper.GetFloat64("sensor.freezeTemp"))
+
+	z.set("ac2", z.controller.SetAC2, false)
+	z.set("ac", z.controller.SetAC, false)
+	z.set("heat2", z.controller.SetHeat2, false)
+	if z.set("heat", z.controller.SetHeat, heat) && heat {
+		reasons["heat"] = "freeze protection while the sensor is faulted"
+	}
+	z.set("fan", z.controller.SetFan, false)
+	if humidity, ok := z.controller.(Humidity); ok {
+		z.set("humidifier", humidity.SetHumidifier, false)
+		z.set("dehumidifier", humidity.SetDehumidifier, false)
+		z.set("fanLow", humidity.SetFanLow, false)
+	}
+}
+
+// drive switches heat or AC to meet demand, given whether each is running, and returns whether each is running now
+func (z *Zone) drive(ac, heat bool, demand Demand) (bool, bool) {
+	switch {
+	case ac && demand != Cooling:
+		ac = z.set("ac", z.controller.SetAC, false)
+	case heat && demand != Heating:
+		heat = z.set("heat", z.controller.SetHeat, false)
+	case ac:
+		// the demand is back, so drop any switch off still waiting on protection timers
+		ac = z.set("ac", z.controller.SetAC, true)
+	case heat:
+		heat = z.set("heat", z.controller.SetHeat, true)
+	case demand == Cooling:
+		ac = z.set("ac", z.controller.SetAC, true)
+	case demand == Heating:
+		heat = z.set("heat", z.controller.SetHeat, true)
+	default:
+		// the zone is satisfied, so drop any demand still waiting on protection timers
+		z.set("ac", z.controller.SetAC, false)
+		z.set("heat", z.controller.SetHeat, false)
+	}
+	return ac, heat
+}
+
+// set switches an output using f, logging any failure, and returns the state of the output.
+// Switches deferred by protection timers are left to the controller to retry.
+func (z *Zone) set(output string, f func(on bool) (bool, error), on bool) bool {
+	state, err := f(on)
+	if errors.As(err, new(*Deferred)) {
+		logrus.WithError(err).WithField("zone", z.zoneID).Debug("output switch deferred")
+	} else if err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"zone":   z.zoneID,
+			"output": output,
+			"on":     on,
+		}).Error("failed to switch output")
+	}
+	return state
+}
+
+// wantFan decides if the fan should run on its own, apart from running with heat or AC, and why. ran is how long the fan
+// has already run this hour. Circulation is put off until late in the hour, so time the fan spends running with heat or
+// AC counts towards it.
+func (z *Zone) wantFan(m mode.Mode, now time.Time, ran time.Duration) (bool, string) {
+	z.mutex.Lock()
+	override := z.override
+	z.mutex.Unlock()
+	if now.Before(override.Until) {
+		return override.On, "fan override"
+	}
+
+	switch m.Fan {
+	case mode.FanOn:
+		return true, "fan mode is on"
+	case mode.FanCirculate:
+		need := time.Minute*time.Duration(m.Circulate) - ran
+		left := hourStart(now).Add(time.Hour).Sub(now)
+		return need > 0 && left <= need, "circulating"
+	default:
+		return false, ""
+	}
+}
+
+// wantHumidity decides if the zone should humidify or dehumidify. Zones whose sensors don't measure humidity read 0%,
+// so they never call for either.
+func (z *Zone) wantHumidity(m mode.Mode, hum float64, humidifying, dehumidifying bool) (humidify, dehumidify bool) {
+	if !sensor.HasHumidity(z.sensor) {
+		return false, false
+	}
+	return humidityCall(m, hum, humidifying, dehumidifying)
+}
+
+// humidityCall decides if m calls for humidifying or dehumidifying at humidity hum. Once called for, each continues until
+// the humidity is back inside the mode's range by the humidity deadband.
+func humidityCall(m mode.Mode, hum float64, humidifying, dehumidifying bool) (humidify, dehumidify bool) {
+	band := viper.GetFloat64("humidity.deadband")
+	if m.MinHumidity > 0 {
+		humidify = hum < m.MinHumidity || (humidifying && hum < m.MinHumidity+band)
+	}
+	if m.MaxHumidity > 0 {
+		dehumidify = hum > m.MaxHumidity || (dehumidifying && hum > m.MaxHumidity-band)
+	}
+	return humidify, dehumidify
+}
+
+// overcool returns how far below the mode's range the AC may cool to dehumidify.
+// It is bounded so the AC never cools into the range where heat would come on.
+func overcool(m mode.Mode) float64 {
+	return math.Max(0, math.Min(m.Overcool, m.MaxTemp-m.MinTemp-2*m.Correction))
+}
+
+// hourStart returns the start of the hour containing t
+func hourStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+}
+
+// wantStage2 decides if a second stage should be engaged. overshoot is how far the temperature is outside the mode's range,
+// and runtime is how long the first stage has been running without satisfying the zone.
+// Once engaged, the second stage runs until the first stage is satisfied.
+func wantStage2(overshoot float64, runtime time.Duration) bool {
+	if runtime >= time.Second*time.Duration(viper.GetInt("stage2.delay")) {
+		return true
 	}
+	return overshoot >= viper.GetFloat64("stage2.threshold")
 }
 
-var zones = make(map[int64]*Zone)
+var (
+	zones      = make(map[int64]*Zone)
+	zonesMutex sync.RWMutex
+)
 
-func NewZone(ctx context.Context, name string, controller Controller, sensor Sensor) (Zone, error) {
+// NewZone returns the named zone, running its schedules by clk. Its control strategy is configured by
+// zones.<name>.strategy.
+func NewZone(ctx context.Context, name string, controller Controller, sensor Sensor, clk clock.Clock) (*Zone, error) {
 	z, err := zone.Get(ctx, name)
 	if err != nil {
-		return Zone{}, err
+		return nil, err
+	}
+	strategy, err := NewStrategy("zones." + name + ".strategy")
+	if err != nil {
+		return nil, err
+	}
+	rates, err := loadRecovery(ctx, z.ID)
+	if err != nil {
+		return nil, err
+	}
+	outdoor, err := LoadOutdoor(clk)
+	if err != nil {
+		return nil, err
 	}
 
-	return Zone{
+	return &Zone{
 		zoneID:     z.ID,
 		controller: controller,
 		sensor:     sensor,
+		strategy:   strategy,
+		outdoor:    outdoor,
+		clock:      clk,
+		rates:      rates,
+		faults:     newFaultDetector(),
 		update:     make(chan []setting.Setting),
+		fan:        make(chan struct{}, 1),
 	}, nil
 }
 
 func GetZone(id int64) (*Zone, error) {
+	zonesMutex.RLock()
+	defer zonesMutex.RUnlock()
 	if z, ok := zones[id]; ok {
 		return z, nil
 	}
@@ -130,14 +572,27 @@ func GetZone(id int64) (*Zone, error) {
 }
 
 func (z *Zone) Startup() {
+	zonesMutex.Lock()
 	zones[z.zoneID] = z
+	zonesMutex.Unlock()
 
+	ctx, cancel := context.WithCancel(context.Background())
+	z.stop, z.stopped = cancel, make(chan struct{})
 	go func() {
-		lastPanic := time.Now()
+		defer close(z.stopped)
+		lastPanic := z.clock.Now()
 		for {
-			z.controller.Reset()
-			z.monitor(context.Background())
-			now := time.Now()
+			if err := z.controller.Reset(); err != nil {
+				logrus.WithError(err).WithField("zone", z.zoneID).Error("failed to reset controller")
+			}
+			z.monitor(ctx)
+			if ctx.Err() != nil {
+				if err := z.controller.Reset(); err != nil {
+					logrus.WithError(err).WithField("zone", z.zoneID).Error("failed to reset controller")
+				}
+				return
+			}
+			now := z.clock.Now()
 			if now.Sub(lastPanic) < time.Hour {
 				defer z.controller.Reset() // worth a try. Deferred in case it also causes a panic
 				logrus.WithField("timeSincePanic", now.Sub(lastPanic).String()).Fatal("exiting because panics are in close proximity. Not sure what state the system is in anymore...")
@@ -148,3 +603,13 @@ func (z *Zone) Startup() {
 		}
 	}()
 }
+
+// Stop stops monitoring the zone and turns its equipment off, waiting for the monitor to finish
+func (z *Zone) Stop() {
+	zonesMutex.Lock()
+	delete(zones, z.zoneID)
+	zonesMutex.Unlock()
+
+	z.stop()
+	<-z.stopped
+}
@@ -1,8 +1,21 @@
 package system
 
 import (
+	"context"
+	"fmt"
 	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"thermostat/clock"
 	"thermostat/config"
+	"thermostat/db/mode"
+	"thermostat/db/setting"
+	"thermostat/db/zone"
+	"thermostat/relay"
+	"thermostat/sensor"
+	"time"
 )
 
 func init() {
@@ -10,3 +23,470 @@ func init() {
 
 	viper.Set("maxTemp", 100)
 }
+
+func TestWantStage2(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		overshoot float64
+		runtime   time.Duration
+		expected  bool
+	}{
+		{0, 0, false},
+		{1, time.Minute, false},
+		{3, 0, true},
+		{-1, 10 * time.Minute, true},
+		{0, 9 * time.Minute, false},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test %d", i), func(t *testing.T) {
+			assert.Equal(t, tt.expected, wantStage2(tt.overshoot, tt.runtime))
+		})
+	}
+}
+
+func TestZone_WantFan(t *testing.T) {
+	t.Parallel()
+
+	hour := time.Date(2020, 6, 1, 14, 0, 0, 0, time.Local)
+	tests := []struct {
+		name     string
+		mode     mode.Mode
+		override FanOverride
+		now      time.Time
+		ran      time.Duration
+		expected bool
+	}{
+		{"auto", mode.Mode{Fan: mode.FanAuto}, FanOverride{}, hour, 0, false},
+		{"default is auto", mode.Mode{}, FanOverride{}, hour, 0, false},
+		{"on", mode.Mode{Fan: mode.FanOn}, FanOverride{}, hour, time.Hour, true},
+		{"circulate early in the hour", mode.Mode{Fan: mode.FanCirculate, Circulate: 15}, FanOverride{}, hour.Add(10 * time.Minute), 0, false},
+		{"circulate late in the hour", mode.Mode{Fan: mode.FanCirculate, Circulate: 15}, FanOverride{}, hour.Add(46 * time.Minute), 0, true},
+		{"circulate after running with heat", mode.Mode{Fan: mode.FanCirculate, Circulate: 15}, FanOverride{}, hour.Add(50 * time.Minute), 10 * time.Minute, false},
+		{"circulate satisfied", mode.Mode{Fan: mode.FanCirculate, Circulate: 15}, FanOverride{}, hour.Add(55 * time.Minute), 15 * time.Minute, false},
+		{"override on", mode.Mode{Fan: mode.FanAuto}, FanOverride{On: true, Until: hour.Add(time.Minute)}, hour, 0, true},
+		{"override off", mode.Mode{Fan: mode.FanOn}, FanOverride{On: false, Until: hour.Add(time.Minute)}, hour, 0, false},
+		{"override expired", mode.Mode{Fan: mode.FanOn}, FanOverride{On: false, Until: hour}, hour, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			z := &Zone{override: tt.override}
+			on, _ := z.wantFan(tt.mode, tt.now, tt.ran)
+			assert.Equal(t, tt.expected, on)
+		})
+	}
+}
+
+func TestZone_OverrideFan(t *testing.T) {
+	t.Parallel()
+
+	hour := time.Date(2020, 6, 1, 14, 0, 0, 0, time.Local)
+	z := &Zone{clock: clock.NewFake(hour), fan: make(chan struct{}, 1)}
+	// nothing is monitoring the zone, so these would block if the monitor had to take them
+	z.OverrideFan(true, hour.Add(time.Hour))
+	z.OverrideFan(false, hour.Add(time.Hour))
+	assert.Equal(t, &FanOverride{On: false, Until: hour.Add(time.Hour)}, z.FanOverride())
+	on, reason := z.wantFan(mode.Mode{Fan: mode.FanOn}, hour, 0)
+	assert.False(t, on)
+	assert.Equal(t, "fan override", reason)
+
+	z.OverrideFan(false, time.Time{})
+	assert.Nil(t, z.FanOverride())
+}
+
+func TestHumidityCall(t *testing.T) {
+	t.Parallel()
+
+	m := mode.Mode{MinHumidity: 30, MaxHumidity: 55}
+	tests := []struct {
+		name          string
+		mode          mode.Mode
+		hum           float64
+		humidifying   bool
+		dehumidifying bool
+		humidify      bool
+		dehumidify    bool
+	}{
+		{"disabled", mode.Mode{}, 90, false, false, false, false},
+		{"comfortable", m, 45, false, false, false, false},
+		{"dry", m, 25, false, false, true, false},
+		{"humidifying inside the deadband", m, 31, true, false, true, false},
+		{"humidifying satisfied", m, 33, true, false, false, false},
+		{"humid", m, 60, false, false, false, true},
+		{"dehumidifying inside the deadband", m, 54, false, true, false, true},
+		{"dehumidifying satisfied", m, 52, false, true, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			humidify, dehumidify := humidityCall(tt.mode, tt.hum, tt.humidifying, tt.dehumidifying)
+			assert.Equal(t, tt.humidify, humidify)
+			assert.Equal(t, tt.dehumidify, dehumidify)
+		})
+	}
+}
+
+func TestZone_DriveDemandReturns(t *testing.T) {
+	t.Parallel()
+
+	clk := clock.NewFake(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+	c := testHVAC(nil, clk)
+	c.ac.timing.minOn = 120 * time.Second
+	c.heat.timing.minOn = 120 * time.Second
+	z := &Zone{controller: c}
+
+	ac, _ := z.drive(false, false, Cooling)
+	require.True(t, ac)
+	ac, _ = z.drive(ac, false, Idle)
+	assert.True(t, ac, "cool.minOn defers the switch off")
+	require.Contains(t, c.Pending(), "ac")
+
+	// the demand returns before the timer expires
+	ac, _ = z.drive(ac, false, Cooling)
+	assert.True(t, ac)
+	assert.Empty(t, c.Pending())
+	clk.Advance(time.Hour)
+	assert.True(t, c.AC(), "the queued switch off mustn't run once the demand is back")
+
+	// the same for heat
+	require.NoError(t, c.Reset())
+	clk.Advance(time.Hour)
+	_, heat := z.drive(false, false, Heating)
+	require.True(t, heat)
+	_, heat = z.drive(false, heat, Cooling)
+	assert.True(t, heat)
+	require.Contains(t, c.Pending(), "heat")
+	_, heat = z.drive(false, heat, Heating)
+	assert.True(t, heat)
+	assert.Empty(t, c.Pending())
+	clk.Advance(time.Hour)
+	assert.True(t, c.Heat())
+}
+
+// thermometer only measures temperature
+type thermometer struct{}
+
+func (thermometer) Temperature() float64 {
+	return 70
+}
+
+func (thermometer) Humidity() float64 {
+	return 0
+}
+
+func (thermometer) HasHumidity() bool {
+	return false
+}
+
+func TestZone_WantHumidity(t *testing.T) {
+	t.Parallel()
+
+	m := mode.Mode{MinHumidity: 30, MaxHumidity: 55}
+
+	z := &Zone{sensor: thermometer{}}
+	humidify, dehumidify := z.wantHumidity(m, 0, false, false)
+	assert.False(t, humidify, "a sensor without humidity reads 0%, which mustn't run the humidifier")
+	assert.False(t, dehumidify)
+	humidify, _ = z.wantHumidity(m, 0, true, false)
+	assert.False(t, humidify, "humidifying stops if the sensor stops measuring humidity")
+
+	z = &Zone{sensor: &clockSensor{clock: clock.New()}}
+	humidify, _ = z.wantHumidity(m, 0, false, false)
+	assert.True(t, humidify)
+}
+
+func TestOvercool(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		mode     mode.Mode
+		expected float64
+	}{
+		{mode.Mode{MinTemp: 68, MaxTemp: 76, Correction: 1, Overcool: 2}, 2},
+		{mode.Mode{MinTemp: 72, MaxTemp: 75, Correction: 1, Overcool: 2}, 1},
+		{mode.Mode{MinTemp: 72, MaxTemp: 74, Correction: 1, Overcool: 2}, 0},
+		{mode.Mode{MinTemp: 68, MaxTemp: 76, Correction: 1}, 0},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test %d", i), func(t *testing.T) {
+			assert.Equal(t, tt.expected, overcool(tt.mode))
+		})
+	}
+}
+
+// clockSensor reads whatever temperature it's set to, at the clock's time. Given equipment, it models a house the
+// equipment heats and cools instead.
+type clockSensor struct {
+	clock     clock.Clock
+	temp      float64
+	equipment sensor.Equipment
+	read      time.Time // when the sensor was last read
+	mutex     sync.Mutex
+}
+
+func (s *clockSensor) Temperature() float64 {
+	r, _ := s.Read()
+	return r.Temperature
+}
+
+func (s *clockSensor) Humidity() float64 {
+	return 40
+}
+
+func (s *clockSensor) Read() (sensor.Reading, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.clock.Now()
+	if s.equipment != nil && !s.read.IsZero() {
+		s.temp = house(s.temp, s.read, now, s.equipment)
+	}
+	s.read = now
+	return sensor.Reading{Temperature: s.temp, Humidity: 40, Time: s.read}, nil
+}
+
+// set fixes the temperature, leaving any house the sensor modeled
+func (s *clockSensor) set(temp float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.temp = temp
+	s.equipment = nil
+}
+
+// steppedController holds the zone at the end of each cycle until the test takes it from cycled, or done is closed
+type steppedController struct {
+	Controller
+	cycled chan struct{}
+	done   chan struct{}
+}
+
+// Outputs is the last thing the zone asks the controller each cycle
+func (c steppedController) Outputs() map[string]bool {
+	select {
+	case c.cycled <- struct{}{}:
+	case <-c.done:
+	}
+	return c.Controller.Outputs()
+}
+
+// house returns the temperature at to of a house that was temp at from. It's 100 degrees outside from noon until 6pm,
+// and 30 otherwise, and each stage of heat or AC that's running moves the temperature 6 degrees an hour.
+func house(temp float64, from, to time.Time, e sensor.Equipment) float64 {
+	var rate float64
+	for _, on := range []bool{e.Heat(), e.Heat2()} {
+		if on {
+			rate += 6
+		}
+	}
+	for _, on := range []bool{e.AC(), e.AC2()} {
+		if on {
+			rate -= 6
+		}
+	}
+	for t := from; t.Before(to); t = t.Add(time.Minute) {
+		outdoor := 30.0
+		if h := t.Hour(); h >= 12 && h < 18 {
+			outdoor = 100
+		}
+		temp += (rate + 0.1*(outdoor-temp)) / 60
+	}
+	return temp
+}
+
+func TestZone_SimulatedWeek(t *testing.T) {
+	ctx := context.Background()
+	// the zone runs schedules in the configured timezone
+	defer func(tz string) {
+		viper.Set("timezone", tz)
+		require.NoError(t, config.LoadLocation())
+	}(viper.GetString("timezone"))
+	viper.Set("timezone", "America/Chicago")
+	require.NoError(t, config.LoadLocation())
+	// without recovery, heat and AC only start on the schedule in effect
+	defer viper.Set("recovery.maxLead", viper.GetInt("recovery.maxLead"))
+	viper.Set("recovery.maxLead", 0)
+	loc := config.Location()
+	start := time.Date(2020, 1, 5, 0, 0, 0, 0, loc) // Sunday
+	clk := clock.NewFake(start)
+
+	z, err := zone.New(ctx, t.Name())
+	require.NoError(t, err)
+	away, err := mode.New(ctx, z.ID, "away", 60, 85, 1, mode.FanAuto, 0, 0, 0, 0)
+	require.NoError(t, err)
+	day, err := mode.New(ctx, z.ID, "day", 68, 76, 1, mode.FanAuto, 0, 0, 0, 0)
+	require.NoError(t, err)
+	late, err := mode.New(ctx, z.ID, "late", 65, 80, 1, mode.FanAuto, 0, 0, 0, 0)
+	require.NoError(t, err)
+	modes := map[int64]mode.Mode{away.ID: away, day.ID: day, late.ID: late}
+	weekdays := 0
+	for d := time.Monday; d <= time.Friday; d++ {
+		weekdays |= setting.WeekdayMask(d)
+	}
+	_, err = setting.New(ctx, z.ID, away.ID, setting.DEFAULT, 255, time.Unix(0, 0), time.Unix(7258118400, 0), 0, 86400)
+	require.NoError(t, err)
+	_, err = setting.New(ctx, z.ID, day.ID, setting.SCHEDULED, weekdays, time.Unix(0, 0), time.Unix(7258118400, 0), 6*3600, 22*3600)
+	require.NoError(t, err)
+	_, err = setting.New(ctx, z.ID, late.ID, setting.SCHEDULED, setting.WeekdayMask(time.Saturday), time.Unix(0, 0), time.Unix(7258118400, 0), 22*3600, 10*3600)
+	require.NoError(t, err)
+
+	c, err := NewHVAC(relay.NewFake("fan", nil), relay.NewFake("ac", nil), relay.NewFake("heat", nil), relay.NewFake("ac2", nil), relay.NewFake("heat2", nil), Accessories{}, clk)
+	require.NoError(t, err)
+	s := &clockSensor{clock: clk, temp: 70, equipment: c}
+	stepped := steppedController{Controller: c, cycled: make(chan struct{}), done: make(chan struct{})}
+	zn, err := NewZone(ctx, t.Name(), stepped, s, clk)
+	require.NoError(t, err)
+	zn.Startup()
+	defer zn.Stop()
+	defer close(stepped.done)
+	<-stepped.cycled
+
+	// step advances the clock a minute, and waits for the zone to finish acting on the tick. The zone waits for the next
+	// tick once the test takes its cycle, so it never sees the new time with the last reading.
+	step := func() {
+		clk.Advance(time.Minute)
+		<-stepped.cycled
+	}
+
+	expected := func(now time.Time) int64 {
+		minute := now.Hour()*60 + now.Minute()
+		switch {
+		case now.Weekday() >= time.Monday && now.Weekday() <= time.Friday && minute >= 6*60 && minute <= 22*60:
+			return day.ID
+		case now.Weekday() == time.Saturday && minute >= 22*60, now.Weekday() == time.Sunday && minute <= 10*60:
+			return late.ID
+		default:
+			return away.ID
+		}
+	}
+
+	// what runs at known times. The house is cold in the mornings and warm in the afternoons, so the heat and AC cycle
+	// every day, and stages switch for schedule changes, stage2.threshold, and stage2.delay, and wait out protection
+	// timers.
+	known := map[string]map[string]bool{
+		"Mon 06:00": {"heat": true, "heat2": true}, // the day schedule starts more than stage2.threshold below its range
+		"Mon 10:01": {"heat": true},
+		"Mon 10:10": {"heat": true},
+		"Mon 10:11": {"heat": true, "heat2": true}, // the first stage has run for stage2.delay
+		"Mon 10:16": {},
+		"Mon 14:42": {"ac": true},
+		"Mon 14:51": {"ac": true},
+		"Mon 14:52": {"ac": true, "ac2": true},
+		"Mon 14:55": {},
+		"Mon 22:01": {}, // the away schedule lets the house drift
+		"Tue 05:59": {}, // the overnight cycle just ended
+		"Tue 06:00": {}, // the day schedule wants heat, but it waits out heat.minOff
+		"Tue 06:01": {"heat": true, "heat2": true},
+	}
+	fan := map[string]bool{
+		"Mon 10:01": false, // heat.fanLead
+		"Mon 10:02": true,
+		"Mon 10:16": true, // heat.fanOverrun
+		"Mon 10:17": false,
+	}
+
+	var heatCycles, coolCycles int
+	var heating, cooling bool
+	for clk.Now().Before(start.AddDate(0, 0, 7)) {
+		step()
+		now := clk.Now()
+		at := now.Format(time.RFC1123)
+		require.Equal(t, expected(now), zn.Setting().ModeID, "at %s", at)
+		require.False(t, c.AC() && c.Heat(), "at %s", at)
+		require.False(t, c.AC2() && !c.AC() || c.Heat2() && !c.Heat(), "second stages only run with the first, at %s", at)
+
+		// once a mode has had an hour to take hold, the house stays near its range
+		if m := modes[expected(now)]; expected(now.Add(-time.Hour)) == m.ID {
+			temp, _ := s.Read()
+			require.InDelta(t, (m.MinTemp+m.MaxTemp)/2, temp.Temperature, (m.MaxTemp-m.MinTemp)/2+1, "at %s", at)
+		}
+
+		if c.Heat() && !heating {
+			heatCycles++
+		}
+		if c.AC() && !cooling {
+			coolCycles++
+		}
+		heating, cooling = c.Heat(), c.AC()
+
+		if want, ok := known[now.Format("Mon 15:04")]; ok {
+			got := make(map[string]bool)
+			for name, on := range c.Outputs() {
+				if on && name != "fan" {
+					got[name] = true
+				}
+			}
+			assert.Equal(t, want, got, "at %s", at)
+		}
+		if want, ok := fan[now.Format("Mon 15:04")]; ok {
+			assert.Equal(t, want, c.Fan(), "the fan at %s", at)
+		}
+		if now.Format("Mon 15:04") == "Tue 06:00" {
+			require.Contains(t, c.Pending(), "heat")
+			assert.Equal(t, now.Add(time.Minute), c.Pending()["heat"].Until)
+		}
+	}
+	assert.GreaterOrEqual(t, heatCycles, 7, "the heat runs every night")
+	assert.GreaterOrEqual(t, coolCycles, 5, "the AC runs every weekday afternoon")
+
+	// the compressor rests for cool.minOff after stopping, even when the zone wants it right back
+	for clk.Now().Before(time.Date(2020, 1, 13, 12, 0, 0, 0, loc)) {
+		step()
+	}
+	s.set(73)
+	step()
+	require.Equal(t, day.ID, zn.Setting().ModeID)
+	s.set(77)
+	step()
+	assert.True(t, c.AC())
+	assert.False(t, c.AC2())
+	step()
+	assert.True(t, c.Fan(), "the fan follows the AC after cool.fanLead")
+
+	s.set(74.5)
+	step()
+	assert.False(t, c.AC())
+	stopped := clk.Now()
+
+	s.set(77)
+	step()
+	assert.False(t, c.AC())
+	require.Contains(t, c.Pending(), "ac")
+	assert.Equal(t, stopped.Add(120*time.Second), c.Pending()["ac"].Until)
+
+	step()
+	assert.True(t, c.AC(), "the AC restarts once cool.minOff has passed")
+	assert.Empty(t, c.Pending())
+
+	// the second stage joins once the first has run for stage2.delay without satisfying the zone
+	s.set(74.5)
+	step()
+	require.False(t, c.AC())
+	for i := 0; i < 3; i++ {
+		step() // past cool.minOff, so the AC starts as soon as it's wanted
+	}
+	s.set(77)
+	step()
+	require.True(t, c.AC())
+	require.Empty(t, c.Pending())
+	for i := 0; i < 9; i++ {
+		step()
+	}
+	assert.False(t, c.AC2())
+	step()
+	assert.True(t, c.AC2(), "at %s", clk.Now().Format(time.RFC1123))
+	stage2 := clk.Now()
+
+	// once the first stage is satisfied both stop, but the second stage runs for cool.stage2.minOn first
+	s.set(74.5)
+	step()
+	assert.True(t, c.AC() && c.AC2())
+	require.Contains(t, c.Pending(), "ac")
+	assert.Equal(t, stage2.Add(120*time.Second), c.Pending()["ac"].Until)
+	step()
+	assert.False(t, c.AC() || c.AC2())
+	assert.Empty(t, c.Pending())
+}
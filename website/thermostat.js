@@ -101,6 +101,13 @@ async function refreshZonePage() {
     } else {
         $("#heat")[0].setAttribute("class", "relayOff");
     }
+
+    let pending = [];
+    for (const [call, deferred] of Object.entries(data.Pending || {})) {
+        let seconds = Math.max(0, Math.round((new Date(deferred.Until) - new Date()) / 1000));
+        pending.push(call + (deferred.On ? " on" : " off") + " in " + seconds + "s");
+    }
+    $("#pending")[0].innerHTML = pending.join(", ");
 }
 
 let zoneInterval;
@@ -173,6 +180,11 @@ async function editMode(id) {
     $("#minTemp")[0].value = v.minTemp;
     $("#maxTemp")[0].value = v.maxTemp;
     $("#offset")[0].value = v.correction;
+    $("#fan")[0].value = v.fan;
+    $("#circulate")[0].value = v.circulate;
+    $("#minHumidity")[0].value = v.minHumidity;
+    $("#maxHumidity")[0].value = v.maxHumidity;
+    $("#overcool")[0].value = v.overcool;
 }
 
 async function refreshModesPage() {
@@ -422,7 +434,12 @@ function addModeForm() {
         Name: data.name,
         MinTemp: parseFloat(data.minTemp),
         MaxTemp: parseFloat(data.maxTemp),
-        Correction: parseFloat(data.offset)
+        Correction: parseFloat(data.offset),
+        Fan: data.fan,
+        Circulate: parseInt(data.circulate),
+        MinHumidity: parseFloat(data.minHumidity),
+        MaxHumidity: parseFloat(data.maxHumidity),
+        Overcool: parseFloat(data.overcool)
     };
 
     request("/v1/mode/add", req).done(function(data) {
@@ -447,7 +464,12 @@ function editModeForm() {
         Name: data.name,
         MinTemp: parseFloat(data.minTemp),
         MaxTemp: parseFloat(data.maxTemp),
-        Correction: parseFloat(data.offset)
+        Correction: parseFloat(data.offset),
+        Fan: data.fan,
+        Circulate: parseInt(data.circulate),
+        MinHumidity: parseFloat(data.minHumidity),
+        MaxHumidity: parseFloat(data.maxHumidity),
+        Overcool: parseFloat(data.overcool)
     };
 
     request("/v1/mode/edit", req).done(function(data) {